@@ -0,0 +1,11 @@
+// Package sacmoney holds the web server's templates and static files, so the
+// server binary carries them with it and runs from any directory.
+package sacmoney
+
+import "embed"
+
+//go:embed templates
+var Templates embed.FS
+
+//go:embed static
+var Static embed.FS
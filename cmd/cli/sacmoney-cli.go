@@ -2,10 +2,19 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
 	cli "tjdickerson/sacmoney/pkg/cli"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := cli.RunCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Printf("sacmoney\n")
 	cli.Run()
 }
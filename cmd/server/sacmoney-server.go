@@ -1,9 +1,21 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"log"
+	"os"
 	server "tjdickerson/sacmoney/pkg/server"
 )
 
 func main() {
-	server.Run()
+	cfg, err := server.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server.Run(cfg)
 }
@@ -2,4 +2,10 @@ module tjdickerson/sacmoney
 
 go 1.22.5
 
-require github.com/mattn/go-sqlite3 v1.14.22
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
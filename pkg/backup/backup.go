@@ -0,0 +1,491 @@
+// Package backup copies a whole data directory into a single JSON archive
+// and rebuilds a data directory from one.
+//
+// The archive is one JSON object:
+//
+//	{
+//	  "format": "sacmoney-backup",
+//	  "version": 1,
+//	  "created": "2026-10-19T17:04:05Z",
+//	  "store": <database>,
+//	  "periods": [{"year": 2026, "month": 10, <database>...}],
+//	  "attachments": [{"name": "receipt.pdf", "data": "<base64>"}]
+//	}
+//
+// Each period file (accounts, transactions, splits, recurrings and
+// categories for one month) and the store (settings that outlive a period,
+// such as import mappings and rules) is written as a database:
+//
+//	{
+//	  "file": "2026October.db",
+//	  "tables": [{
+//	    "name": "accounts",
+//	    "sql": "CREATE TABLE accounts (...)",
+//	    "columns": ["id", "name", "kind"],
+//	    "rows": [[1, "Checking", "asset"]]
+//	  }],
+//	  "indexes": ["CREATE INDEX ..."]
+//	}
+//
+// Tables keep the statement SQLite created them with, so a restore
+// reproduces the schema exactly, including columns added by later versions.
+// Rows are in rowid order. Cells are JSON numbers, strings or null; blobs
+// are written as {"base64": "..."}. Attachments are the files kept under
+// the data directory's attachments folder.
+//
+// The version changes whenever an older restore could not read the archive
+// correctly. Restore refuses archives newer than it understands.
+package backup
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+const (
+	Format  = "sacmoney-backup"
+	Version = 1
+
+	AttachmentsDirectory = "attachments"
+)
+
+type Archive struct {
+	Format      string       `json:"format"`
+	Version     int          `json:"version"`
+	Created     time.Time    `json:"created"`
+	Store       *Database    `json:"store"`
+	Periods     []Period     `json:"periods"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+type Period struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	Database
+}
+
+type Database struct {
+	File    string   `json:"file"`
+	Tables  []Table  `json:"tables"`
+	Indexes []string `json:"indexes"`
+}
+
+type Table struct {
+	Name    string   `json:"name"`
+	Sql     string   `json:"sql"`
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+type Attachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type blob struct {
+	Base64 string `json:"base64"`
+}
+
+// Create reads every period, the store and any attachments in dir.
+func Create(dir string) (*Archive, error) {
+	archive := &Archive{
+		Format:      Format,
+		Version:     Version,
+		Created:     time.Now().UTC().Truncate(time.Second),
+		Periods:     []Period{},
+		Attachments: []Attachment{},
+	}
+
+	periods, err := db.ListPeriods(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range periods {
+		d, err := dumpDatabase(filepath.Join(dir, p.FileName()))
+		if err != nil {
+			return nil, err
+		}
+		archive.Periods = append(archive.Periods, Period{Year: p.Year, Month: int(p.Month), Database: *d})
+	}
+
+	storePath := filepath.Join(dir, db.StoreFileName)
+	if _, err = os.Stat(storePath); err == nil {
+		if archive.Store, err = dumpDatabase(storePath); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, AttachmentsDirectory))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading attachments: %s", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, AttachmentsDirectory, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading attachment %s: %s", e.Name(), err)
+		}
+		archive.Attachments = append(archive.Attachments, Attachment{Name: e.Name(), Data: data})
+	}
+
+	return archive, nil
+}
+
+func dumpDatabase(path string) (*Database, error) {
+	sdb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening %s: %s", path, err)
+	}
+
+	defer sdb.Close()
+
+	d := &Database{File: filepath.Base(path), Tables: []Table{}, Indexes: []string{}}
+
+	rows, err := sdb.Query(Q_SCHEMA)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading schema of %s: %s", path, err)
+	}
+
+	var kind, name string
+	var statement sql.NullString
+	for rows.Next() {
+		if err = rows.Scan(&kind, &name, &statement); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("Error reading schema of %s: %s", path, err)
+		}
+
+		if kind == "index" {
+			if statement.Valid {
+				d.Indexes = append(d.Indexes, statement.String)
+			}
+			continue
+		}
+
+		d.Tables = append(d.Tables, Table{Name: name, Sql: statement.String})
+	}
+	rows.Close()
+
+	for i := range d.Tables {
+		if err = dumpTable(sdb, &d.Tables[i]); err != nil {
+			return nil, fmt.Errorf("Error reading %s from %s: %s", d.Tables[i].Name, path, err)
+		}
+	}
+
+	return d, nil
+}
+
+func dumpTable(sdb *sql.DB, t *Table) error {
+	rows, err := sdb.Query(fmt.Sprintf("select * from \"%s\" order by rowid", t.Name))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	t.Columns, err = rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	t.Rows = [][]any{}
+	for rows.Next() {
+		values := make([]any, len(t.Columns))
+		pointers := make([]any, len(t.Columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		for i, v := range values {
+			values[i] = encodeCell(v)
+		}
+		t.Rows = append(t.Rows, values)
+	}
+
+	return rows.Err()
+}
+
+func encodeCell(v any) any {
+	switch value := v.(type) {
+	case []byte:
+		return blob{Base64: base64.StdEncoding.EncodeToString(value)}
+	case bool:
+		if value {
+			return int64(1)
+		}
+		return int64(0)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+func decodeCell(v any) (any, error) {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, nil
+		}
+		return value.Float64()
+	case map[string]any:
+		encoded, ok := value["base64"].(string)
+		if !ok {
+			return nil, fmt.Errorf("Unknown cell %v", value)
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return v, nil
+}
+
+// Write writes the archive as indented JSON.
+func (a *Archive) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a); err != nil {
+		return fmt.Errorf("Error writing backup: %s", err)
+	}
+	return nil
+}
+
+// Read parses an archive and checks it is one this version can restore.
+func Read(r io.Reader) (*Archive, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	archive := &Archive{}
+	if err := dec.Decode(archive); err != nil {
+		return nil, fmt.Errorf("Error reading backup: %s", err)
+	}
+
+	if archive.Format != Format {
+		return nil, fmt.Errorf("Not a sacmoney backup.")
+	}
+
+	if archive.Version < 1 || archive.Version > Version {
+		return nil, fmt.Errorf("Backup version %d is not supported, this sacmoney reads version %d.", archive.Version, Version)
+	}
+
+	return archive, nil
+}
+
+// Restore rebuilds a data directory from the archive. dir must not hold a
+// ledger already, so a restore can never overwrite live data.
+func Restore(a *Archive, dir string) error {
+	if err := checkEmpty(dir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Error creating %s: %s", dir, err)
+	}
+
+	for _, p := range a.Periods {
+		expected := db.Period{Year: p.Year, Month: time.Month(p.Month)}.FileName()
+		if p.File != expected {
+			return fmt.Errorf("Backup period %d-%02d is stored as %s, expected %s.", p.Year, p.Month, p.File, expected)
+		}
+
+		if err := loadDatabase(filepath.Join(dir, p.File), &p.Database); err != nil {
+			return err
+		}
+	}
+
+	if a.Store != nil {
+		if a.Store.File != db.StoreFileName {
+			return fmt.Errorf("Backup store is named %s, expected %s.", a.Store.File, db.StoreFileName)
+		}
+
+		if err := loadDatabase(filepath.Join(dir, db.StoreFileName), a.Store); err != nil {
+			return err
+		}
+	}
+
+	if len(a.Attachments) > 0 {
+		if err := os.MkdirAll(filepath.Join(dir, AttachmentsDirectory), 0700); err != nil {
+			return fmt.Errorf("Error creating attachments directory: %s", err)
+		}
+	}
+
+	for _, attachment := range a.Attachments {
+		name := filepath.Base(attachment.Name)
+		if name != attachment.Name || name == "." || name == ".." {
+			return fmt.Errorf("Invalid attachment name %q.", attachment.Name)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, AttachmentsDirectory, name), attachment.Data, 0600); err != nil {
+			return fmt.Errorf("Error writing attachment %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+func checkEmpty(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %s", dir, err)
+	}
+
+	for _, e := range entries {
+		if _, ok := db.ParsePeriod(e.Name()); ok || e.Name() == db.StoreFileName {
+			return fmt.Errorf("%s already holds sacmoney data, restore into an empty directory.", dir)
+		}
+	}
+
+	return nil
+}
+
+func loadDatabase(path string, d *Database) error {
+	sdb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %s", path, err)
+	}
+
+	defer sdb.Close()
+
+	tx, err := sdb.Begin()
+	if err != nil {
+		return fmt.Errorf("Error restoring %s: %s", path, err)
+	}
+
+	for _, t := range d.Tables {
+		if err = loadTable(tx, &t); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error restoring %s into %s: %s", t.Name, path, err)
+		}
+	}
+
+	for _, index := range d.Indexes {
+		if _, err = tx.Exec(index); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Error restoring index into %s: %s", path, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Error restoring %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func loadTable(tx *sql.Tx, t *Table) error {
+	if strings.HasPrefix(t.Name, "sqlite_") {
+		return nil
+	}
+
+	if _, err := tx.Exec(t.Sql); err != nil {
+		return err
+	}
+
+	if len(t.Rows) == 0 {
+		return nil
+	}
+
+	var quoted []string
+	for _, c := range t.Columns {
+		quoted = append(quoted, fmt.Sprintf("\"%s\"", c))
+	}
+
+	insert := fmt.Sprintf("insert into \"%s\" (%s) values (%s)", t.Name,
+		strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(t.Columns)), ", "))
+
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, row := range t.Rows {
+		if len(row) != len(t.Columns) {
+			return fmt.Errorf("row has %d values for %d columns", len(row), len(t.Columns))
+		}
+
+		values := make([]any, len(row))
+		for i, cell := range row {
+			if values[i], err = decodeCell(cell); err != nil {
+				return err
+			}
+		}
+
+		if _, err = stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Verify restores the archive into a scratch directory, backs that up
+// again and checks both archives hold the same data.
+func Verify(a *Archive) error {
+	scratch, err := os.MkdirTemp("", "sacmoney-verify-")
+	if err != nil {
+		return fmt.Errorf("Error creating scratch directory: %s", err)
+	}
+
+	defer os.RemoveAll(scratch)
+
+	var original bytes.Buffer
+	if err = a.Write(&original); err != nil {
+		return err
+	}
+
+	// Round trip through JSON so both sides have the same cell types.
+	reread, err := Read(&original)
+	if err != nil {
+		return err
+	}
+
+	if err = Restore(reread, scratch); err != nil {
+		return err
+	}
+
+	copied, err := Create(scratch)
+	if err != nil {
+		return err
+	}
+	copied.Created = a.Created
+
+	var want, got bytes.Buffer
+	a.Write(&want)
+	copied.Write(&got)
+
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		return fmt.Errorf("Backup verification failed, the restored data does not match the original.")
+	}
+
+	return nil
+}
+
+const Q_SCHEMA = `
+	select type
+	     , name
+	     , sql
+	from sqlite_master
+	where type in ('table', 'index')
+	  and name not like 'sqlite_%'
+	order by type desc
+	        ,name
+`
@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+func mustInsert(t *testing.T, items ...db.Crudder) {
+	t.Helper()
+	for _, item := range items {
+		if err := db.Insert(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// buildDataDir makes a data directory with two periods, a store with
+// settings and users, and an attachment. The store also gets a table of
+// blobs, reals and nulls, the cells JSON has the most trouble with.
+func buildDataDir(t *testing.T) string {
+	dir := t.TempDir()
+	september := db.Period{Year: 2026, Month: time.September}
+	october := db.Period{Year: 2026, Month: time.October}
+
+	if err := db.InitStore(filepath.Join(dir, db.StoreFileName)); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseStore()
+
+	if err := db.InitDatabase(filepath.Join(dir, september.FileName()), false); err != nil {
+		t.Fatal(err)
+	}
+
+	checking := &db.Account{Name: "Checking", Kind: db.AccountAsset}
+	visa := &db.Account{Name: "Visa", Kind: db.AccountLiability}
+	mustInsert(t, checking, visa, &db.Category{Name: "Groceries"})
+	mustInsert(t,
+		&db.Transaction{AccountId: checking.Id, Name: "Paycheck", Amount: 250000, Date: september.Start(), Category: "Salary"},
+		&db.Transaction{AccountId: checking.Id, Name: "Safeway", Amount: -8523, Date: september.Start().AddDate(0, 0, 3),
+			Memo: "weekly shop", Tags: "food, weekly", Payee: "Safeway", CheckNumber: "1001", FitId: "ABC123",
+			Splits: []db.Split{
+				{Category: "Groceries", Amount: -6523, Memo: "food"},
+				{Category: "Household", Amount: -2000},
+			}},
+		&db.Transaction{AccountId: visa.Id, Name: "Gas", Amount: -4512, Date: september.Start().AddDate(0, 0, 9), CreatedBy: 1},
+		&db.Recurring{AccountId: checking.Id, Name: "Rent", Amount: -100000, Day: 1},
+	)
+
+	if err := db.InitDatabase(filepath.Join(dir, october.FileName()), true); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, &db.Transaction{AccountId: visa.Id, Name: "Coffee", Amount: -450, Date: october.Start()})
+	db.CloseDatabase()
+
+	mustInsert(t,
+		&db.CsvMapping{Name: "Bank", HasHeader: true, Delimiter: ";", DateColumn: 1, DescriptionColumn: 2, AmountColumn: 3, DateFormat: "2006-01-02", NegateAmounts: true},
+		&db.Rule{Name: "Groceries", Enabled: true, Pattern: "SAFEWAY", MinAmount: -10000, Category: "Groceries", Tags: "food"},
+		&db.User{Username: "admin", PasswordHash: "hash", IsAdmin: true, Created: time.Unix(1790000000, 0)},
+		&db.Webhook{Name: "hook", Url: "http://127.0.0.1:9000", Secret: "s3cret", Events: "test", Enabled: true},
+	)
+	if err := db.SetAccountRole(checking.Id, 1, db.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordHistory(db.HistoryEntry{UserId: 1, Action: db.HistoryRollover, Period: october}); err != nil {
+		t.Fatal(err)
+	}
+	db.CloseStore()
+
+	raw, err := sql.Open("sqlite3", filepath.Join(dir, db.StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for _, statement := range []string{
+		"create table extras (id integer primary key, data blob, ratio real, note text)",
+		"create index extras_note on extras (note)",
+		"insert into extras (data, ratio, note) values (x'00ff10', 0.25, 'quote \" and ''single''')",
+		"insert into extras (data, ratio, note) values (null, null, null)",
+	} {
+		if _, err = raw.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attachments := filepath.Join(dir, AttachmentsDirectory)
+	if err = os.MkdirAll(attachments, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(attachments, "receipt.pdf"), []byte("%PDF-1.4\x00\x01binary"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	original := buildDataDir(t)
+
+	archive, err := Create(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Periods) != 2 || archive.Store == nil || len(archive.Attachments) != 1 {
+		t.Fatalf("Archive has %d periods, store %v and %d attachments", len(archive.Periods), archive.Store != nil, len(archive.Attachments))
+	}
+
+	var written bytes.Buffer
+	if err = archive.Write(&written); err != nil {
+		t.Fatal(err)
+	}
+
+	reread, err := Read(&written)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored")
+	if err = Restore(reread, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	compareDirs(t, original, restored)
+
+	if err = Restore(reread, restored); err == nil {
+		t.Error("Restoring over existing data should fail")
+	}
+}
+
+// compareDirs checks both directories hold the same files, and that every
+// database in them has the same schema and rows, read straight from SQLite
+// rather than through the archive code being tested.
+func compareDirs(t *testing.T, want string, got string) {
+	wantFiles := listFiles(t, want)
+	gotFiles := listFiles(t, got)
+	if !reflect.DeepEqual(wantFiles, gotFiles) {
+		t.Fatalf("Restored files %v, want %v", gotFiles, wantFiles)
+	}
+
+	for _, name := range wantFiles {
+		wantPath := filepath.Join(want, name)
+		gotPath := filepath.Join(got, name)
+
+		if filepath.Ext(name) != ".db" {
+			wantData, _ := os.ReadFile(wantPath)
+			gotData, _ := os.ReadFile(gotPath)
+			if !bytes.Equal(wantData, gotData) {
+				t.Errorf("%s differs after restore", name)
+			}
+			continue
+		}
+
+		wantTables := readDatabase(t, wantPath)
+		gotTables := readDatabase(t, gotPath)
+		if len(wantTables) == 0 {
+			t.Errorf("%s has no tables", name)
+		}
+		for table, rows := range wantTables {
+			if !reflect.DeepEqual(rows, gotTables[table]) {
+				t.Errorf("%s %s restored as\n%v\nwant\n%v", name, table, gotTables[table], rows)
+			}
+		}
+		for table := range gotTables {
+			if _, ok := wantTables[table]; !ok {
+				t.Errorf("%s gained %s", name, table)
+			}
+		}
+	}
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+// readDatabase returns every table's rows keyed by name, with the schema
+// itself under "sqlite_master".
+func readDatabase(t *testing.T, path string) map[string][][]any {
+	sdb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+
+	tables := map[string][][]any{}
+	tables["sqlite_master"] = queryRows(t, sdb, "select type, name, tbl_name, sql from sqlite_master order by type, name")
+
+	var names []string
+	for _, row := range tables["sqlite_master"] {
+		if row[0] == "table" {
+			names = append(names, row[1].(string))
+		}
+	}
+
+	for _, name := range names {
+		tables[name] = queryRows(t, sdb, "select * from \""+name+"\" order by rowid")
+	}
+
+	return tables
+}
+
+func queryRows(t *testing.T, sdb *sql.DB, query string) [][]any {
+	rows, err := sdb.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns, _ := rows.Columns()
+	var results [][]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, values)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
@@ -0,0 +1,402 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+
+	sqlite "github.com/mattn/go-sqlite3"
+)
+
+// A snapshot is a plain copy of the data directory, taken with SQLite's
+// online backup API so the server can keep writing while it runs. Each one
+// lives in its own folder named after when it was taken and why, for
+// example 20261019-020000-scheduled.
+
+const (
+	ReasonScheduled = "scheduled"
+	ReasonRollover  = "rollover"
+	ReasonManual    = "manual"
+	ReasonRestore   = "before-restore"
+	ReasonUndo      = "before-undo-rollover"
+
+	snapshotTimeFormat = "20060102-150405"
+
+	// snapshotStepPages is how many pages are copied before the source is
+	// unlocked again, so a large file never holds off writers for long.
+	snapshotStepPages = 64
+	snapshotStepPause = 5 * time.Millisecond
+)
+
+type Snapshot struct {
+	Name   string
+	Taken  time.Time
+	Reason string
+	Files  []string
+	Size   int64
+}
+
+// Retention is how many snapshots are kept per day, week and month. The
+// newest snapshot in each of the last Daily days is kept, the newest in each
+// of the last Weekly weeks and so on; anything not kept by one of them is
+// removed. Each reason is rotated on its own, so a day of scheduled
+// snapshots never pushes out the one taken before a rollover.
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+var DefaultRetention = Retention{Daily: 7, Weekly: 4, Monthly: 12}
+
+func parseSnapshotName(name string) (time.Time, string, bool) {
+	if len(name) <= len(snapshotTimeFormat)+1 || name[len(snapshotTimeFormat)] != '-' {
+		return time.Time{}, "", false
+	}
+
+	taken, err := time.ParseInLocation(snapshotTimeFormat, name[:len(snapshotTimeFormat)], time.Local)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	return taken, name[len(snapshotTimeFormat)+1:], true
+}
+
+// ledgerFiles lists the period files and store in dir.
+func ledgerFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", dir, err)
+	}
+
+	var files []string
+	for _, e := range entries {
+		if _, ok := db.ParsePeriod(e.Name()); ok || e.Name() == db.StoreFileName {
+			files = append(files, e.Name())
+		}
+	}
+
+	return files, nil
+}
+
+// TakeSnapshot copies every period, the store and any attachments in
+// dataDir into a new folder under snapshotDir. The folder only appears once
+// the copy is complete.
+func TakeSnapshot(dataDir string, snapshotDir string, reason string) (Snapshot, error) {
+	taken := time.Now()
+	snapshot := Snapshot{
+		Name:   fmt.Sprintf("%s-%s", taken.Format(snapshotTimeFormat), reason),
+		Taken:  taken.Truncate(time.Second),
+		Reason: reason,
+	}
+
+	files, err := ledgerFiles(dataDir)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if err = os.MkdirAll(snapshotDir, 0700); err != nil {
+		return snapshot, fmt.Errorf("Error creating snapshot directory: %s", err)
+	}
+
+	// Names only go down to the second, so a second snapshot for the same
+	// reason within one is named a second later rather than lost.
+	final := filepath.Join(snapshotDir, snapshot.Name)
+	for _, err = os.Stat(final); err == nil; _, err = os.Stat(final) {
+		taken = taken.Add(time.Second)
+		snapshot.Name = fmt.Sprintf("%s-%s", taken.Format(snapshotTimeFormat), reason)
+		final = filepath.Join(snapshotDir, snapshot.Name)
+	}
+
+	working, err := os.MkdirTemp(snapshotDir, ".partial-")
+	if err != nil {
+		return snapshot, fmt.Errorf("Error creating snapshot directory: %s", err)
+	}
+
+	for _, f := range files {
+		if err = copyDatabase(filepath.Join(dataDir, f), filepath.Join(working, f)); err != nil {
+			os.RemoveAll(working)
+			return snapshot, fmt.Errorf("Error copying %s: %s", f, err)
+		}
+	}
+
+	if err = copyAttachments(filepath.Join(dataDir, AttachmentsDirectory), filepath.Join(working, AttachmentsDirectory)); err != nil {
+		os.RemoveAll(working)
+		return snapshot, err
+	}
+
+	if err = os.Rename(working, final); err != nil {
+		os.RemoveAll(working)
+		return snapshot, fmt.Errorf("Error saving snapshot: %s", err)
+	}
+
+	return readSnapshot(snapshotDir, snapshot.Name)
+}
+
+// copyDatabase copies src to dest with the online backup API, a few pages at
+// a time. If the source changes in between, SQLite starts the copy over, so
+// the result is always consistent.
+func copyDatabase(src string, dest string) error {
+	srcDb, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer srcDb.Close()
+
+	destDb, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return err
+	}
+	defer destDb.Close()
+
+	ctx := context.Background()
+	srcConn, err := srcDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := destDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			b, err := destDriver.(*sqlite.SQLiteConn).Backup("main", srcDriver.(*sqlite.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			for {
+				done, err := b.Step(snapshotStepPages)
+				if err != nil {
+					b.Finish()
+					return err
+				}
+				if done {
+					return b.Finish()
+				}
+				time.Sleep(snapshotStepPause)
+			}
+		})
+	})
+}
+
+func copyAttachments(src string, dest string) error {
+	entries, err := os.ReadDir(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading attachments: %s", err)
+	}
+
+	if err = os.MkdirAll(dest, 0700); err != nil {
+		return fmt.Errorf("Error creating attachments directory: %s", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		if err = copyFile(filepath.Join(src, e.Name()), filepath.Join(dest, e.Name())); err != nil {
+			return fmt.Errorf("Error copying attachment %s: %s", e.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func readSnapshot(snapshotDir string, name string) (Snapshot, error) {
+	taken, reason, ok := parseSnapshotName(name)
+	if !ok {
+		return Snapshot{}, fmt.Errorf("%s is not a snapshot.", name)
+	}
+
+	snapshot := Snapshot{Name: name, Taken: taken, Reason: reason}
+	if _, err := os.Stat(filepath.Join(snapshotDir, name)); err != nil {
+		return snapshot, fmt.Errorf("No snapshot named %s.", name)
+	}
+
+	files, err := ledgerFiles(filepath.Join(snapshotDir, name))
+	if err != nil {
+		return snapshot, err
+	}
+
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(snapshotDir, name, f))
+		if err != nil {
+			return snapshot, fmt.Errorf("Error reading snapshot %s: %s", name, err)
+		}
+		snapshot.Files = append(snapshot.Files, f)
+		snapshot.Size += info.Size()
+	}
+
+	return snapshot, nil
+}
+
+// GetSnapshot returns the named snapshot, or an error if there isn't one.
+func GetSnapshot(snapshotDir string, name string) (Snapshot, error) {
+	if filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return Snapshot{}, fmt.Errorf("Invalid snapshot name %q.", name)
+	}
+
+	snapshot, err := readSnapshot(snapshotDir, name)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if len(snapshot.Files) == 0 {
+		return snapshot, fmt.Errorf("Snapshot %s is empty.", name)
+	}
+
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots in snapshotDir, newest first.
+func ListSnapshots(snapshotDir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(snapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading snapshots: %s", err)
+	}
+
+	var snapshots []Snapshot
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, _, ok := parseSnapshotName(e.Name()); !ok {
+			continue
+		}
+
+		snapshot, err := readSnapshot(snapshotDir, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
+
+// Rotate removes the snapshots the retention doesn't keep and returns their
+// names.
+func Rotate(snapshotDir string, keep Retention) ([]string, error) {
+	snapshots, err := ListSnapshots(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := map[string]bool{}
+	bucket := func(limit int, key func(time.Time) string) {
+		seen := map[string]bool{}
+		counts := map[string]int{}
+		for _, s := range snapshots {
+			k := s.Reason + " " + key(s.Taken)
+			if seen[k] {
+				continue
+			}
+			if counts[s.Reason] >= limit {
+				continue
+			}
+			seen[k] = true
+			counts[s.Reason]++
+			kept[s.Name] = true
+		}
+	}
+
+	bucket(keep.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	bucket(keep.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+	bucket(keep.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	var removed []string
+	for _, s := range snapshots {
+		if kept[s.Name] {
+			continue
+		}
+
+		if err = os.RemoveAll(filepath.Join(snapshotDir, s.Name)); err != nil {
+			return removed, fmt.Errorf("Error removing snapshot %s: %s", s.Name, err)
+		}
+		removed = append(removed, s.Name)
+	}
+
+	return removed, nil
+}
+
+// RestoreSnapshot replaces the ledger in dataDir with the one saved in the
+// named snapshot. Period files the snapshot doesn't have are removed, so the
+// ledger ends up exactly as it was. Nothing may have the ledger open while
+// this runs.
+func RestoreSnapshot(snapshotDir string, name string, dataDir string) error {
+	snapshot, err := GetSnapshot(snapshotDir, name)
+	if err != nil {
+		return err
+	}
+
+	current, err := ledgerFiles(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("Error creating %s: %s", dataDir, err)
+	}
+
+	for _, f := range current {
+		if err = os.Remove(filepath.Join(dataDir, f)); err != nil {
+			return fmt.Errorf("Error removing %s: %s", f, err)
+		}
+	}
+
+	for _, f := range snapshot.Files {
+		if err = copyFile(filepath.Join(snapshotDir, name, f), filepath.Join(dataDir, f)); err != nil {
+			return fmt.Errorf("Error restoring %s: %s", f, err)
+		}
+	}
+
+	return copyAttachments(filepath.Join(snapshotDir, name, AttachmentsDirectory), filepath.Join(dataDir, AttachmentsDirectory))
+}
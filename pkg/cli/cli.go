@@ -6,24 +6,47 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 	db "tjdickerson/sacmoney/pkg/database"
+	rules "tjdickerson/sacmoney/pkg/rules"
 	utils "tjdickerson/sacmoney/pkg/utils"
 )
 
 const WIDTH = 100
+const DbDirectory = "data/"
+const SnapshotDirectory = "backups/"
+
+func currentDbPath() string {
+	periods, err := db.ListPeriods(DbDirectory)
+	if err != nil || len(periods) == 0 {
+		return filepath.Join(DbDirectory, db.PeriodOf(time.Now()).FileName())
+	}
+
+	return filepath.Join(DbDirectory, periods[len(periods)-1].FileName())
+}
 
 func Run() {
+	if err := os.MkdirAll(DbDirectory, 0700); err != nil {
+		log.Fatal(fmt.Sprintf("Failure creating data directory: %s\n", err))
+	}
 
-	err := db.InitDatabase()
+	err := db.InitDatabase(currentDbPath(), false)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("Failure initializing database: %s\n", err))
 	}
 
 	defer db.CloseDatabase()
 
+	err = db.InitStore(filepath.Join(DbDirectory, db.StoreFileName))
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Failure initializing store: %s\n", err))
+	}
+
+	defer db.CloseStore()
+
 	var accountName string
 	if !db.HasAccount() {
 		log.Printf("You have no accounts configured.\n")
@@ -54,10 +77,10 @@ func Run() {
 			running = false
 			break
 		case "1":
-			createWithdrawal()
+			msg = createWithdrawal(account.Id)
 			break
 		case "2":
-			createDeposit()
+			msg = createDeposit(account.Id)
 			break
 		case "d":
 			msg = deleteEntry()
@@ -66,40 +89,67 @@ func Run() {
 	}
 }
 
-func createDeposit() {
+func createDeposit(accountId int) string {
 	name := getStringFromUser("Deposit Name > ")
 	amount := getStringFromUser("Deposit Amount > ")
 
-	iAmount := utils.GetCentsFromString(amount)
-	transaction := &db.Transaction{
-		Name:   name,
-		Amount: iAmount,
-		Date:   time.Now(),
+	iAmount, err := utils.ParseMoney(amount)
+	if err != nil {
+		log.Printf("Invalid amount: %s\n", err)
+		return "Invalid Amount"
 	}
 
-	err := db.Insert(transaction)
-	if err != nil {
-		log.Printf("Error adding transaction: %s\n", err)
+	transaction := &db.Transaction{
+		AccountId: accountId,
+		Name:      name,
+		Amount:    iAmount,
+		Date:      time.Now(),
 	}
+
+	return addTransaction(transaction)
 }
 
-func createWithdrawal() {
+func createWithdrawal(accountId int) string {
 	name := getStringFromUser("Debit Name > ")
 	amount := getStringFromUser("Debit Amount > ")
 
-	iAmount := utils.GetCentsFromString(amount)
-	iAmount = iAmount * -1
+	iAmount, err := utils.ParseMoney(amount)
+	if err != nil {
+		log.Printf("Invalid amount: %s\n", err)
+		return "Invalid Amount"
+	}
 
 	transaction := &db.Transaction{
-		Name:   name,
-		Amount: iAmount,
-		Date:   time.Now(),
+		AccountId: accountId,
+		Name:      name,
+		Amount:    -iAmount,
+		Date:      time.Now(),
 	}
 
+	return addTransaction(transaction)
+}
+
+func addTransaction(transaction *db.Transaction) string {
+	applyRules(transaction)
 	err := db.Insert(transaction)
 	if err != nil {
 		log.Printf("Error adding transaction: %s\n", err)
+		return "Couldn't Add Transaction"
 	}
+
+	return "Transaction Added"
+}
+
+// applyRules cleans up a new transaction with the saved rules. A rule that
+// fails to load shouldn't stop the transaction from being entered.
+func applyRules(transaction *db.Transaction) {
+	engine, err := rules.Load()
+	if err != nil {
+		log.Printf("Error loading rules: %s\n", err)
+		return
+	}
+
+	engine.Apply(transaction)
 }
 
 func deleteEntry() string {
@@ -131,10 +181,9 @@ func displayMenu(account *db.Account, msg string) {
 	fmt.Printf("%s\n", headerRow(account.Name))
 	fmt.Printf("%s\n", msg)
 
-	amount := fmt.Sprintf("%.2f", float64(account.TotalAvailable)*0.10)
-	fmt.Printf("%s\n\n", amount)
+	fmt.Printf("%s\n\n", account.TotalAvailable.Dollars())
 
-	top10, err := db.FetchAllTransactions()
+	top10, err := db.FetchTransactionsFor(account.Id)
 	if err != nil {
 		log.Printf("Error getting last transactions: %s\n", err)
 	} else {
@@ -0,0 +1,943 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	backup "tjdickerson/sacmoney/pkg/backup"
+	db "tjdickerson/sacmoney/pkg/database"
+	duplicates "tjdickerson/sacmoney/pkg/duplicates"
+	encryption "tjdickerson/sacmoney/pkg/encryption"
+	exporter "tjdickerson/sacmoney/pkg/exporter"
+	importer "tjdickerson/sacmoney/pkg/importer"
+	reports "tjdickerson/sacmoney/pkg/reports"
+	rules "tjdickerson/sacmoney/pkg/rules"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+func RunCommand(args []string) error {
+	switch args[0] {
+	case "report":
+		return reportCommand(args[1:])
+	case "networth":
+		return netWorthCommand(args[1:])
+	case "import-csv":
+		return importCsvCommand(args[1:])
+	case "import-ofx":
+		return importOfxCommand(args[1:])
+	case "import-qif":
+		return importQifCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "rules":
+		return rulesCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "snapshots":
+		return snapshotsCommand(args[1:])
+	case "users":
+		return usersCommand(args[1:])
+	}
+
+	return fmt.Errorf("Unknown command %s", args[0])
+}
+
+// openLedger connects to the current period and the store the same way the
+// server does. The returned function closes both.
+func openLedger() (func(), error) {
+	if err := os.MkdirAll(DbDirectory, 0700); err != nil {
+		return nil, fmt.Errorf("Failure creating data directory: %s", err)
+	}
+
+	if err := db.InitDatabase(currentDbPath(), false); err != nil {
+		return nil, fmt.Errorf("Failure initializing database: %s", err)
+	}
+
+	if err := db.InitStore(filepath.Join(DbDirectory, db.StoreFileName)); err != nil {
+		db.CloseDatabase()
+		return nil, err
+	}
+
+	return func() {
+		db.CloseStore()
+		db.CloseDatabase()
+	}, nil
+}
+
+func reportCommand(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	from := flags.String("from", "", "first day of the report (yyyy-mm-dd)")
+	to := flags.String("to", "", "last day of the report (yyyy-mm-dd)")
+	format := flags.String("format", "json", "output format: json or csv")
+	top := flags.Int("top", 10, "number of largest transactions to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	start, end, err := reports.ParseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	report, err := reports.Build(DbDirectory, start, end, *top, nil)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		return report.WriteJSON(os.Stdout)
+	case "csv":
+		return report.WriteCSV(os.Stdout)
+	}
+
+	return fmt.Errorf("Unknown report format %s", *format)
+}
+
+func netWorthCommand(args []string) error {
+	flags := flag.NewFlagSet("networth", flag.ContinueOnError)
+	date := flags.String("date", "", "report net worth at the end of this day (yyyy-mm-dd)")
+	months := flags.Int("months", 12, "number of months of history to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	asOf := time.Now().UTC()
+	if len(*date) > 0 {
+		t, err := time.Parse("2006-01-02", *date)
+		if err != nil {
+			return fmt.Errorf("Invalid date %s, expected yyyy-mm-dd.", *date)
+		}
+		asOf = t
+	}
+
+	if *months < 1 {
+		return fmt.Errorf("At least one month of history is required.")
+	}
+
+	last := db.PeriodOf(asOf)
+	first := db.PeriodOf(last.Start().AddDate(0, 1-*months, 0))
+	history, err := db.NetWorthHistory(DbDirectory, first, last, nil)
+	if err != nil {
+		return err
+	}
+
+	nw, err := db.NetWorthAsOf(DbDirectory, asOf.AddDate(0, 0, 1), nil)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(os.Stdout)
+	cw.Write([]string{"month", "assets", "liabilities", "net worth"})
+	for _, m := range history {
+		cw.Write([]string{
+			fmt.Sprintf("%d-%02d", m.Period.Year, int(m.Period.Month)),
+			m.Assets.String(),
+			m.Liabilities.String(),
+			m.Total.String(),
+		})
+	}
+	cw.Write([]string{asOf.Format("2006-01-02"),
+		nw.Assets.String(),
+		nw.Liabilities.String(),
+		nw.Total.String(),
+	})
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func importCsvCommand(args []string) error {
+	flags := flag.NewFlagSet("import-csv", flag.ContinueOnError)
+	file := flags.String("file", "", "csv file to import")
+	mappingName := flags.String("mapping", "", "name of a saved mapping to use")
+	save := flags.Bool("save", false, "save the column flags under -mapping")
+	apply := flags.Bool("apply", false, "insert the rows instead of only previewing them")
+	onDuplicate := flags.String("duplicates", duplicates.ActionSkip, "what to do with likely duplicates: skip, keep or merge")
+	account := flags.Int("account", 0, "account id to import into (defaults to the first account)")
+	header := flags.Bool("header", true, "the first row holds column names")
+	delimiter := flags.String("delimiter", ",", "field delimiter")
+	dateCol := flags.Int("date-col", 0, "date column number")
+	descCol := flags.Int("desc-col", 0, "description column number")
+	amountCol := flags.Int("amount-col", 0, "signed amount column number")
+	debitCol := flags.Int("debit-col", 0, "debit column number")
+	creditCol := flags.Int("credit-col", 0, "credit column number")
+	checkCol := flags.Int("check-col", 0, "check number column number")
+	dateFormat := flags.String("date-format", importer.DateFormats[1], "date layout, written as Go's reference date")
+	negate := flags.Bool("negate", false, "flip the sign of the amount column")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*file) == 0 {
+		return fmt.Errorf("-file is required")
+	}
+
+	closeLedger, err := openLedger()
+	if err != nil {
+		return err
+	}
+	defer closeLedger()
+
+	mapping := db.CsvMapping{
+		Name:              *mappingName,
+		HasHeader:         *header,
+		Delimiter:         *delimiter,
+		DateColumn:        *dateCol,
+		DescriptionColumn: *descCol,
+		AmountColumn:      *amountCol,
+		DebitColumn:       *debitCol,
+		CreditColumn:      *creditCol,
+		CheckNumberColumn: *checkCol,
+		DateFormat:        *dateFormat,
+		NegateAmounts:     *negate,
+	}
+
+	if len(*mappingName) > 0 && !*save {
+		saved, err := db.GetCsvMapping(*mappingName)
+		if err != nil {
+			return err
+		}
+		if saved == nil {
+			return fmt.Errorf("No saved mapping named %s", *mappingName)
+		}
+		mapping = *saved
+	}
+
+	if *save {
+		if len(*mappingName) == 0 {
+			return fmt.Errorf("-save needs a -mapping name")
+		}
+		if err := importer.ValidateMapping(&mapping); err != nil {
+			return err
+		}
+		if err := db.SaveCsvMapping(&mapping); err != nil {
+			return err
+		}
+		fmt.Printf("Saved mapping %s\n", mapping.Name)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", *file, err)
+	}
+	defer f.Close()
+
+	rows, err := importer.ParseCsv(f, mapping)
+	if err != nil {
+		return err
+	}
+
+	return finishImport(rows, *account, *apply, *onDuplicate)
+}
+
+func importOfxCommand(args []string) error {
+	flags := flag.NewFlagSet("import-ofx", flag.ContinueOnError)
+	file := flags.String("file", "", "ofx or qfx file to import")
+	apply := flags.Bool("apply", false, "insert the rows instead of only previewing them")
+	onDuplicate := flags.String("duplicates", duplicates.ActionSkip, "what to do with likely duplicates: skip, keep or merge")
+	account := flags.Int("account", 0, "account id to import into (defaults to the first account)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*file) == 0 {
+		return fmt.Errorf("-file is required")
+	}
+
+	closeLedger, err := openLedger()
+	if err != nil {
+		return err
+	}
+	defer closeLedger()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", *file, err)
+	}
+	defer f.Close()
+
+	statement, err := importer.ParseOfx(f)
+	if err != nil {
+		return err
+	}
+
+	accountId, err := resolveAccount(*account)
+	if err != nil {
+		return err
+	}
+
+	if err = importer.MarkImported(statement.Rows, DbDirectory, accountId); err != nil {
+		return err
+	}
+
+	if err = finishImport(statement.Rows, accountId, *apply, *onDuplicate); err != nil {
+		return err
+	}
+
+	if statement.HasLedgerBalance {
+		a, err := db.GetAccount(accountId)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Bank ledger balance %s as of %s, sacmoney balance %s, difference %s.\n",
+			statement.LedgerBalance.String(),
+			statement.LedgerDate.Format("2006-01-02"),
+			a.TotalAvailable.String(),
+			(statement.LedgerBalance - a.TotalAvailable).String())
+	}
+
+	return nil
+}
+
+func importQifCommand(args []string) error {
+	flags := flag.NewFlagSet("import-qif", flag.ContinueOnError)
+	file := flags.String("file", "", "qif file to import")
+	apply := flags.Bool("apply", false, "insert the rows instead of only previewing them")
+	onDuplicate := flags.String("duplicates", duplicates.ActionSkip, "what to do with likely duplicates: skip, keep or merge")
+	account := flags.Int("account", 0, "account id to import into (defaults to the first account)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*file) == 0 {
+		return fmt.Errorf("-file is required")
+	}
+
+	closeLedger, err := openLedger()
+	if err != nil {
+		return err
+	}
+	defer closeLedger()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", *file, err)
+	}
+	defer f.Close()
+
+	rows, err := importer.ParseQif(f)
+	if err != nil {
+		return err
+	}
+
+	return finishImport(rows, *account, *apply, *onDuplicate)
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	from := flags.String("from", "", "first day to export (yyyy-mm-dd)")
+	to := flags.String("to", "", "last day to export (yyyy-mm-dd)")
+	format := flags.String("format", "qif", "output format: qif, ledger, hledger or beancount")
+	account := flags.Int("account", 0, "account id to export (defaults to every account)")
+	out := flags.String("out", "", "file to write (defaults to stdout)")
+	encrypt := flags.Bool("encrypt", false, "encrypt the export with a passphrase")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	start, end, err := reports.ParseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := exporter.Load(DbDirectory, db.AccountSet(nil).Only(*account), start, end)
+	if err != nil {
+		return err
+	}
+
+	write, ok := map[string]func(io.Writer) error{
+		"qif":       ledger.WriteQif,
+		"ledger":    ledger.WriteLedger,
+		"hledger":   ledger.WriteHledger,
+		"beancount": ledger.WriteBeancount,
+	}[*format]
+	if !ok {
+		return fmt.Errorf("Unknown export format %s", *format)
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("Error creating %s: %s", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if !*encrypt {
+		return write(w)
+	}
+
+	sealed, err := encryptedOutput(w)
+	if err != nil {
+		return err
+	}
+
+	if err = write(sealed); err != nil {
+		return err
+	}
+	return sealed.Close()
+}
+
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write (defaults to stdout)")
+	verify := flags.Bool("verify", true, "restore the backup into a scratch directory and compare it")
+	encrypt := flags.Bool("encrypt", false, "encrypt the backup with a passphrase")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	archive, err := backup.Create(DbDirectory)
+	if err != nil {
+		return err
+	}
+
+	if *verify {
+		if err = backup.Verify(archive); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("Error creating %s: %s", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *encrypt {
+		sealed, err := encryptedOutput(w)
+		if err != nil {
+			return err
+		}
+
+		if err = archive.Write(sealed); err != nil {
+			return err
+		}
+		if err = sealed.Close(); err != nil {
+			return fmt.Errorf("Error writing backup: %s", err)
+		}
+	} else if err = archive.Write(w); err != nil {
+		return err
+	}
+
+	if len(*out) > 0 {
+		fmt.Fprintf(os.Stderr, "Backed up %d periods and %d attachments to %s\n", len(archive.Periods), len(archive.Attachments), *out)
+	}
+	return nil
+}
+
+// restoreCommand rebuilds a data directory from a backup. It never opens the
+// ledger, so restoring into the default directory only works while it's empty.
+// Encrypted backups ask for their passphrase.
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	in := flags.String("in", "", "backup file to restore")
+	dir := flags.String("dir", DbDirectory, "empty data directory to restore into")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*in) == 0 {
+		return fmt.Errorf("Usage: restore -in backup.json [-dir data/]")
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", *in, err)
+	}
+
+	if encryption.IsEncrypted(data) {
+		passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for %s", *in), false)
+		if err != nil {
+			return err
+		}
+
+		if data, err = encryption.Decrypt(data, passphrase); err != nil {
+			return err
+		}
+	}
+
+	archive, err := backup.Read(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if err = backup.Restore(archive, *dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d periods and %d attachments into %s\n", len(archive.Periods), len(archive.Attachments), *dir)
+	return nil
+}
+
+func snapshotsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: snapshots list|take|restore|rotate [flags]")
+	}
+
+	flags := flag.NewFlagSet("snapshots "+args[0], flag.ContinueOnError)
+	dir := flags.String("dir", SnapshotDirectory, "snapshot directory")
+	name := flags.String("name", "", "snapshot to restore")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		snapshots, err := backup.ListSnapshots(*dir)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range snapshots {
+			fmt.Printf("%s  %-14s  %d files  %d bytes\n", s.Name, s.Reason, len(s.Files), s.Size)
+		}
+		return nil
+	case "take":
+		snapshot, err := backup.TakeSnapshot(DbDirectory, *dir, backup.ReasonManual)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Took snapshot %s\n", snapshot.Name)
+		return rotateSnapshots(*dir)
+	case "rotate":
+		return rotateSnapshots(*dir)
+	case "restore":
+		if len(*name) == 0 {
+			return fmt.Errorf("Usage: snapshots restore -name <snapshot>")
+		}
+
+		if _, err := backup.GetSnapshot(*dir, *name); err != nil {
+			return err
+		}
+
+		// Keep what's there now, in case the wrong snapshot was picked.
+		current, err := backup.TakeSnapshot(DbDirectory, *dir, backup.ReasonRestore)
+		if err != nil {
+			return fmt.Errorf("Not restoring, the snapshot of the current data failed: %s", err)
+		}
+		fmt.Printf("Saved the current data as snapshot %s\n", current.Name)
+
+		if err = backup.RestoreSnapshot(*dir, *name, DbDirectory); err != nil {
+			return err
+		}
+
+		fmt.Printf("Restored snapshot %s\n", *name)
+		return rotateSnapshots(*dir)
+	}
+
+	return fmt.Errorf("Unknown snapshots command %s", args[0])
+}
+
+func rotateSnapshots(dir string) error {
+	removed, err := backup.Rotate(dir, backup.DefaultRetention)
+	for _, name := range removed {
+		fmt.Printf("Removed snapshot %s\n", name)
+	}
+	return err
+}
+
+func rulesCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: rules list|add|delete|apply [flags]")
+	}
+
+	closeLedger, err := openLedger()
+	if err != nil {
+		return err
+	}
+	defer closeLedger()
+
+	switch args[0] {
+	case "list":
+		saved, err := db.FetchAllRules()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range saved {
+			match := fmt.Sprintf("contains %q", r.Pattern)
+			if r.IsRegex {
+				match = fmt.Sprintf("matches /%s/", r.Pattern)
+			}
+			state := ""
+			if !r.Enabled {
+				state = " (disabled)"
+			}
+			bound := func(cents utils.Money) string {
+				if cents == 0 {
+					return "any"
+				}
+				return cents.String()
+			}
+			fmt.Printf("%3d  %s%s: %s, amount %s to %s, account %d -> display %q, payee %q, category %q, tags %q\n",
+				r.Id, r.Name, state, match, bound(r.MinAmount), bound(r.MaxAmount),
+				r.AccountId, r.DisplayName, r.Payee, r.Category, r.Tags)
+		}
+		return nil
+	case "add":
+		return addRuleCommand(args[1:])
+	case "delete":
+		flags := flag.NewFlagSet("rules delete", flag.ContinueOnError)
+		id := flags.Int("id", 0, "id of the rule to delete")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		rule, err := db.GetRule(*id)
+		if err != nil {
+			return err
+		}
+		if rule == nil {
+			return fmt.Errorf("No rule with id %d", *id)
+		}
+
+		if err = db.Delete(rule); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted rule %s\n", rule.Name)
+		return nil
+	case "apply":
+		return applyRulesCommand(args[1:])
+	}
+
+	return fmt.Errorf("Unknown rules command %s", args[0])
+}
+
+func addRuleCommand(args []string) error {
+	flags := flag.NewFlagSet("rules add", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the rule (defaults to the pattern)")
+	pattern := flags.String("pattern", "", "text the transaction name contains")
+	regex := flags.Bool("regex", false, "treat -pattern as a regular expression")
+	minAmount := flags.String("min", "", "smallest amount to match, ignoring sign")
+	maxAmount := flags.String("max", "", "largest amount to match, ignoring sign")
+	account := flags.Int("account", 0, "only match this account id")
+	category := flags.String("category", "", "category to set")
+	tags := flags.String("tags", "", "comma separated tags to add")
+	payee := flags.String("payee", "", "payee to set")
+	display := flags.String("display", "", "display name to set")
+	disabled := flags.Bool("disabled", false, "save the rule without enabling it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rule := db.Rule{
+		Name:        *name,
+		Enabled:     !*disabled,
+		Pattern:     *pattern,
+		IsRegex:     *regex,
+		AccountId:   *account,
+		Category:    *category,
+		Tags:        *tags,
+		Payee:       *payee,
+		DisplayName: *display,
+	}
+	if len(rule.Name) == 0 {
+		rule.Name = rule.Pattern
+	}
+
+	var err error
+	if len(*minAmount) > 0 {
+		if rule.MinAmount, err = utils.ParseMoney(*minAmount); err != nil {
+			return err
+		}
+	}
+	if len(*maxAmount) > 0 {
+		if rule.MaxAmount, err = utils.ParseMoney(*maxAmount); err != nil {
+			return err
+		}
+	}
+
+	if err = rules.Validate(&rule); err != nil {
+		return err
+	}
+
+	if err = db.Insert(&rule); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added rule %d %s\n", rule.Id, rule.Name)
+	return nil
+}
+
+func applyRulesCommand(args []string) error {
+	flags := flag.NewFlagSet("rules apply", flag.ContinueOnError)
+	from := flags.String("from", "", "first day to re-run (yyyy-mm-dd)")
+	to := flags.String("to", "", "last day to re-run (yyyy-mm-dd)")
+	account := flags.Int("account", 0, "account id (defaults to every account)")
+	apply := flags.Bool("apply", false, "write the changes instead of only previewing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	start, end, err := reports.ParseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	engine, err := rules.Load()
+	if err != nil {
+		return err
+	}
+
+	changed, err := engine.ApplyHistory(DbDirectory, db.AccountSet(nil).Only(*account), start, end, !*apply)
+	for _, t := range changed {
+		fmt.Printf("%s  %10s  %s -> %s  payee %q  category %q  tags %q\n", t.Date.Format("2006-01-02"),
+			t.Amount.String(), t.Name, t.Display(), t.Payee, t.Category, t.Tags)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !*apply {
+		fmt.Printf("\n%d transactions would change. Run again with -apply to update them.\n", len(changed))
+		return nil
+	}
+
+	fmt.Printf("Updated %d transactions.\n", len(changed))
+	return nil
+}
+
+func resolveAccount(account int) (int, error) {
+	if account != 0 {
+		return account, nil
+	}
+
+	if !db.HasAccount() {
+		return 0, fmt.Errorf("Create an account before importing.")
+	}
+
+	a, err := db.GetDefaultAccount()
+	if err != nil {
+		return 0, err
+	}
+
+	return a.Id, nil
+}
+
+// ruleSummary lists what the rules changed on an imported row.
+func ruleSummary(row *importer.Row) string {
+	var parts []string
+	if len(row.DisplayName) > 0 {
+		parts = append(parts, "as "+row.DisplayName)
+	}
+	if len(row.Category) > 0 {
+		parts = append(parts, "in "+row.Category)
+	}
+	if len(row.Tags) > 0 {
+		parts = append(parts, "tagged "+row.Tags)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "  (" + strings.Join(parts, ", ") + ")"
+}
+
+func finishImport(rows []importer.Row, account int, apply bool, onDuplicate string) error {
+	if onDuplicate != duplicates.ActionSkip && onDuplicate != duplicates.ActionKeep && onDuplicate != duplicates.ActionMerge {
+		return fmt.Errorf("Unknown -duplicates action %s, expected skip, keep or merge", onDuplicate)
+	}
+
+	account, err := resolveAccount(account)
+	if err != nil {
+		return err
+	}
+
+	if err = importer.ApplyRules(rows, account); err != nil {
+		return err
+	}
+
+	if err = importer.MatchExisting(rows, DbDirectory, account); err != nil {
+		return err
+	}
+
+	valid := 0
+	for i, row := range rows {
+		if len(row.Error) > 0 {
+			fmt.Printf("%s\n", row.Error)
+			continue
+		}
+
+		if len(row.Duplicate) > 0 {
+			fmt.Printf("skip  %s  %s\n", row.Name, row.Duplicate)
+			continue
+		}
+
+		if row.Match != nil {
+			rows[i].Action = onDuplicate
+			fmt.Printf("%-5s %s  %10s  %s  looks like %s on %s (%.0f%%)\n", onDuplicate,
+				row.Date.Format("2006-01-02"), row.Amount.String(), row.Name,
+				row.Match.Existing.Name, row.Match.Existing.Date.Format("2006-01-02"), row.Match.Score*100)
+			if onDuplicate != duplicates.ActionSkip {
+				valid++
+			}
+			continue
+		}
+
+		valid++
+		fmt.Printf("%s  %10s  %s%s\n", row.Date.Format("2006-01-02"), row.Amount.String(), row.Name, ruleSummary(&row))
+	}
+
+	if !apply {
+		fmt.Printf("\n%d of %d rows ready. Run again with -apply to import them.\n", valid, len(rows))
+		return nil
+	}
+
+	count, err := importer.Import(db.CurrentLedger(), DbDirectory, rows, account, 0)
+	fmt.Printf("Imported %d transactions into account %d.\n", count, account)
+	return err
+}
+
+// usersCommand manages who can log in to the server. It's also the way back
+// in when the only admin has forgotten their password.
+func usersCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: users list|add|passwd|delete|share [flags]")
+	}
+
+	flags := flag.NewFlagSet("users "+args[0], flag.ContinueOnError)
+	name := flags.String("name", "", "username")
+	admin := flags.Bool("admin", false, "make the new user an admin")
+	account := flags.Int("account", 0, "account id to share")
+	role := flags.String("role", "", "owner, editor or viewer, or none to stop sharing")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	closeLedger, err := openLedger()
+	if err != nil {
+		return err
+	}
+	defer closeLedger()
+
+	if args[0] == "list" {
+		users, err := db.FetchAllUsers()
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			role := ""
+			if u.IsAdmin {
+				role = " (admin)"
+			}
+			fmt.Printf("%3d  %s%s, added %s\n", u.Id, u.Username, role, u.Created.Format("2006-01-02"))
+
+			roles, err := db.FetchUserRoles(u.Id)
+			if err != nil {
+				return err
+			}
+			for _, id := range roles.AccountIds() {
+				fmt.Printf("       account %d: %s\n", id, roles[id])
+			}
+		}
+		return nil
+	}
+
+	if len(*name) == 0 {
+		return fmt.Errorf("Usage: users %s -name <username>", args[0])
+	}
+
+	user, err := db.GetUserByName(*name)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if user != nil {
+			return db.ErrUsernameTaken
+		}
+
+		user = &db.User{Username: strings.TrimSpace(*name), IsAdmin: *admin}
+		if err = setPasswordFromUser(user); err != nil {
+			return err
+		}
+		if err = db.Insert(user); err != nil {
+			return err
+		}
+
+		fmt.Printf("Added %s\n", user.Username)
+		return nil
+	case "passwd":
+		if user == nil {
+			return fmt.Errorf("No user named %s.", *name)
+		}
+
+		if err = setPasswordFromUser(user); err != nil {
+			return err
+		}
+		if err = db.Update(user); err != nil {
+			return err
+		}
+
+		// A new password should lock out whoever had the old one.
+		if err = db.DeleteUserSessions(user.Id); err != nil {
+			return err
+		}
+
+		fmt.Printf("Changed the password for %s and logged them out everywhere\n", user.Username)
+		return nil
+	case "delete":
+		if user == nil {
+			return fmt.Errorf("No user named %s.", *name)
+		}
+
+		if err = db.Delete(user); err != nil {
+			return err
+		}
+
+		fmt.Printf("Deleted %s\n", user.Username)
+		return nil
+	case "share":
+		if user == nil {
+			return fmt.Errorf("No user named %s.", *name)
+		}
+
+		if found, err := db.FindAccount(*account); err != nil {
+			return err
+		} else if found == nil {
+			return fmt.Errorf("No account with id %d.", *account)
+		}
+
+		if *role == "none" {
+			err = db.RemoveAccountRole(*account, user.Id)
+		} else {
+			err = db.SetAccountRole(*account, user.Id, db.Role(*role))
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s's role on account %d is now %s\n", user.Username, *account, *role)
+		return nil
+	}
+
+	return fmt.Errorf("Unknown users command %s", args[0])
+}
+
+func setPasswordFromUser(user *db.User) error {
+	password, err := readPassphrase(fmt.Sprintf("Password for %s", user.Username), true)
+	if err != nil {
+		return err
+	}
+
+	return user.SetPassword(password)
+}
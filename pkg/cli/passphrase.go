@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	encryption "tjdickerson/sacmoney/pkg/encryption"
+
+	"golang.org/x/term"
+)
+
+// readPassphrase asks for a passphrase on the terminal without echoing it.
+// When stdin isn't a terminal the first line is read instead, so scripts can
+// pipe one in. With confirm set it's asked for twice.
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("Error reading passphrase: %s", err)
+		}
+
+		passphrase := strings.TrimRight(line, "\r\n")
+		if len(passphrase) == 0 {
+			return "", encryption.ErrEmptyPassphrase
+		}
+		return passphrase, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	entered, err := term.ReadPassword(fd)
+	fmt.Fprintf(os.Stderr, "\n")
+	if err != nil {
+		return "", fmt.Errorf("Error reading passphrase: %s", err)
+	}
+
+	if len(entered) == 0 {
+		return "", encryption.ErrEmptyPassphrase
+	}
+
+	if confirm {
+		fmt.Fprintf(os.Stderr, "Again: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintf(os.Stderr, "\n")
+		if err != nil {
+			return "", fmt.Errorf("Error reading passphrase: %s", err)
+		}
+
+		if string(again) != string(entered) {
+			return "", fmt.Errorf("The passphrases don't match.")
+		}
+	}
+
+	return string(entered), nil
+}
+
+// encryptedOutput wraps w so everything written is encrypted with a
+// passphrase asked for now. Closing the result writes the encrypted file.
+func encryptedOutput(w io.Writer) (io.WriteCloser, error) {
+	passphrase, err := readPassphrase("Passphrase to encrypt with", true)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryption.NewWriter(w, passphrase), nil
+}
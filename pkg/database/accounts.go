@@ -2,77 +2,163 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
 )
 
+const (
+	AccountAsset     = "asset"
+	AccountLiability = "liability"
+)
+
+// Every account keeps the register's sign convention (money out is negative),
+// so a liability such as a credit card with an outstanding balance sums to a
+// negative TotalAvailable. Kind only decides which side of net worth it is
+// reported on.
 type Account struct {
 	Id             int
 	Name           string
-	TotalAvailable int64
+	Kind           string
+	TotalAvailable utils.Money
+}
+
+var ErrAccountInUse = errors.New("The account still has transactions or recurring transactions, or is the default account.")
+
+// ErrNoAccount is returned when saving a transaction or recurring transaction
+// that doesn't say which account it belongs to.
+var ErrNoAccount = errors.New("The transaction has no account.")
+
+func ValidAccountKind(kind string) bool {
+	return kind == AccountAsset || kind == AccountLiability
 }
 
-func (a *Account) insert() error {
-	stmt, err := dbc.db.Prepare("insert into accounts(name) values(@name);")
+func (a *Account) insert(l *Ledger) error {
+	if len(a.Kind) == 0 {
+		a.Kind = AccountAsset
+	}
+
+	stmt, err := l.db.Prepare(INS_ACCOUNT)
 	if err != nil {
 		return fmt.Errorf("Error preparing account for insert: %s", err)
 	}
 
-	_, err = stmt.Exec(sql.Named("name", a.Name))
+	var id any = nil
+	if a.Id > 0 {
+		id = a.Id
+	}
+
+	result, err := stmt.Exec(sql.Named("id", id), sql.Named("name", a.Name), sql.Named("kind", a.Kind))
 	if err != nil {
 		return fmt.Errorf("Error inserting account: %s", err)
 	}
 
+	if newId, err := result.LastInsertId(); err == nil {
+		a.Id = int(newId)
+	}
+
 	return nil
 }
 
-func (a *Account) update() error {
-	return fmt.Errorf("Not yet supported")
+func (a *Account) update(l *Ledger) error {
+	_, err := l.db.Exec("update accounts set name = @name, kind = @kind where id = @id",
+		sql.Named("id", a.Id),
+		sql.Named("name", a.Name),
+		sql.Named("kind", a.Kind),
+	)
+	if err != nil {
+		return fmt.Errorf("Error updating account: %s", err)
+	}
+
+	return nil
 }
 
-func (a *Account) delete() error {
-	return fmt.Errorf("Not yet supported")
+// delete only removes accounts nothing refers to. The first account is the
+// default every page opens on, so it always stays.
+func (a *Account) delete(l *Ledger) error {
+	if a.Id == 1 {
+		return ErrAccountInUse
+	}
+
+	var count int
+	err := l.db.QueryRow(Q_ACCOUNT_REFERENCES, sql.Named("id", a.Id)).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("Error checking account: %s", err)
+	}
+
+	if count > 0 {
+		return ErrAccountInUse
+	}
+
+	_, err = l.db.Exec("delete from transactions where account_id = @id", sql.Named("id", a.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting account: %s", err)
+	}
+
+	_, err = l.db.Exec("delete from accounts where id = @id", sql.Named("id", a.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting account: %s", err)
+	}
+
+	return nil
+}
+
+func (l *Ledger) getAccount(id int) (Account, error) {
+	return getAccountAsOf(l.db, id, time.UnixMilli(math.MaxInt64))
 }
 
-func getAccount(id int) (Account, error) {
-	stmt, err := dbc.db.Prepare(Q_GET_ACCOUNT)
+// getAccountAsOf totals every transaction dated before asOf. The starting
+// balance carried in by rollover is always counted, since it stands in for
+// everything that happened before the period began.
+func getAccountAsOf(pdb *sql.DB, id int, asOf time.Time) (Account, error) {
+	stmt, err := pdb.Prepare(Q_GET_ACCOUNT)
 	if err != nil {
 		return Account{}, fmt.Errorf("Error preparing fetching account: %s", err)
 	}
 
-	row := stmt.QueryRow(sql.Named("id", id))
+	defer stmt.Close()
 
-	var aid int
-	var name string
-	var total int64
-	err = row.Scan(&aid, &name, &total)
+	row := stmt.QueryRow(
+		sql.Named("id", id),
+		sql.Named("as_of", asOf.UnixMilli()),
+	)
+
+	var account Account
+	err = row.Scan(&account.Id, &account.Name, &account.Kind, &account.TotalAvailable)
 	if err != nil {
-		return Account{}, fmt.Errorf("Error reading account: %s", err)
+		return Account{}, fmt.Errorf("Error reading account: %w", err)
 	}
 
-	return Account{
-		Id:             aid,
-		Name:           name,
-		TotalAvailable: total,
-	}, nil
+	return account, nil
+}
 
+func (l *Ledger) FetchAllAccounts() ([]Account, error) {
+	return queryAccounts(l.db)
 }
 
-func fetchAllAccounts() ([]Account, error) {
-	stmt, err := dbc.db.Prepare("select a.id, a.name from accounts a order by a.Name")
+func queryAccounts(pdb *sql.DB) ([]Account, error) {
+	stmt, err := pdb.Prepare("select a.id, a.name, a.kind from accounts a order by a.Name")
 	if err != nil {
 		return nil, fmt.Errorf("Error preparing to fetch accounts: %s", err)
 	}
 
+	defer stmt.Close()
+
 	rows, err := stmt.Query()
 	if err != nil {
 		return nil, fmt.Errorf("Error fetching accounts: %s", err)
 	}
 
+	defer rows.Close()
+
 	var id int
 	var name string
+	var kind string
 	var accounts []Account
 	for rows.Next() {
-		err = rows.Scan(&id, &name)
+		err = rows.Scan(&id, &name, &kind)
 		if err != nil {
 			return nil, fmt.Errorf("Error reading accounts: %s", err)
 		}
@@ -80,26 +166,39 @@ func fetchAllAccounts() ([]Account, error) {
 		accounts = append(accounts, Account{
 			Id:   id,
 			Name: name,
+			Kind: kind,
 		})
 	}
 
 	return accounts, nil
 }
 
+const Q_ACCOUNT_REFERENCES = `
+	select (select count(1) from transactions where account_id = @id and is_starting_balance = 0)
+	     + (select count(1) from recurrings where account_id = @id)
+`
+
+const INS_ACCOUNT = `
+	insert into accounts(id, name, kind) values(@id, @name, @kind);
+`
+
 const Q_GET_ACCOUNT = `
 	select a.id
 	     , a.name
+	     , a.kind
 	     , coalesce(sum(t.amount), 0) as total_available
 	from accounts a
 	left join transactions t on a.id = t.account_id
+	     and (t.transaction_date < @as_of or t.is_starting_balance = 1)
 	where a.id = @id
-	group by a.id, a.name
+	group by a.id, a.name, a.kind
 `
 
 const CT_ACCOUNT = `
 	create table if not exists accounts (
 		id integer primary key,
-	    name varchar(100)
+	    name varchar(100),
+	    kind varchar(20) not null default 'asset'
 	);
 `
 
@@ -1,28 +1,148 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
+	"strings"
 )
 
 type Category struct {
+	Id        int
+	AccountId int
+	Name      string
 }
 
-func (c *Category) insert() error {
-	return fmt.Errorf("Not yet implemented.")
+func (c *Category) insert(l *Ledger) error {
+	var id any = nil
+	if c.Id > 0 {
+		id = c.Id
+	}
+
+	result, err := l.db.Exec(INS_CATEGORY,
+		sql.Named("id", id),
+		sql.Named("account_id", c.AccountId),
+		sql.Named("name", c.Name),
+	)
+	if err != nil {
+		return fmt.Errorf("Error inserting category: %s", err)
+	}
+
+	if newId, err := result.LastInsertId(); err == nil {
+		c.Id = int(newId)
+	}
+
+	return nil
+}
+
+func (c *Category) update(l *Ledger) error {
+	_, err := l.db.Exec("update categories set name = @name where id = @id",
+		sql.Named("id", c.Id),
+		sql.Named("name", c.Name),
+	)
+	if err != nil {
+		return fmt.Errorf("Error updating category: %s", err)
+	}
+
+	return nil
+}
+
+func (c *Category) delete(l *Ledger) error {
+	_, err := l.db.Exec("update transactions set category_id = null where category_id = @id", sql.Named("id", c.Id))
+	if err != nil {
+		return fmt.Errorf("Error clearing category from transactions: %s", err)
+	}
+
+	_, err = l.db.Exec("delete from categories where id = @id", sql.Named("id", c.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting category: %s", err)
+	}
+
+	return nil
+}
+
+func (l *Ledger) FetchAllCategories() ([]Category, error) {
+	rows, err := l.db.Query("select id, coalesce(account_id, 0), name from categories order by name")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching categories: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []Category
+	for rows.Next() {
+		var c Category
+		if err = rows.Scan(&c.Id, &c.AccountId, &c.Name); err != nil {
+			return nil, fmt.Errorf("Error reading categories: %s", err)
+		}
+		results = append(results, c)
+	}
+
+	return results, nil
 }
 
-func (c *Category) update() error {
-	return fmt.Errorf("Not yet implemented.")
+func GetCategory(id int) (*Category, error) {
+	return CurrentLedger().GetCategory(id)
 }
 
-func (c *Category) delete() error {
-	return fmt.Errorf("Not yet implemented.")
+// GetCategory returns the category with the id, or nil when there isn't one.
+func (l *Ledger) GetCategory(id int) (*Category, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf(DbInitError)
+	}
+
+	var c Category
+	err := l.db.QueryRow("select id, coalesce(account_id, 0), name from categories where id = @id", sql.Named("id", id)).
+		Scan(&c.Id, &c.AccountId, &c.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading category: %s", err)
+	}
+
+	return &c, nil
 }
 
+// ensureCategoryIn looks a category up by name, ignoring case, and creates it
+// when it doesn't exist yet.
+func ensureCategoryIn(pdb *sql.DB, name string) (int, error) {
+	name = strings.TrimSpace(name)
+
+	var id int
+	err := pdb.QueryRow("select id from categories where lower(name) = lower(@name)", sql.Named("name", name)).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("Error looking up category: %s", err)
+	}
+
+	result, err := pdb.Exec(INS_CATEGORY,
+		sql.Named("id", nil),
+		sql.Named("account_id", 0),
+		sql.Named("name", name),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Error inserting category: %s", err)
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("Error inserting category: %s", err)
+	}
+
+	return int(newId), nil
+}
+
+const INS_CATEGORY = `
+	insert into categories(id, account_id, name) values(@id, @account_id, @name);
+`
+
 const CT_CATEGORIES = `
 	create table if not exists categories (
 		id integer primary key,
-		account_id integer
+		account_id integer,
 		name varchar(100),
 		foreign key(account_id) references accounts(id)
 	);
@@ -5,20 +5,28 @@ import (
 	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
-	"log"
 	"os"
 	"time"
 )
 
+// Crudder is a record Insert, Update and Delete can save. Records kept in a
+// period are saved to the Ledger they are given; the ones kept in the store
+// ignore it.
 type Crudder interface {
-	insert() error
-	delete() error
-	update() error
+	insert(l *Ledger) error
+	delete(l *Ledger) error
+	update(l *Ledger) error
+}
+
+// Ledger is one period's database. The functions that don't take one use
+// the period InitDatabase opened, and OpenLedger opens another alongside it
+// without changing what they see.
+type Ledger struct {
+	db *sql.DB
 }
 
 type dbContext struct {
-	db               *sql.DB
-	currentAccountId int
+	db *sql.DB
 }
 
 var (
@@ -27,49 +35,82 @@ var (
 
 const DbInitError = "Database not initialized. Call InitDatabase() before calling any other database functions. (Also defer CloseDatabase())"
 
+// InitDatabase opens the period file at dbPath, creating it when it doesn't
+// exist, as the one the functions without a Ledger use. With isRollover the
+// new period starts from the open one's accounts, balances, recurring
+// transactions and categories; if that fails the open period stays open.
 func InitDatabase(dbPath string, isRollover bool) error {
-	var currentAccount Account
+	var accounts []Account
 	var recurrings []Recurring
+	var categories []Category
 	if isRollover {
-		a, err := getAccount(dbc.currentAccountId)
+		current := CurrentLedger()
+		all, err := current.FetchAllAccounts()
 		if err != nil {
-			return fmt.Errorf("Error getting current account information for rollover: %s", err)
+			return fmt.Errorf("Error getting account information for rollover: %s", err)
 		}
-		currentAccount = a
 
-		r, err := fetchAllRecurrings()
-		if err != nil {
-			return fmt.Errorf("Error getting recurring transactions for rollover: %s", err)
+		for _, a := range all {
+			a, err = current.getAccount(a.Id)
+			if err != nil {
+				return fmt.Errorf("Error getting account information for rollover: %s", err)
+			}
+			accounts = append(accounts, a)
 		}
-		recurrings = r
 
-		if dbc.db != nil {
-			dbc.db.Close()
-			dbc.db = nil
+		for _, a := range accounts {
+			r, err := current.FetchRecurringsFor(a.Id)
+			if err != nil {
+				return fmt.Errorf("Error getting recurring transactions for rollover: %s", err)
+			}
+			recurrings = append(recurrings, r...)
 		}
+
+		c, err := current.FetchAllCategories()
+		if err != nil {
+			return fmt.Errorf("Error getting categories for rollover: %s", err)
+		}
+		categories = c
 	}
 
+	created := false
 	_, existErr := os.Stat(dbPath)
 	if errors.Is(existErr, os.ErrNotExist) {
 		tdb, cErr := createSchema(dbPath)
 		if cErr != nil {
-			panic(fmt.Sprintf("Couldn't create the database: %s\n", cErr))
+			return fmt.Errorf("Couldn't create the database: %s", cErr)
 		}
 		tdb.Close()
+		created = true
 	}
 
 	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
+	if err != nil {
+		return fmt.Errorf("Error opening database: %s", err)
+	}
 	db.SetMaxOpenConns(1)
-	dbc.db = db
+
+	if err = migrateSchema(db); err != nil {
+		db.Close()
+		return err
+	}
 
 	if isRollover {
-		err := rolloverDatabase(currentAccount, recurrings)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Error occurred during rollover: %s\n", err))
+		if err = rolloverDatabase(&Ledger{db: db}, accounts, recurrings, categories); err != nil {
+			db.Close()
+			if created {
+				os.Remove(dbPath)
+			}
+			return fmt.Errorf("Error occurred during rollover: %s", err)
 		}
 	}
 
-	return err
+	if dbc.db != nil {
+		dbc.db.Close()
+	}
+	dbc.db = db
+
+	return nil
 }
 
 func CloseDatabase() error {
@@ -83,60 +124,156 @@ func CloseDatabase() error {
 	return nil
 }
 
+// CurrentLedger is the period InitDatabase opened.
+func CurrentLedger() *Ledger {
+	return &Ledger{db: dbc.db}
+}
+
+// OpenLedger opens the period file at dbPath, which has to exist already, on
+// its own connection. Close it when done.
+func OpenLedger(dbPath string) (*Ledger, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("Error opening period: %s", err)
+	}
+
+	pdb, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database: %s", err)
+	}
+	pdb.SetMaxOpenConns(1)
+
+	if err = migrateSchema(pdb); err != nil {
+		pdb.Close()
+		return nil, err
+	}
+
+	return &Ledger{db: pdb}, nil
+}
+
+// Close closes a ledger from OpenLedger.
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
 func Insert(c Crudder) error {
-	return c.insert()
+	return CurrentLedger().Insert(c)
 }
 
 func Delete(c Crudder) error {
-	return c.delete()
+	return CurrentLedger().Delete(c)
 }
 
 func Update(c Crudder) error {
-	return c.update()
+	return CurrentLedger().Update(c)
+}
+
+func (l *Ledger) Insert(c Crudder) error {
+	return c.insert(l)
+}
+
+func (l *Ledger) Delete(c Crudder) error {
+	return c.delete(l)
 }
 
-func FetchAllTransactions() ([]Transaction, error) {
-	return fetchAllTransactions()
+func (l *Ledger) Update(c Crudder) error {
+	return c.update(l)
 }
 
-func FetchAllRecurrings() ([]Recurring, error) {
-	return fetchAllRecurrings()
+func FetchAllCategories() ([]Category, error) {
+	return CurrentLedger().FetchAllCategories()
 }
 
 func FetchAllAccounts() ([]Account, error) {
-	return fetchAllAccounts()
+	return CurrentLedger().FetchAllAccounts()
+}
+
+// FetchAccounts returns the accounts in the set from the open period.
+func FetchAccounts(accounts AccountSet) ([]Account, error) {
+	return CurrentLedger().FetchAccounts(accounts)
+}
+
+// FetchAccounts returns the accounts in the set.
+func (l *Ledger) FetchAccounts(accounts AccountSet) ([]Account, error) {
+	all, err := l.FetchAllAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Account
+	for _, a := range all {
+		if accounts.Allows(a.Id) {
+			results = append(results, a)
+		}
+	}
+
+	return results, nil
 }
 
 func GetDefaultAccount() (Account, error) {
 	if dbc.db == nil {
 		return Account{}, fmt.Errorf(DbInitError)
 	}
-	account, err := getAccount(1)
-	dbc.currentAccountId = account.Id
-	return account, err
+	return CurrentLedger().getAccount(1)
+}
+
+func GetAccount(id int) (Account, error) {
+	return CurrentLedger().GetAccount(id)
+}
+
+func (l *Ledger) GetAccount(id int) (Account, error) {
+	if l.db == nil {
+		return Account{}, fmt.Errorf(DbInitError)
+	}
+	return l.getAccount(id)
 }
 
-func CreateTransactionFromRecurring(id int) error {
-	recurring, err := getRecurringById(id)
+func FindAccount(id int) (*Account, error) {
+	return CurrentLedger().FindAccount(id)
+}
+
+// FindAccount is GetAccount for ids that may not exist: it returns nil
+// rather than an error when there's no such account.
+func (l *Ledger) FindAccount(id int) (*Account, error) {
+	account, err := l.GetAccount(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func CreateTransactionFromRecurring(id int, userId int) error {
+	return CurrentLedger().CreateTransactionFromRecurring(id, userId)
+}
+
+// CreateTransactionFromRecurring adds the recurring transaction to its
+// account in the ledger, on behalf of userId.
+func (l *Ledger) CreateTransactionFromRecurring(id int, userId int) error {
+	recurring, err := l.getRecurringById(id)
 	if err != nil {
 		return err
 	}
 
 	newTrans := &Transaction{
-		Name:   recurring.Name,
-		Amount: recurring.Amount,
-		Date:   time.Now(),
+		AccountId: recurring.AccountId,
+		Name:      recurring.Name,
+		Amount:    recurring.Amount,
+		Date:      time.Now(),
+		CreatedBy: userId,
 	}
 
-	return newTrans.insert()
+	return newTrans.insert(l)
 }
 
-func GetRecurringNetBalance() (int64, error) {
-	return getNetRecurringBalance()
+func HasAccount() bool {
+	return CurrentLedger().HasAccount()
 }
 
-func HasAccount() bool {
-	result, err := dbc.db.Query("select count(1) from accounts;")
+func (l *Ledger) HasAccount() bool {
+	result, err := l.db.Query("select count(1) from accounts;")
 	if err != nil {
 		return false
 	}
@@ -165,10 +302,129 @@ func createSchema(db_path string) (*sql.DB, error) {
 	createTable(db, CT_CATEGORIES)
 	createTable(db, CT_TRANSACTIONS)
 	createTable(db, CT_RECURRINGS)
+	createTable(db, CT_TRANSACTION_SPLITS)
 
 	return db, nil
 }
 
+// migrateSchema brings period files created by older versions up to the
+// current schema. Every step must be safe to run more than once.
+func migrateSchema(db *sql.DB) error {
+	hasName, err := hasColumn(db, "categories", "name")
+	if err != nil {
+		return err
+	}
+
+	// The original categories definition was missing a comma, which left the
+	// table without a name column. Nothing could be inserted into it, so it is
+	// safe to rebuild.
+	if !hasName {
+		if err = createTable(db, "drop table if exists categories;"); err != nil {
+			return fmt.Errorf("Error migrating categories: %s", err)
+		}
+		if err = createTable(db, CT_CATEGORIES); err != nil {
+			return fmt.Errorf("Error migrating categories: %s", err)
+		}
+	}
+
+	if err = addColumn(db, "accounts", "kind", "varchar(20) not null default 'asset'"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "check_number", "varchar(20)"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "fitid", "varchar(255)"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "memo", "varchar(1000)"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "payee", "varchar(100)"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "display_name", "varchar(100)"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "tags", "varchar(255)"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "created_by", "integer"); err != nil {
+		return err
+	}
+
+	if err = addColumn(db, "transactions", "edited_by", "integer"); err != nil {
+		return err
+	}
+
+	if err = createTable(db, CT_TRANSACTION_SPLITS); err != nil {
+		return fmt.Errorf("Error creating transaction splits: %s", err)
+	}
+
+	// Starting balances used to be known only by their name. When the column
+	// is added, the ones from back then are marked by it, once.
+	hasStartingBalance, err := hasColumn(db, "transactions", "is_starting_balance")
+	if err != nil {
+		return err
+	}
+
+	if !hasStartingBalance {
+		if err = addColumn(db, "transactions", "is_starting_balance", "integer not null default 0"); err != nil {
+			return err
+		}
+
+		_, err = db.Exec("update transactions set is_starting_balance = 1 where name = @starting_balance;",
+			sql.Named("starting_balance", StartingBalanceName))
+		if err != nil {
+			return fmt.Errorf("Error marking starting balances: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func addColumn(db *sql.DB, table string, column string, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("alter table %s add column %s %s;", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("Error adding %s to %s: %s", column, table, err)
+	}
+
+	return nil
+}
+
+func hasColumn(db *sql.DB, table string, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("select name from pragma_table_info('%s');", table))
+	if err != nil {
+		return false, fmt.Errorf("Error reading columns for %s: %s", table, err)
+	}
+
+	defer rows.Close()
+
+	var name string
+	for rows.Next() {
+		if err = rows.Scan(&name); err != nil {
+			return false, fmt.Errorf("Error reading columns for %s: %s", table, err)
+		}
+
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func createTable(db *sql.DB, statement string) error {
 	stmt, err := db.Prepare(statement)
 	if err != nil {
@@ -183,24 +439,34 @@ func createTable(db *sql.DB, statement string) error {
 	return nil
 }
 
-func rolloverDatabase(account Account, recurrings []Recurring) error {
-	if err := account.insert(); err != nil {
-		return fmt.Errorf("Error rolling over account information: %s", err)
+func rolloverDatabase(l *Ledger, accounts []Account, recurrings []Recurring, categories []Category) error {
+	for _, account := range accounts {
+		if err := account.insert(l); err != nil {
+			return fmt.Errorf("Error rolling over account information: %s", err)
+		}
+
+		initialTransaction := &Transaction{
+			AccountId:       account.Id,
+			Name:            StartingBalanceName,
+			Amount:          account.TotalAvailable,
+			Date:            time.Now(),
+			StartingBalance: true,
+		}
+		if err := initialTransaction.insert(l); err != nil {
+			return fmt.Errorf("Error creating initial transaction for starting balance.")
+		}
 	}
 
 	for _, r := range recurrings {
-		if err := r.insert(); err != nil {
+		if err := r.insert(l); err != nil {
 			return fmt.Errorf("Error rolling over recurring transactions: %s", err)
 		}
 	}
 
-	initialTransaction := &Transaction{
-		Name:   "Starting Balance",
-		Amount: account.TotalAvailable,
-		Date:   time.Now(),
-	}
-	if err := initialTransaction.insert(); err != nil {
-		return fmt.Errorf("Error creating initial transaction for starting balance.")
+	for _, c := range categories {
+		if err := c.insert(l); err != nil {
+			return fmt.Errorf("Error rolling over categories: %s", err)
+		}
 	}
 
 	return nil
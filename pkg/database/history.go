@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// HistoryEntry records a change to the periods themselves rather than to
+// what is in them: a rollover, undoing one, or carrying balances forward
+// after an earlier period was edited. The history lives in the store, so it
+// survives the periods it talks about.
+type HistoryEntry struct {
+	Id     int
+	Time   time.Time
+	UserId int
+	Action string
+	Period Period
+}
+
+const (
+	HistoryRollover      = "rollover"
+	HistoryUndoRollover  = "undo-rollover"
+	HistoryCarryBalances = "carry-balances"
+)
+
+// RecordHistory adds an entry to the history, stamped with the current time
+// when it has none.
+func RecordHistory(e HistoryEntry) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	_, err := store.Exec(INS_HISTORY,
+		sql.Named("time", e.Time.UnixMilli()),
+		sql.Named("user_id", e.UserId),
+		sql.Named("action", e.Action),
+		sql.Named("year", e.Period.Year),
+		sql.Named("month", int(e.Period.Month)),
+	)
+	if err != nil {
+		return fmt.Errorf("Error recording history: %s", err)
+	}
+
+	return nil
+}
+
+// FetchHistory returns the newest limit entries, newest first.
+func FetchHistory(limit int) ([]HistoryEntry, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(Q_HISTORY, sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching history: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		var at int64
+		var month int
+		if err = rows.Scan(&e.Id, &at, &e.UserId, &e.Action, &e.Period.Year, &month); err != nil {
+			return nil, fmt.Errorf("Error reading history: %s", err)
+		}
+
+		e.Time = time.UnixMilli(at)
+		e.Period.Month = time.Month(month)
+		results = append(results, e)
+	}
+
+	return results, nil
+}
+
+const INS_HISTORY = `
+	insert into history (time, user_id, action, year, month)
+	values (@time, @user_id, @action, @year, @month)
+`
+
+const Q_HISTORY = `
+	select id, time, user_id, action, year, month
+	from history
+	order by time desc, id desc
+	limit @limit
+`
+
+const CT_HISTORY = `
+	create table if not exists history (
+		id integer primary key,
+		time integer,
+		user_id integer,
+		action varchar(50),
+		year integer,
+		month integer
+	);
+`
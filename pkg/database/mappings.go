@@ -0,0 +1,208 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// CsvMapping describes how one bank lays out its CSV download. Columns are
+// numbered from 1 and 0 means the column is not present. Either Amount or
+// one of Debit/Credit must be mapped.
+type CsvMapping struct {
+	Id                int
+	Name              string
+	HasHeader         bool
+	Delimiter         string
+	DateColumn        int
+	DescriptionColumn int
+	AmountColumn      int
+	DebitColumn       int
+	CreditColumn      int
+	CheckNumberColumn int
+	DateFormat        string
+	NegateAmounts     bool
+}
+
+func (m *CsvMapping) insert(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	result, err := store.Exec(INS_CSV_MAPPING, m.namedArgs()...)
+	if err != nil {
+		return fmt.Errorf("Error inserting csv mapping: %s", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		m.Id = int(id)
+	}
+
+	return nil
+}
+
+func (m *CsvMapping) update(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	args := append(m.namedArgs(), sql.Named("id", m.Id))
+	_, err := store.Exec(UPD_CSV_MAPPING, args...)
+	if err != nil {
+		return fmt.Errorf("Error updating csv mapping: %s", err)
+	}
+
+	return nil
+}
+
+func (m *CsvMapping) delete(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	_, err := store.Exec("delete from csv_mappings where id = @id", sql.Named("id", m.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting csv mapping: %s", err)
+	}
+
+	return nil
+}
+
+func (m *CsvMapping) namedArgs() []any {
+	return []any{
+		sql.Named("name", m.Name),
+		sql.Named("has_header", m.HasHeader),
+		sql.Named("delimiter", m.Delimiter),
+		sql.Named("date_column", m.DateColumn),
+		sql.Named("description_column", m.DescriptionColumn),
+		sql.Named("amount_column", m.AmountColumn),
+		sql.Named("debit_column", m.DebitColumn),
+		sql.Named("credit_column", m.CreditColumn),
+		sql.Named("check_number_column", m.CheckNumberColumn),
+		sql.Named("date_format", m.DateFormat),
+		sql.Named("negate_amounts", m.NegateAmounts),
+	}
+}
+
+// SaveCsvMapping stores m under its name, replacing any mapping already saved
+// for that bank.
+func SaveCsvMapping(m *CsvMapping) error {
+	existing, err := GetCsvMapping(m.Name)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		return Insert(m)
+	}
+
+	m.Id = existing.Id
+	return Update(m)
+}
+
+func GetCsvMapping(name string) (*CsvMapping, error) {
+	mappings, err := queryCsvMappings(Q_CSV_MAPPINGS+" where name = @name", sql.Named("name", name))
+	if err != nil || len(mappings) == 0 {
+		return nil, err
+	}
+
+	return &mappings[0], nil
+}
+
+func FetchAllCsvMappings() ([]CsvMapping, error) {
+	return queryCsvMappings(Q_CSV_MAPPINGS + " order by name")
+}
+
+func queryCsvMappings(query string, args ...any) ([]CsvMapping, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching csv mappings: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []CsvMapping
+	for rows.Next() {
+		var m CsvMapping
+		err = rows.Scan(&m.Id, &m.Name, &m.HasHeader, &m.Delimiter, &m.DateColumn,
+			&m.DescriptionColumn, &m.AmountColumn, &m.DebitColumn, &m.CreditColumn,
+			&m.CheckNumberColumn, &m.DateFormat, &m.NegateAmounts)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading csv mappings: %s", err)
+		}
+
+		results = append(results, m)
+	}
+
+	return results, nil
+}
+
+const CT_CSV_MAPPINGS = `
+	create table if not exists csv_mappings (
+		id integer primary key,
+		name varchar(100) unique,
+		has_header integer,
+		delimiter varchar(1),
+		date_column integer,
+		description_column integer,
+		amount_column integer,
+		debit_column integer,
+		credit_column integer,
+		check_number_column integer,
+		date_format varchar(40),
+		negate_amounts integer
+	);
+`
+
+const Q_CSV_MAPPINGS = `
+	select id
+	     , name
+	     , has_header
+	     , delimiter
+	     , date_column
+	     , description_column
+	     , amount_column
+	     , debit_column
+	     , credit_column
+	     , check_number_column
+	     , date_format
+	     , negate_amounts
+	from csv_mappings
+`
+
+const INS_CSV_MAPPING = `
+	insert into csv_mappings (
+		  name
+		, has_header
+		, delimiter
+		, date_column
+		, description_column
+		, amount_column
+		, debit_column
+		, credit_column
+		, check_number_column
+		, date_format
+		, negate_amounts)
+	values (@name, @has_header, @delimiter, @date_column, @description_column, @amount_column,
+	        @debit_column, @credit_column, @check_number_column, @date_format, @negate_amounts)
+`
+
+const UPD_CSV_MAPPING = `
+	update csv_mappings
+	set name = @name,
+	    has_header = @has_header,
+	    delimiter = @delimiter,
+	    date_column = @date_column,
+	    description_column = @description_column,
+	    amount_column = @amount_column,
+	    debit_column = @debit_column,
+	    credit_column = @credit_column,
+	    check_number_column = @check_number_column,
+	    date_format = @date_format,
+	    negate_amounts = @negate_amounts
+	where id = @id;
+`
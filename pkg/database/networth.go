@@ -0,0 +1,100 @@
+package database
+
+import (
+	"fmt"
+	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+type NetWorth struct {
+	AsOf        time.Time
+	Accounts    []Account
+	Assets      utils.Money
+	Liabilities utils.Money
+	Total       utils.Money
+}
+
+type NetWorthMonth struct {
+	Period Period
+	NetWorth
+}
+
+// NetWorthAsOf totals the accounts in the set as they stood at the start of
+// asOf. The balances come from the period file covering that date, or the
+// latest one before it when no period was opened for that month.
+func NetWorthAsOf(dir string, asOf time.Time, accounts AccountSet) (NetWorth, error) {
+	periods, err := ListPeriods(dir)
+	if err != nil {
+		return NetWorth{}, err
+	}
+
+	var source *Period
+	for i := range periods {
+		if periods[i].Start().Before(asOf) {
+			source = &periods[i]
+		}
+	}
+
+	if source == nil {
+		return NetWorth{AsOf: asOf, Accounts: []Account{}}, nil
+	}
+
+	return netWorthInPeriod(dir, *source, asOf, accounts)
+}
+
+// NetWorthHistory reports the net worth at the close of every month from
+// first through last inclusive.
+func NetWorthHistory(dir string, first Period, last Period, accounts AccountSet) ([]NetWorthMonth, error) {
+	if last.Before(first) {
+		return nil, fmt.Errorf("Net worth history must end after it starts.")
+	}
+
+	var results []NetWorthMonth
+	for p := first; !last.Before(p); p = p.Next() {
+		nw, err := NetWorthAsOf(dir, p.End(), accounts)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, NetWorthMonth{Period: p, NetWorth: nw})
+	}
+
+	return results, nil
+}
+
+func netWorthInPeriod(dir string, p Period, asOf time.Time, only AccountSet) (NetWorth, error) {
+	pdb, err := openPeriod(dir, p)
+	if err != nil {
+		return NetWorth{}, err
+	}
+
+	defer pdb.Close()
+
+	accounts, err := queryAccounts(pdb)
+	if err != nil {
+		return NetWorth{}, err
+	}
+
+	nw := NetWorth{AsOf: asOf, Accounts: []Account{}}
+	for _, a := range accounts {
+		if !only.Allows(a.Id) {
+			continue
+		}
+
+		account, err := getAccountAsOf(pdb, a.Id, asOf)
+		if err != nil {
+			return NetWorth{}, err
+		}
+
+		if account.Kind == AccountLiability {
+			nw.Liabilities += account.TotalAvailable
+		} else {
+			nw.Assets += account.TotalAvailable
+		}
+
+		nw.Accounts = append(nw.Accounts, account)
+	}
+
+	nw.Total = nw.Assets + nw.Liabilities
+	return nw, nil
+}
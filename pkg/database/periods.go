@@ -0,0 +1,468 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+const StartingBalanceName = "Starting Balance"
+
+type Period struct {
+	Year  int
+	Month time.Month
+}
+
+func PeriodOf(t time.Time) Period {
+	return Period{Year: t.Year(), Month: t.Month()}
+}
+
+func ParsePeriod(fileName string) (Period, bool) {
+	if !strings.HasSuffix(fileName, ".db") {
+		return Period{}, false
+	}
+
+	t, err := time.Parse("2006January", strings.TrimSuffix(fileName, ".db"))
+	if err != nil {
+		return Period{}, false
+	}
+
+	return PeriodOf(t), true
+}
+
+func (p Period) FileName() string {
+	return fmt.Sprintf("%d%s.db", p.Year, p.Month)
+}
+
+func (p Period) YearString() string {
+	return strconv.Itoa(p.Year)
+}
+
+func (p Period) Start() time.Time {
+	return time.Date(p.Year, p.Month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (p Period) End() time.Time {
+	return p.Start().AddDate(0, 1, 0)
+}
+
+func (p Period) Next() Period {
+	return PeriodOf(p.Start().AddDate(0, 1, 0))
+}
+
+func (p Period) Previous() Period {
+	return PeriodOf(p.Start().AddDate(0, -1, 0))
+}
+
+func (p Period) Before(o Period) bool {
+	return p.Start().Before(o.Start())
+}
+
+func ListPeriods(dir string) ([]Period, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading data directory: %s", err)
+	}
+
+	var periods []Period
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		if p, ok := ParsePeriod(e.Name()); ok {
+			periods = append(periods, p)
+		}
+	}
+
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].Before(periods[j])
+	})
+
+	return periods, nil
+}
+
+func openPeriod(dir string, p Period) (*sql.DB, error) {
+	pdb, err := sql.Open("sqlite3", filepath.Join(dir, p.FileName()))
+	if err != nil {
+		return nil, fmt.Errorf("Error opening period %s %d: %s", p.Month, p.Year, err)
+	}
+
+	if err = migrateSchema(pdb); err != nil {
+		pdb.Close()
+		return nil, err
+	}
+
+	return pdb, nil
+}
+
+// FetchTransactionsBetween reads every period file in dir and returns the
+// transactions dated inside [from, to). A period can hold entries dated
+// outside its month, so no file is skipped based on its name. Starting
+// balance entries created by rollover are left out since they only carry the
+// previous period's total forward. Only the accounts in the set are read.
+func FetchTransactionsBetween(dir string, from time.Time, to time.Time, accounts AccountSet) ([]Transaction, error) {
+	periods, err := ListPeriods(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Transaction
+	for _, p := range periods {
+		pdb, err := openPeriod(dir, p)
+		if err != nil {
+			return nil, err
+		}
+
+		transactions, err := queryTransactionsBetween(pdb, from, to)
+		pdb.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range transactions {
+			if accounts.Allows(t.AccountId) {
+				t.Period = p
+				results = append(results, t)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// FetchImportedFitIds returns the FITIDs of every transaction imported into
+// the account from a bank statement, across all periods.
+func FetchImportedFitIds(dir string, accountId int) (map[string]bool, error) {
+	periods, err := ListPeriods(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]bool{}
+	for _, p := range periods {
+		pdb, err := openPeriod(dir, p)
+		if err != nil {
+			return nil, err
+		}
+
+		err = queryFitIds(pdb, accountId, results)
+		pdb.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func queryFitIds(pdb *sql.DB, accountId int, results map[string]bool) error {
+	rows, err := pdb.Query(Q_FITIDS, sql.Named("account_id", accountId))
+	if err != nil {
+		return fmt.Errorf("Error fetching imported FITIDs: %s", err)
+	}
+
+	defer rows.Close()
+
+	var fitId string
+	for rows.Next() {
+		if err = rows.Scan(&fitId); err != nil {
+			return fmt.Errorf("Error reading imported FITIDs: %s", err)
+		}
+		results[fitId] = true
+	}
+
+	return nil
+}
+
+func queryTransactionsBetween(pdb *sql.DB, from time.Time, to time.Time) ([]Transaction, error) {
+	return queryTransactions(pdb, Q_TRANSACTIONS_BETWEEN,
+		sql.Named("from", from.UnixMilli()),
+		sql.Named("to", to.UnixMilli()),
+	)
+}
+
+// queryTransactions runs a query built on Q_TRANSACTION_DETAILS and reads
+// every field of the transactions it returns, splits included.
+func queryTransactions(pdb *sql.DB, query string, args ...any) ([]Transaction, error) {
+	splits, err := querySplits(pdb)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pdb.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching transactions: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []Transaction
+	for rows.Next() {
+		var t Transaction
+		var date int64
+		var categoryId sql.NullInt64
+		var category, memo, checkNumber, fitId, payee, displayName, tags sql.NullString
+		var createdBy, editedBy sql.NullInt64
+		err = rows.Scan(&t.Id, &t.AccountId, &t.Name, &t.Amount, &date,
+			&categoryId, &category, &memo, &checkNumber, &fitId, &payee, &displayName, &tags,
+			&createdBy, &editedBy)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading transactions: %s", err)
+		}
+
+		t.Date = time.UnixMilli(date).UTC()
+		t.CategoryId = int(categoryId.Int64)
+		t.Category = category.String
+		t.Memo = memo.String
+		t.CheckNumber = checkNumber.String
+		t.FitId = fitId.String
+		t.Payee = payee.String
+		t.DisplayName = displayName.String
+		t.Tags = tags.String
+		t.CreatedBy = int(createdBy.Int64)
+		t.EditedBy = int(editedBy.Int64)
+		t.Splits = splits[t.Id]
+		results = append(results, t)
+	}
+
+	return results, nil
+}
+
+// FetchPeriodTransactions returns everything stored in one period file,
+// including the starting balances rollover carried in.
+func FetchPeriodTransactions(dir string, p Period) ([]Transaction, error) {
+	pdb, err := openPeriod(dir, p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer pdb.Close()
+
+	transactions, err := queryTransactions(pdb, Q_TRANSACTION_DETAILS+`
+	order by t.transaction_date
+	        ,t.timestamp_added`)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range transactions {
+		transactions[i].Period = p
+	}
+
+	return transactions, nil
+}
+
+// CountEnteredTransactions counts the transactions in a period other than
+// the starting balances rollover carried in, which is what would be lost by
+// removing it.
+func CountEnteredTransactions(dir string, p Period) (int, error) {
+	pdb, err := openPeriod(dir, p)
+	if err != nil {
+		return 0, err
+	}
+
+	defer pdb.Close()
+
+	var count int
+	err = pdb.QueryRow("select count(1) from transactions where is_starting_balance = 0").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("Error counting transactions: %s", err)
+	}
+
+	return count, nil
+}
+
+// RemovePeriod deletes a period's file. The period must not be open.
+func RemovePeriod(dir string, p Period) error {
+	if err := os.Remove(filepath.Join(dir, p.FileName())); err != nil {
+		return fmt.Errorf("Error removing period %s %d: %s", p.Month, p.Year, err)
+	}
+	return nil
+}
+
+// StaleBalancesAfter reports whether any period after p starts with
+// balances that no longer match how the period before it ended, which
+// happens when an earlier period is edited after it was rolled over.
+func StaleBalancesAfter(dir string, p Period) (bool, error) {
+	periods, err := ListPeriods(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for i := 1; i < len(periods); i++ {
+		if periods[i-1].Before(p) {
+			continue
+		}
+
+		stale, err := carryBalances(dir, periods[i-1], periods[i], false)
+		if err != nil || stale {
+			return stale, err
+		}
+	}
+
+	return false, nil
+}
+
+// CarryBalancesForward sets the starting balances of every period after from
+// to how the period before it ended, one period at a time so a change made in
+// from reaches the latest period. It returns the periods that changed.
+func CarryBalancesForward(dir string, from Period) ([]Period, error) {
+	periods, err := ListPeriods(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []Period
+	for i := 1; i < len(periods); i++ {
+		if periods[i-1].Before(from) {
+			continue
+		}
+
+		updated, err := carryBalances(dir, periods[i-1], periods[i], true)
+		if err != nil {
+			return changed, err
+		}
+		if updated {
+			changed = append(changed, periods[i])
+		}
+	}
+
+	return changed, nil
+}
+
+// carryBalances compares how each account ended p with the starting balance
+// next begins with, and when fix is set makes them match. It reports whether
+// any differed.
+func carryBalances(dir string, p Period, next Period, fix bool) (bool, error) {
+	pdb, err := openPeriod(dir, p)
+	if err != nil {
+		return false, err
+	}
+
+	defer pdb.Close()
+
+	accounts, err := queryAccounts(pdb)
+	if err != nil {
+		return false, err
+	}
+
+	ndb, err := openPeriod(dir, next)
+	if err != nil {
+		return false, err
+	}
+
+	defer ndb.Close()
+
+	differs := false
+	for _, a := range accounts {
+		closing, err := getAccountAsOf(pdb, a.Id, time.UnixMilli(math.MaxInt64))
+		if err != nil {
+			return false, err
+		}
+
+		var starting sql.NullInt64
+		err = ndb.QueryRow(Q_STARTING_BALANCE, sql.Named("account_id", a.Id)).Scan(&starting)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("Error reading starting balance: %s", err)
+		}
+
+		if utils.Money(starting.Int64) == closing.TotalAvailable && (starting.Valid || closing.TotalAvailable == 0) {
+			continue
+		}
+
+		differs = true
+		if !fix {
+			continue
+		}
+
+		if starting.Valid {
+			_, err = ndb.Exec(UPD_STARTING_BALANCE,
+				sql.Named("account_id", a.Id),
+				sql.Named("amount", closing.TotalAvailable))
+		} else {
+			_, err = ndb.Exec(INS_TRANSACTION,
+				sql.Named("account_id", a.Id),
+				sql.Named("name", StartingBalanceName),
+				sql.Named("amount", closing.TotalAvailable),
+				sql.Named("transaction_date", next.Start().UnixMilli()),
+				sql.Named("category_id", nil),
+				sql.Named("memo", ""),
+				sql.Named("check_number", ""),
+				sql.Named("fitid", ""),
+				sql.Named("payee", ""),
+				sql.Named("display_name", ""),
+				sql.Named("tags", ""),
+				sql.Named("timestamp_added", time.Now().UnixMilli()),
+				sql.Named("created_by", 0),
+				sql.Named("is_starting_balance", true))
+		}
+		if err != nil {
+			return false, fmt.Errorf("Error updating starting balance for %s %d: %s", next.Month, next.Year, err)
+		}
+	}
+
+	return differs, nil
+}
+
+// Rollover writes one starting balance per account; if there were ever more,
+// only the first is carried.
+const Q_STARTING_BALANCE = `
+	select t.amount
+	from transactions t
+	where t.account_id = @account_id
+	  and t.is_starting_balance = 1
+	order by t.id
+	limit 1
+`
+
+const UPD_STARTING_BALANCE = `
+	update transactions
+	set amount = @amount
+	where id = (select min(id) from transactions
+	            where account_id = @account_id
+	              and is_starting_balance = 1)
+`
+
+const Q_TRANSACTION_DETAILS = `
+	select t.id
+	     , t.account_id
+	     , t.name
+	     , t.amount
+	     , t.transaction_date
+	     , t.category_id
+	     , c.name
+	     , t.memo
+	     , t.check_number
+	     , t.fitid
+	     , t.payee
+	     , t.display_name
+	     , t.tags
+	     , t.created_by
+	     , t.edited_by
+	from transactions t
+	left join categories c on c.id = t.category_id
+`
+
+const Q_TRANSACTIONS_BETWEEN = Q_TRANSACTION_DETAILS + `
+	where t.transaction_date >= @from
+	  and t.transaction_date < @to
+	  and t.is_starting_balance = 0
+	order by t.transaction_date
+	        ,t.timestamp_added
+`
+
+const Q_FITIDS = `
+	select t.fitid
+	from transactions t
+	where t.account_id = @account_id
+	  and coalesce(t.fitid, '') <> ''
+`
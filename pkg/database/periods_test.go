@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+func mustInsert(t *testing.T, l *Ledger, items ...Crudder) {
+	t.Helper()
+	for _, item := range items {
+		if err := l.Insert(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// enteredNames names the transactions dated in [from, to), which leaves out
+// starting balances.
+func enteredNames(t *testing.T, dir string, from time.Time, to time.Time) []string {
+	t.Helper()
+	transactions, err := FetchTransactionsBetween(dir, from, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, tr := range transactions {
+		names = append(names, tr.Name)
+	}
+	return names
+}
+
+// TestStartingBalancesAreMarked enters a transaction with the name rollover
+// gives starting balances, and checks it is still treated as any other.
+func TestStartingBalancesAreMarked(t *testing.T) {
+	dir := t.TempDir()
+	september := Period{Year: 2026, Month: time.September}
+	october := september.Next()
+
+	if err := InitDatabase(filepath.Join(dir, september.FileName()), false); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDatabase()
+
+	checking := &Account{Name: "Checking"}
+	mustInsert(t, CurrentLedger(), checking)
+	mustInsert(t, CurrentLedger(), &Transaction{AccountId: checking.Id, Name: "Paycheck", Amount: 100000, Date: september.Start()})
+
+	if err := InitDatabase(filepath.Join(dir, october.FileName()), true); err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, CurrentLedger(), &Transaction{AccountId: checking.Id, Name: StartingBalanceName, Amount: -2500, Date: october.Start()})
+
+	names := enteredNames(t, dir, october.Start(), october.Next().Start())
+	if len(names) != 1 || names[0] != StartingBalanceName {
+		t.Errorf("October's entered transactions are %q, want the one named %q", names, StartingBalanceName)
+	}
+
+	// Editing September moves the carried balance and leaves the entered one.
+	l, err := OpenLedger(filepath.Join(dir, september.FileName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustInsert(t, l, &Transaction{AccountId: checking.Id, Name: "Rent", Amount: -10000, Date: september.Start()})
+	l.Close()
+
+	if _, err = CarryBalancesForward(dir, september); err != nil {
+		t.Fatal(err)
+	}
+
+	transactions, err := FetchPeriodTransactions(dir, october)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var amounts []utils.Money
+	for _, tr := range transactions {
+		amounts = append(amounts, tr.Amount)
+	}
+	if len(amounts) != 2 || amounts[0]+amounts[1] != 90000-2500 || (amounts[0] != -2500 && amounts[1] != -2500) {
+		t.Errorf("October holds %v, want the carried 900.00 and the entered -25.00", amounts)
+	}
+
+	account, err := GetAccount(checking.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.TotalAvailable != 90000-2500 {
+		t.Errorf("Checking has %s, want %s", account.TotalAvailable, utils.Money(90000-2500))
+	}
+}
+
+// TestOldStartingBalancesAreMarked opens a period from before starting
+// balances were marked, which only had their name to go by.
+func TestOldStartingBalancesAreMarked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), Period{Year: 2026, Month: time.October}.FileName())
+	if err := InitDatabase(path, false); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDatabase()
+
+	checking := &Account{Name: "Checking"}
+	mustInsert(t, CurrentLedger(), checking)
+	mustInsert(t, CurrentLedger(),
+		&Transaction{AccountId: checking.Id, Name: StartingBalanceName, Amount: 5000, Date: time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		&Transaction{AccountId: checking.Id, Name: "Coffee", Amount: -450, Date: time.Date(2026, time.October, 2, 0, 0, 0, 0, time.UTC)},
+	)
+	CloseDatabase()
+
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = raw.Exec("alter table transactions drop column is_starting_balance;")
+	raw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = InitDatabase(path, false); err != nil {
+		t.Fatal(err)
+	}
+	names := enteredNames(t, filepath.Dir(path), time.Time{}, time.Now().AddDate(10, 0, 0))
+	if len(names) != 1 || names[0] != "Coffee" {
+		t.Errorf("Entered transactions are %q, want only Coffee", names)
+	}
+}
@@ -2,41 +2,73 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
 )
 
 type Recurring struct {
-	Id     int
-	Name   string
-	Amount int64
-	Day    uint8
+	Id        int
+	AccountId int
+	Name      string
+	Amount    utils.Money
+	Day       uint8
 }
 
-func getRecurringById(id int) (Recurring, error) {
-	stmt, err := dbc.db.Prepare("select id, name, amount from recurrings where id = @id")
+func (l *Ledger) getRecurringById(id int) (Recurring, error) {
+	stmt, err := l.db.Prepare("select id, account_id, name, amount, occurrence_day from recurrings where id = @id")
 	if err != nil {
 		return Recurring{}, fmt.Errorf("Error preparing recurring by id: %s", err)
 	}
 
+	defer stmt.Close()
+
 	row := stmt.QueryRow(sql.Named("id", id))
 
 	recurring := Recurring{}
-	if err := row.Scan(&recurring.Id, &recurring.Name, &recurring.Amount); err != nil {
-		return recurring, fmt.Errorf("Error retrieving recurring: %s", err)
+	if err := row.Scan(&recurring.Id, &recurring.AccountId, &recurring.Name, &recurring.Amount, &recurring.Day); err != nil {
+		return recurring, fmt.Errorf("Error retrieving recurring: %w", err)
 	}
 
 	return recurring, nil
 }
 
-func getNetRecurringBalance() (int64, error) {
-	stmt, err := dbc.db.Prepare("select sum(amount) from recurrings where account_id = @account_id")
+func GetRecurring(id int) (*Recurring, error) {
+	return CurrentLedger().GetRecurring(id)
+}
+
+// GetRecurring returns the recurring transaction with the id, or nil when
+// there isn't one.
+func (l *Ledger) GetRecurring(id int) (*Recurring, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf(DbInitError)
+	}
+
+	recurring, err := l.getRecurringById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &recurring, nil
+}
+
+func GetRecurringNetBalanceFor(accountId int) (utils.Money, error) {
+	return CurrentLedger().GetRecurringNetBalanceFor(accountId)
+}
+
+// GetRecurringNetBalanceFor totals one account's recurring transactions.
+func (l *Ledger) GetRecurringNetBalanceFor(accountId int) (utils.Money, error) {
+	stmt, err := l.db.Prepare("select coalesce(sum(amount), 0) from recurrings where account_id = @account_id")
 	if err != nil {
 		return 0, fmt.Errorf("Error preparing statement for recurring net balance: %s", err)
 	}
 
-	row := stmt.QueryRow(sql.Named("account_id", dbc.currentAccountId))
-	var balance int64
+	row := stmt.QueryRow(sql.Named("account_id", accountId))
+	var balance utils.Money
 	err = row.Scan(&balance)
 	if err != nil {
 		return 0, fmt.Errorf("Error getting recurring net balance: %s", err)
@@ -45,13 +77,21 @@ func getNetRecurringBalance() (int64, error) {
 	return balance, nil
 }
 
-func fetchAllRecurrings() ([]Recurring, error) {
-	stmt, err := dbc.db.Prepare(Q_RECURRING_TRANSACTIONS)
+func FetchRecurringsFor(accountId int) ([]Recurring, error) {
+	return CurrentLedger().FetchRecurringsFor(accountId)
+}
+
+// FetchRecurringsFor returns one account's recurring transactions in the
+// ledger.
+func (l *Ledger) FetchRecurringsFor(accountId int) ([]Recurring, error) {
+	stmt, err := l.db.Prepare(Q_RECURRING_TRANSACTIONS)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(sql.Named("account_id", dbc.currentAccountId))
+	defer stmt.Close()
+
+	rows, err := stmt.Query(sql.Named("account_id", accountId))
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +101,7 @@ func fetchAllRecurrings() ([]Recurring, error) {
 	var results []Recurring
 	var id int
 	var name string
-	var amount int64
+	var amount utils.Money
 	var day uint8
 
 	for rows.Next() {
@@ -71,25 +111,30 @@ func fetchAllRecurrings() ([]Recurring, error) {
 		}
 
 		results = append(results, Recurring{
-			Id:     id,
-			Name:   name,
-			Amount: amount,
-			Day:    day,
+			Id:        id,
+			AccountId: accountId,
+			Name:      name,
+			Amount:    amount,
+			Day:       day,
 		})
 	}
 
 	return results, nil
 }
 
-func (r *Recurring) insert() error {
-	stmt, err := dbc.db.Prepare(INS_RECURRING_TRANSACTION)
+func (r *Recurring) insert(l *Ledger) error {
+	if r.AccountId == 0 {
+		return ErrNoAccount
+	}
+
+	stmt, err := l.db.Prepare(INS_RECURRING_TRANSACTION)
 	if err != nil {
 		return fmt.Errorf("Error preparing recurring for insert: %s", err)
 	}
 
 	// values (@account_id, @name, @amount, @occurrence_day, @timestamp_added)
-	_, err = stmt.Exec(
-		sql.Named("account_id", dbc.currentAccountId),
+	result, err := stmt.Exec(
+		sql.Named("account_id", r.AccountId),
 		sql.Named("name", r.Name),
 		sql.Named("amount", r.Amount),
 		sql.Named("occurrence_day", r.Day),
@@ -99,15 +144,34 @@ func (r *Recurring) insert() error {
 		return fmt.Errorf("Error inserting recurring: %s", err)
 	}
 
+	if id, err := result.LastInsertId(); err == nil {
+		r.Id = int(id)
+	}
+
 	return nil
 }
 
-func (r *Recurring) update() error {
-	return fmt.Errorf("Not yet implemented")
+func (r *Recurring) update(l *Ledger) error {
+	if r.AccountId == 0 {
+		return ErrNoAccount
+	}
+
+	_, err := l.db.Exec(UPD_RECURRING_TRANSACTION,
+		sql.Named("id", r.Id),
+		sql.Named("account_id", r.AccountId),
+		sql.Named("name", r.Name),
+		sql.Named("amount", r.Amount),
+		sql.Named("day", r.Day),
+	)
+	if err != nil {
+		return fmt.Errorf("Error updating recurring: %s", err)
+	}
+
+	return nil
 }
 
-func (r *Recurring) delete() error {
-	stmt, err := dbc.db.Prepare(DEL_RECURRING_TRANSACTION)
+func (r *Recurring) delete(l *Ledger) error {
+	stmt, err := l.db.Prepare(DEL_RECURRING_TRANSACTION)
 	if err != nil {
 		return err
 	}
@@ -152,11 +216,12 @@ const INS_RECURRING_TRANSACTION = `
 `
 
 const UPD_RECURRING_TRANSACTION = `
-	update recurrings r 
-	set r.name = @name,
-		r.amount = @amount,
-		r.occurrence_day = @day
-    where r.id = @id;
+	update recurrings
+	set account_id = @account_id,
+		name = @name,
+		amount = @amount,
+		occurrence_day = @day
+    where id = @id;
 `
 
 const DEL_RECURRING_TRANSACTION = `
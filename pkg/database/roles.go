@@ -0,0 +1,296 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Role is what a user may do with one account. Owners can also share the
+// account and rename or delete it; editors can change its transactions and
+// recurring transactions; viewers can only read. Roles live in the store so
+// they carry across periods.
+type Role string
+
+const (
+	RoleNone   Role = ""
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+var Roles = []Role{RoleOwner, RoleEditor, RoleViewer}
+
+var ErrLastOwner = errors.New("Every account needs an owner. Make someone else the owner first.")
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// AtLeast reports whether r allows everything min does.
+func (r Role) AtLeast(min Role) bool {
+	return r.rank() > 0 && r.rank() >= min.rank()
+}
+
+func ValidRole(role string) bool {
+	return Role(role).rank() > 0
+}
+
+// AccountSet limits a query to some accounts. A nil set allows every
+// account, which is what the command line uses; the server always passes the
+// accounts the logged-in user may see.
+type AccountSet map[int]bool
+
+func (s AccountSet) Allows(accountId int) bool {
+	return s == nil || s[accountId]
+}
+
+// Only narrows s to one account. An accountId of 0 leaves s as it is.
+func (s AccountSet) Only(accountId int) AccountSet {
+	if accountId == 0 {
+		return s
+	}
+	if !s.Allows(accountId) {
+		return AccountSet{}
+	}
+	return AccountSet{accountId: true}
+}
+
+// AccountRoles is one user's role on each account they can see.
+type AccountRoles map[int]Role
+
+func (roles AccountRoles) Can(accountId int, min Role) bool {
+	return roles[accountId].AtLeast(min)
+}
+
+// With is the set of accounts the user has at least min on.
+func (roles AccountRoles) With(min Role) AccountSet {
+	set := AccountSet{}
+	for id, role := range roles {
+		if role.AtLeast(min) {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+// AccountIds lists the accounts in id order.
+func (roles AccountRoles) AccountIds() []int {
+	var ids []int
+	for id := range roles {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+type AccountRole struct {
+	AccountId int
+	UserId    int
+	Username  string
+	Role      Role
+}
+
+func FetchUserRoles(userId int) (AccountRoles, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query("select account_id, role from account_roles where user_id = @user_id", sql.Named("user_id", userId))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching account roles: %s", err)
+	}
+
+	defer rows.Close()
+
+	roles := AccountRoles{}
+	for rows.Next() {
+		var accountId int
+		var role string
+		if err = rows.Scan(&accountId, &role); err != nil {
+			return nil, fmt.Errorf("Error reading account roles: %s", err)
+		}
+		roles[accountId] = Role(role)
+	}
+
+	return roles, nil
+}
+
+// FetchAccountRoles lists who has access to an account, owners first.
+func FetchAccountRoles(accountId int) ([]AccountRole, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(Q_ACCOUNT_ROLES, sql.Named("account_id", accountId))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching account roles: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []AccountRole
+	for rows.Next() {
+		var r AccountRole
+		var role string
+		if err = rows.Scan(&r.AccountId, &r.UserId, &r.Username, &role); err != nil {
+			return nil, fmt.Errorf("Error reading account roles: %s", err)
+		}
+		r.Role = Role(role)
+		results = append(results, r)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Role.rank() > results[j].Role.rank()
+	})
+
+	return results, nil
+}
+
+// SetAccountRole gives the user role on the account, replacing any role they
+// had. The last owner can't be demoted.
+func SetAccountRole(accountId int, userId int, role Role) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if !ValidRole(string(role)) {
+		return fmt.Errorf("Unknown role %s.", role)
+	}
+
+	if role != RoleOwner {
+		if err := checkOtherOwner(accountId, userId); err != nil {
+			return err
+		}
+	}
+
+	_, err := store.Exec(UPS_ACCOUNT_ROLE,
+		sql.Named("account_id", accountId),
+		sql.Named("user_id", userId),
+		sql.Named("role", string(role)))
+	if err != nil {
+		return fmt.Errorf("Error saving account role: %s", err)
+	}
+
+	return nil
+}
+
+// RemoveAccountRole takes the user's access to the account away.
+func RemoveAccountRole(accountId int, userId int) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if err := checkOtherOwner(accountId, userId); err != nil {
+		return err
+	}
+
+	_, err := store.Exec("delete from account_roles where account_id = @account_id and user_id = @user_id",
+		sql.Named("account_id", accountId), sql.Named("user_id", userId))
+	if err != nil {
+		return fmt.Errorf("Error removing account role: %s", err)
+	}
+
+	return nil
+}
+
+// checkOtherOwner refuses to leave an account without an owner when userId
+// stops being one.
+func checkOtherOwner(accountId int, userId int) error {
+	var others int
+	err := store.QueryRow(`select count(*) from account_roles
+		where account_id = @account_id and role = @owner and user_id <> @user_id`,
+		sql.Named("account_id", accountId), sql.Named("owner", string(RoleOwner)), sql.Named("user_id", userId)).Scan(&others)
+	if err != nil {
+		return fmt.Errorf("Error checking account owners: %s", err)
+	}
+
+	var isOwner int
+	err = store.QueryRow(`select count(*) from account_roles
+		where account_id = @account_id and role = @owner and user_id = @user_id`,
+		sql.Named("account_id", accountId), sql.Named("owner", string(RoleOwner)), sql.Named("user_id", userId)).Scan(&isOwner)
+	if err != nil {
+		return fmt.Errorf("Error checking account owners: %s", err)
+	}
+
+	if isOwner > 0 && others == 0 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// DeleteAccountRoles forgets who had access to a deleted account.
+func DeleteAccountRoles(accountId int) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if _, err := store.Exec("delete from account_roles where account_id = @account_id", sql.Named("account_id", accountId)); err != nil {
+		return fmt.Errorf("Error removing account roles: %s", err)
+	}
+
+	return nil
+}
+
+// ClaimUnownedAccounts makes every admin an owner of each account nobody
+// owns: accounts from before there were users, ones added from the command
+// line, and ones whose owners were deleted.
+func ClaimUnownedAccounts(accountIds []int) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	for _, id := range accountIds {
+		_, err := store.Exec(CLAIM_ACCOUNT, sql.Named("account_id", id), sql.Named("owner", string(RoleOwner)))
+		if err != nil {
+			return fmt.Errorf("Error claiming account %d: %s", id, err)
+		}
+	}
+
+	return nil
+}
+
+const CT_ACCOUNT_ROLES = `
+	create table if not exists account_roles (
+		account_id integer,
+		user_id integer,
+		role varchar(20),
+		primary key (account_id, user_id)
+	);
+`
+
+const Q_ACCOUNT_ROLES = `
+	select r.account_id
+	     , r.user_id
+	     , u.username
+	     , r.role
+	from account_roles r
+	join users u on u.id = r.user_id
+	where r.account_id = @account_id
+	order by lower(u.username)
+`
+
+const UPS_ACCOUNT_ROLE = `
+	insert into account_roles (account_id, user_id, role)
+	values (@account_id, @user_id, @role)
+	on conflict (account_id, user_id) do update set role = excluded.role
+`
+
+const CLAIM_ACCOUNT = `
+	insert into account_roles (account_id, user_id, role)
+	select @account_id, u.id, @owner
+	from users u
+	where u.is_admin
+	  and not exists (select 1 from account_roles r where r.account_id = @account_id and r.role = @owner)
+	on conflict (account_id, user_id) do update set role = excluded.role
+`
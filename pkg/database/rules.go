@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// Rule cleans up transactions as they come in. A transaction matches when
+// its name contains Pattern (or matches it as a regular expression when
+// IsRegex is set), its amount, ignoring sign, falls between MinAmount and
+// MaxAmount, and it belongs to AccountId. A zero bound or account matches
+// anything. The non-empty actions are copied onto every match, in Position
+// order, so a later rule can override an earlier one.
+type Rule struct {
+	Id          int
+	Name        string
+	Position    int
+	Enabled     bool
+	Pattern     string
+	IsRegex     bool
+	MinAmount   utils.Money
+	MaxAmount   utils.Money
+	AccountId   int
+	Category    string
+	Tags        string
+	Payee       string
+	DisplayName string
+}
+
+func (r *Rule) insert(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if r.Position == 0 {
+		if err := store.QueryRow("select coalesce(max(position), 0) + 1 from rules").Scan(&r.Position); err != nil {
+			return fmt.Errorf("Error numbering rule: %s", err)
+		}
+	}
+
+	result, err := store.Exec(INS_RULE, r.namedArgs()...)
+	if err != nil {
+		return fmt.Errorf("Error inserting rule: %s", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		r.Id = int(id)
+	}
+
+	return nil
+}
+
+func (r *Rule) update(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	args := append(r.namedArgs(), sql.Named("id", r.Id))
+	_, err := store.Exec(UPD_RULE, args...)
+	if err != nil {
+		return fmt.Errorf("Error updating rule: %s", err)
+	}
+
+	return nil
+}
+
+func (r *Rule) delete(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	_, err := store.Exec("delete from rules where id = @id", sql.Named("id", r.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting rule: %s", err)
+	}
+
+	return nil
+}
+
+func (r *Rule) namedArgs() []any {
+	return []any{
+		sql.Named("name", r.Name),
+		sql.Named("position", r.Position),
+		sql.Named("enabled", r.Enabled),
+		sql.Named("pattern", r.Pattern),
+		sql.Named("is_regex", r.IsRegex),
+		sql.Named("min_amount", r.MinAmount),
+		sql.Named("max_amount", r.MaxAmount),
+		sql.Named("account_id", r.AccountId),
+		sql.Named("category", r.Category),
+		sql.Named("tags", r.Tags),
+		sql.Named("payee", r.Payee),
+		sql.Named("display_name", r.DisplayName),
+	}
+}
+
+func GetRule(id int) (*Rule, error) {
+	rules, err := queryRules(Q_RULES+" where id = @id", sql.Named("id", id))
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	return &rules[0], nil
+}
+
+func FetchAllRules() ([]Rule, error) {
+	return queryRules(Q_RULES + " order by position, id")
+}
+
+func queryRules(query string, args ...any) ([]Rule, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching rules: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []Rule
+	for rows.Next() {
+		var r Rule
+		err = rows.Scan(&r.Id, &r.Name, &r.Position, &r.Enabled, &r.Pattern, &r.IsRegex,
+			&r.MinAmount, &r.MaxAmount, &r.AccountId, &r.Category, &r.Tags, &r.Payee, &r.DisplayName)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading rules: %s", err)
+		}
+
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+const CT_RULES = `
+	create table if not exists rules (
+		id integer primary key,
+		name varchar(100),
+		position integer,
+		enabled integer,
+		pattern varchar(255),
+		is_regex integer,
+		min_amount integer,
+		max_amount integer,
+		account_id integer,
+		category varchar(100),
+		tags varchar(255),
+		payee varchar(100),
+		display_name varchar(100)
+	);
+`
+
+const Q_RULES = `
+	select id
+	     , name
+	     , position
+	     , enabled
+	     , pattern
+	     , is_regex
+	     , min_amount
+	     , max_amount
+	     , account_id
+	     , category
+	     , tags
+	     , payee
+	     , display_name
+	from rules
+`
+
+const INS_RULE = `
+	insert into rules (
+		  name
+		, position
+		, enabled
+		, pattern
+		, is_regex
+		, min_amount
+		, max_amount
+		, account_id
+		, category
+		, tags
+		, payee
+		, display_name)
+	values (@name, @position, @enabled, @pattern, @is_regex, @min_amount, @max_amount,
+	        @account_id, @category, @tags, @payee, @display_name)
+`
+
+const UPD_RULE = `
+	update rules
+	set name = @name,
+	    position = @position,
+	    enabled = @enabled,
+	    pattern = @pattern,
+	    is_regex = @is_regex,
+	    min_amount = @min_amount,
+	    max_amount = @max_amount,
+	    account_id = @account_id,
+	    category = @category,
+	    tags = @tags,
+	    payee = @payee,
+	    display_name = @display_name
+	where id = @id;
+`
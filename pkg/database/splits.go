@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// Split divides a transaction's amount between categories. The splits of a
+// transaction add up to its amount.
+type Split struct {
+	Id            int
+	TransactionId int
+	CategoryId    int
+	Category      string
+	Memo          string
+	Amount        utils.Money
+}
+
+func (s *Split) insert(l *Ledger) error {
+	var err error
+	if s.CategoryId == 0 && len(strings.TrimSpace(s.Category)) > 0 {
+		if s.CategoryId, err = ensureCategoryIn(l.db, s.Category); err != nil {
+			return err
+		}
+	}
+
+	var categoryId any = nil
+	if s.CategoryId > 0 {
+		categoryId = s.CategoryId
+	}
+
+	result, err := l.db.Exec(INS_TRANSACTION_SPLIT,
+		sql.Named("transaction_id", s.TransactionId),
+		sql.Named("category_id", categoryId),
+		sql.Named("memo", s.Memo),
+		sql.Named("amount", s.Amount),
+	)
+	if err != nil {
+		return fmt.Errorf("Error inserting split: %s", err)
+	}
+
+	if id, err := result.LastInsertId(); err == nil {
+		s.Id = int(id)
+	}
+
+	return nil
+}
+
+func deleteSplits(pdb *sql.DB, transactionId int) error {
+	_, err := pdb.Exec("delete from transaction_splits where transaction_id = @id", sql.Named("id", transactionId))
+	if err != nil {
+		return fmt.Errorf("Error deleting splits: %s", err)
+	}
+
+	return nil
+}
+
+func querySplits(pdb *sql.DB) (map[int][]Split, error) {
+	rows, err := pdb.Query(Q_TRANSACTION_SPLITS)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching splits: %s", err)
+	}
+
+	defer rows.Close()
+
+	results := map[int][]Split{}
+	for rows.Next() {
+		var s Split
+		var categoryId sql.NullInt64
+		var category, memo sql.NullString
+		err = rows.Scan(&s.Id, &s.TransactionId, &categoryId, &category, &memo, &s.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading splits: %s", err)
+		}
+
+		s.CategoryId = int(categoryId.Int64)
+		s.Category = category.String
+		s.Memo = memo.String
+		results[s.TransactionId] = append(results[s.TransactionId], s)
+	}
+
+	return results, nil
+}
+
+const INS_TRANSACTION_SPLIT = `
+	insert into transaction_splits (
+		  transaction_id
+		, category_id
+		, memo
+		, amount)
+	values (@transaction_id, @category_id, @memo, @amount)
+`
+
+const Q_TRANSACTION_SPLITS = `
+	select s.id
+	     , s.transaction_id
+	     , s.category_id
+	     , c.name
+	     , s.memo
+	     , s.amount
+	from transaction_splits s
+	left join categories c on c.id = s.category_id
+	order by s.transaction_id
+	        ,s.id
+`
+
+const CT_TRANSACTION_SPLITS = `
+	create table if not exists transaction_splits (
+		id integer primary key,
+		transaction_id integer,
+		category_id integer,
+		memo varchar(1000),
+		amount integer,
+		foreign key(transaction_id) references transactions(id),
+		foreign key(category_id) references categories(id)
+	);
+`
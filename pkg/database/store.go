@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// The store holds data that is not tied to a single period, such as saved
+// import mappings, rules and logins. It lives next to the period files but
+// is never rolled over.
+const StoreFileName = "sacmoney.db"
+
+const StoreInitError = "Store not initialized. Call InitStore() before using settings that outlive a period."
+
+var (
+	store *sql.DB
+)
+
+func InitStore(storePath string) error {
+	sdb, err := sql.Open("sqlite3", storePath+"?cache=shared")
+	if err != nil {
+		return fmt.Errorf("Error opening store: %s", err)
+	}
+	sdb.SetMaxOpenConns(1)
+
+	for _, statement := range storeSchema {
+		if err = createTable(sdb, statement); err != nil {
+			sdb.Close()
+			return fmt.Errorf("Error creating store schema: %s", err)
+		}
+	}
+
+	store = sdb
+	return nil
+}
+
+func CloseStore() error {
+	if store != nil {
+		if err := store.Close(); err != nil {
+			return err
+		}
+		store = nil
+	}
+	return nil
+}
+
+func checkStore() error {
+	if store == nil {
+		return fmt.Errorf(StoreInitError)
+	}
+	return nil
+}
+
+var storeSchema = []string{
+	CT_CSV_MAPPINGS,
+	CT_RULES,
+	CT_USERS,
+	CT_SESSIONS,
+	CT_ACCOUNT_ROLES,
+	CT_HISTORY,
+	CT_WEBHOOKS,
+	CT_WEBHOOK_DELIVERIES,
+}
@@ -6,63 +6,224 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
 )
 
 type Transaction struct {
-	Id     int
-	Name   string
-	Amount int64
-	Date   time.Time
+	Id          int
+	AccountId   int
+	Name        string
+	Amount      utils.Money
+	Date        time.Time
+	CategoryId  int
+	Category    string
+	Memo        string
+	CheckNumber string
+	FitId       string
+	Payee       string
+	DisplayName string
+	Tags        string
+	Splits      []Split
+	Period      Period
+
+	// StartingBalance marks the transaction rollover carries an account's
+	// balance into a new period with. It is only set on insert.
+	StartingBalance bool
+
+	// CreatedBy and EditedBy are the ids of the users who added and last
+	// changed the transaction, or 0 when it was done from the command line.
+	CreatedBy int
+	EditedBy  int
+}
+
+// Display is the name shown in lists, which a rule may have cleaned up.
+func (t *Transaction) Display() string {
+	if len(t.DisplayName) > 0 {
+		return t.DisplayName
+	}
+	return t.Name
 }
 
-func (t *Transaction) insert() error {
-	stmt, err := dbc.db.Prepare(INS_TRANSACTION)
+// PayeeName is who the money went to or came from, for reports and exports.
+func (t *Transaction) PayeeName() string {
+	if len(t.Payee) > 0 {
+		return t.Payee
+	}
+	return t.Display()
+}
+
+func (t *Transaction) insert(l *Ledger) error {
+	if t.AccountId == 0 {
+		return ErrNoAccount
+	}
+
+	stmt, err := l.db.Prepare(INS_TRANSACTION)
 	if err != nil {
 		return fmt.Errorf("Error preparing transaction for inserting: %s", err)
 	}
 
-	// values (@account_id, @name, @amount, @transaction_date, @timestamp_added)
-	_, err = stmt.Exec(
-		sql.Named("account_id", dbc.currentAccountId),
+	if t.CategoryId == 0 && len(strings.TrimSpace(t.Category)) > 0 {
+		if t.CategoryId, err = ensureCategoryIn(l.db, t.Category); err != nil {
+			return err
+		}
+	}
+
+	var categoryId any = nil
+	if t.CategoryId > 0 {
+		categoryId = t.CategoryId
+	}
+
+	// values (@account_id, @name, @amount, @transaction_date, @category_id, @memo, @check_number, @fitid,
+	//         @payee, @display_name, @tags, @timestamp_added, @created_by, @is_starting_balance)
+	result, err := stmt.Exec(
+		sql.Named("account_id", t.AccountId),
 		sql.Named("name", t.Name),
 		sql.Named("amount", t.Amount),
 		sql.Named("transaction_date", t.Date.UnixMilli()),
+		sql.Named("category_id", categoryId),
+		sql.Named("memo", t.Memo),
+		sql.Named("check_number", t.CheckNumber),
+		sql.Named("fitid", t.FitId),
+		sql.Named("payee", t.Payee),
+		sql.Named("display_name", t.DisplayName),
+		sql.Named("tags", t.Tags),
 		sql.Named("timestamp_added", time.Now().UnixMilli()),
+		sql.Named("created_by", t.CreatedBy),
+		sql.Named("is_starting_balance", t.StartingBalance),
 	)
 
 	if err != nil {
 		return fmt.Errorf("Error inserting transaction: %s", err)
 	}
 
+	if id, err := result.LastInsertId(); err == nil {
+		t.Id = int(id)
+	}
+
+	for i := range t.Splits {
+		t.Splits[i].TransactionId = t.Id
+		if err = t.Splits[i].insert(l); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (t *Transaction) update() error {
-	stmt, err := dbc.db.Prepare(UPD_TRANSACTION)
-	if err != nil {
-		return fmt.Errorf("Error preparing update for transaction: %s", err)
+// update saves every editable field and replaces the splits, so callers
+// that only change a few fields should start from GetTransaction.
+func (t *Transaction) update(l *Ledger) error {
+	var err error
+	if t.CategoryId == 0 && len(strings.TrimSpace(t.Category)) > 0 {
+		if t.CategoryId, err = ensureCategoryIn(l.db, t.Category); err != nil {
+			return err
+		}
 	}
 
-	_, err = stmt.Exec(
+	var categoryId any = nil
+	if t.CategoryId > 0 {
+		categoryId = t.CategoryId
+	}
+
+	_, err = l.db.Exec(UPD_TRANSACTION,
 		sql.Named("id", t.Id),
+		sql.Named("account_id", t.AccountId),
 		sql.Named("name", t.Name),
 		sql.Named("amount", t.Amount),
+		sql.Named("transaction_date", t.Date.UnixMilli()),
+		sql.Named("category_id", categoryId),
+		sql.Named("memo", t.Memo),
+		sql.Named("check_number", t.CheckNumber),
+		sql.Named("payee", t.Payee),
+		sql.Named("display_name", t.DisplayName),
+		sql.Named("tags", t.Tags),
+		sql.Named("edited_by", t.EditedBy),
 	)
 
 	if err != nil {
 		return fmt.Errorf("Error updating transaction: %s", err)
 	}
 
+	if err = deleteSplits(l.db, t.Id); err != nil {
+		return err
+	}
+
+	for i := range t.Splits {
+		t.Splits[i].TransactionId = t.Id
+		if err = t.Splits[i].insert(l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetTransaction(id int) (*Transaction, error) {
+	return CurrentLedger().GetTransaction(id)
+}
+
+// GetTransaction reads a transaction from the ledger, or returns nil when
+// there's no transaction with that id.
+func (l *Ledger) GetTransaction(id int) (*Transaction, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf(DbInitError)
+	}
+
+	transactions, err := queryTransactions(l.db, Q_TRANSACTION_DETAILS+" where t.id = @id", sql.Named("id", id))
+	if err != nil || len(transactions) == 0 {
+		return nil, err
+	}
+
+	return &transactions[0], nil
+}
+
+// MergeTransaction folds incoming into target, a transaction read through
+// FetchTransactionsBetween that may live in any period. Whatever target
+// already has is kept and the bank's memo, check number and FITID fill the
+// gaps, so a hand-entered transaction picks up the identifiers of its
+// imported twin. The name is only replaced when rename is set.
+func MergeTransaction(dir string, target Transaction, incoming Transaction, rename bool) error {
+	pdb, err := openPeriod(dir, target.Period)
+	if err != nil {
+		return err
+	}
+
+	defer pdb.Close()
+
+	_, err = pdb.Exec(MERGE_TRANSACTION,
+		sql.Named("id", target.Id),
+		sql.Named("rename", rename),
+		sql.Named("name", incoming.Name),
+		sql.Named("memo", incoming.Memo),
+		sql.Named("check_number", incoming.CheckNumber),
+		sql.Named("fitid", incoming.FitId),
+		sql.Named("edited_by", incoming.CreatedBy),
+	)
+
+	if err != nil {
+		return fmt.Errorf("Error merging transaction: %s", err)
+	}
+
 	return nil
 }
 
-func fetchAllTransactions() ([]Transaction, error) {
-	stmt, err := dbc.db.Prepare(Q_TRANSACTIONS)
+func FetchTransactionsFor(accountId int) ([]Transaction, error) {
+	return CurrentLedger().FetchTransactionsFor(accountId)
+}
+
+// FetchTransactionsFor returns one account's transactions in the ledger,
+// newest first.
+func (l *Ledger) FetchTransactionsFor(accountId int) ([]Transaction, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf(DbInitError)
+	}
+
+	stmt, err := l.db.Prepare(Q_TRANSACTIONS)
 	if err != nil {
 		return nil, fmt.Errorf("Error preparing for fetching transactions: %s", err)
 	}
 
-	rows, err := stmt.Query(sql.Named("account_id", dbc.currentAccountId))
+	rows, err := stmt.Query(sql.Named("account_id", accountId))
 	if err != nil {
 		return nil, fmt.Errorf("Error fetching transactions: %s", err)
 	}
@@ -72,13 +233,15 @@ func fetchAllTransactions() ([]Transaction, error) {
 	var results []Transaction
 	var id int
 	var name string
-	var amount int64
+	var amount utils.Money
 	var date int64
+	var category, payee, displayName, tags sql.NullString
+	var createdBy, editedBy sql.NullInt64
 	var utcDate time.Time
 	utc, _ := time.LoadLocation("UTC")
 
 	for rows.Next() {
-		err = rows.Scan(&id, &name, &amount, &date)
+		err = rows.Scan(&id, &name, &amount, &date, &category, &payee, &displayName, &tags, &createdBy, &editedBy)
 		if err != nil {
 			return nil, fmt.Errorf("Error reading transactions: %s", err)
 		}
@@ -86,30 +249,90 @@ func fetchAllTransactions() ([]Transaction, error) {
 		utcDate = time.UnixMilli(date).In(utc)
 
 		results = append(results, Transaction{
-			Id:     id,
-			Name:   name,
-			Amount: amount,
-			Date:   utcDate,
+			Id:          id,
+			AccountId:   accountId,
+			Name:        name,
+			Amount:      amount,
+			Date:        utcDate,
+			Category:    category.String,
+			Payee:       payee.String,
+			DisplayName: displayName.String,
+			Tags:        tags.String,
+			CreatedBy:   int(createdBy.Int64),
+			EditedBy:    int(editedBy.Int64),
 		})
 	}
 
 	return results, nil
 }
 
-func (t *Transaction) delete() error {
-	stmt, err := dbc.db.Prepare(DEL_TRANSACTION)
+// ApplyRuleFields writes the fields a rule can set back to each
+// transaction's period, which is how rules are re-run over transactions that
+// were already saved.
+func ApplyRuleFields(dir string, transactions []Transaction) error {
+	byPeriod := map[Period][]Transaction{}
+	for _, t := range transactions {
+		byPeriod[t.Period] = append(byPeriod[t.Period], t)
+	}
+
+	for p, group := range byPeriod {
+		if err := applyRuleFieldsIn(dir, p, group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyRuleFieldsIn(dir string, p Period, transactions []Transaction) error {
+	pdb, err := openPeriod(dir, p)
+	if err != nil {
+		return err
+	}
+
+	defer pdb.Close()
+
+	for _, t := range transactions {
+		var categoryId any = nil
+		if len(strings.TrimSpace(t.Category)) > 0 {
+			if categoryId, err = ensureCategoryIn(pdb, t.Category); err != nil {
+				return err
+			}
+		}
+
+		_, err = pdb.Exec(UPD_RULE_FIELDS,
+			sql.Named("id", t.Id),
+			sql.Named("category_id", categoryId),
+			sql.Named("payee", t.Payee),
+			sql.Named("display_name", t.DisplayName),
+			sql.Named("tags", t.Tags),
+		)
+
+		if err != nil {
+			return fmt.Errorf("Error updating transaction: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (t *Transaction) delete(l *Ledger) error {
+	stmt, err := l.db.Prepare(DEL_TRANSACTION)
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(sql.Named("id", t.Id))
-	return err
+	if _, err = stmt.Exec(sql.Named("id", t.Id)); err != nil {
+		return err
+	}
+
+	return deleteSplits(l.db, t.Id)
 }
 
 func (t *Transaction) ToCliString(width int) string {
 	id := strconv.Itoa(int(t.Id))
 	name := t.Name
-	amount := fmt.Sprintf("$%.2f", float64(t.Amount)*float64(0.01))
+	amount := t.Amount.Dollars()
 	date := t.Date.Format("Mon 02 Jan")
 
 	padding := 9 // account for spacers between data elements
@@ -138,14 +361,52 @@ const INS_TRANSACTION = `
 		, name
 	    , amount
 	    , transaction_date
-	    , timestamp_added)
-	values (@account_id, @name, @amount, @transaction_date, @timestamp_added)
+	    , category_id
+	    , memo
+	    , check_number
+	    , fitid
+	    , payee
+	    , display_name
+	    , tags
+	    , timestamp_added
+	    , created_by
+	    , is_starting_balance)
+	values (@account_id, @name, @amount, @transaction_date, @category_id, @memo, @check_number, @fitid,
+	        @payee, @display_name, @tags, @timestamp_added, @created_by, @is_starting_balance)
+`
+
+const MERGE_TRANSACTION = `
+	update transactions
+	set name = case when @rename then @name else name end,
+	    memo = coalesce(nullif(memo, ''), @memo),
+	    check_number = coalesce(nullif(check_number, ''), @check_number),
+	    fitid = coalesce(nullif(fitid, ''), @fitid),
+	    edited_by = @edited_by
+	where id = @id;
 `
 
 const UPD_TRANSACTION = `
-	update transactions 
-	set name = @name,
-	    amount = @amount
+	update transactions
+	set account_id = @account_id,
+	    name = @name,
+	    amount = @amount,
+	    transaction_date = @transaction_date,
+	    category_id = @category_id,
+	    memo = @memo,
+	    check_number = @check_number,
+	    payee = @payee,
+	    display_name = @display_name,
+	    tags = @tags,
+	    edited_by = @edited_by
+	where id = @id;
+`
+
+const UPD_RULE_FIELDS = `
+	update transactions
+	set category_id = @category_id,
+	    payee = @payee,
+	    display_name = @display_name,
+	    tags = @tags
 	where id = @id;
 `
 
@@ -154,8 +415,15 @@ const Q_TRANSACTIONS = `
 	     , t.name
 		 , t.amount
 	     , t.transaction_date
+	     , c.name
+	     , t.payee
+	     , t.display_name
+	     , t.tags
+	     , t.created_by
+	     , t.edited_by
 	from transactions t
-	where account_id = @account_id
+	left join categories c on c.id = t.category_id
+	where t.account_id = @account_id
 	order by t.transaction_date desc
 			,t.timestamp_added desc
 `
@@ -172,7 +440,16 @@ const CT_TRANSACTIONS = `
 	    name varchar(1000),
 	    account_id integer,
 	    category_id integer,
+	    memo varchar(1000),
+	    check_number varchar(20),
+	    fitid varchar(255),
+	    payee varchar(100),
+	    display_name varchar(100),
+	    tags varchar(255),
 		timestamp_added integer,
+	    created_by integer,
+	    edited_by integer,
+	    is_starting_balance integer not null default 0,
 	    foreign key(account_id) references accounts(id),
 	    foreign key(category_id) references categories(id)
 	);
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSavingNeedsAnAccount(t *testing.T) {
+	dir := t.TempDir()
+	period := Period{Year: 2026, Month: time.October}
+	if err := InitDatabase(filepath.Join(dir, period.FileName()), false); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDatabase()
+
+	checking := &Account{Name: "Checking"}
+	savings := &Account{Name: "Savings"}
+	for _, a := range []*Account{checking, savings} {
+		if err := Insert(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []Crudder{
+		&Transaction{Name: "Coffee", Amount: -450, Date: period.Start()},
+		&Recurring{Name: "Rent", Amount: -100000, Day: 1},
+	} {
+		if err := Insert(c); !errors.Is(err, ErrNoAccount) {
+			t.Errorf("Inserting %T without an account: %v", c, err)
+		}
+	}
+
+	rent := &Recurring{AccountId: checking.Id, Name: "Rent", Amount: -100000, Day: 1}
+	interest := &Recurring{AccountId: savings.Id, Name: "Interest", Amount: 120, Day: 28}
+	for _, r := range []*Recurring{rent, interest} {
+		if err := Insert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rent.AccountId = 0
+	if err := Update(rent); !errors.Is(err, ErrNoAccount) {
+		t.Errorf("Updating a recurring transaction without an account: %v", err)
+	}
+	rent.AccountId = checking.Id
+
+	// A rollover carries every account's recurring transactions forward.
+	if err := InitDatabase(filepath.Join(dir, period.Next().FileName()), true); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []*Recurring{rent, interest} {
+		recurrings, err := FetchRecurringsFor(want.AccountId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recurrings) != 1 || recurrings[0].Name != want.Name {
+			t.Errorf("Account %d has %v after rollover, want %s", want.AccountId, recurrings, want.Name)
+		}
+	}
+}
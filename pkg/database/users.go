@@ -0,0 +1,319 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is someone who can log in to the server. Passwords are only kept as
+// bcrypt hashes.
+type User struct {
+	Id           int
+	Username     string
+	PasswordHash string
+	IsAdmin      bool
+	Created      time.Time
+}
+
+// Session is a login. Only the SHA-256 of the cookie's token is stored, so
+// reading the store doesn't give anyone a way in.
+type Session struct {
+	TokenHash string
+	UserId    int
+	Created   time.Time
+	Expires   time.Time
+}
+
+const MinPasswordLength = 8
+
+var ErrUsernameTaken = errors.New("That username is already taken.")
+
+func (u *User) SetPassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("Passwords need at least %d characters.", MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("Error hashing password: %s", err)
+	}
+
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+func (u *User) insert(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if existing, err := GetUserByName(u.Username); err != nil {
+		return err
+	} else if existing != nil {
+		return ErrUsernameTaken
+	}
+
+	if u.Created.IsZero() {
+		u.Created = time.Now()
+	}
+
+	result, err := store.Exec(INS_USER, u.namedArgs()...)
+	if err != nil {
+		return fmt.Errorf("Error inserting user: %s", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		u.Id = int(id)
+	}
+
+	return nil
+}
+
+func (u *User) update(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	args := append(u.namedArgs(), sql.Named("id", u.Id))
+	_, err := store.Exec(UPD_USER, args...)
+	if err != nil {
+		return fmt.Errorf("Error updating user: %s", err)
+	}
+
+	return nil
+}
+
+func (u *User) delete(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if err := DeleteUserSessions(u.Id); err != nil {
+		return err
+	}
+
+	if _, err := store.Exec("delete from account_roles where user_id = @id", sql.Named("id", u.Id)); err != nil {
+		return fmt.Errorf("Error removing user's account roles: %s", err)
+	}
+
+	_, err := store.Exec("delete from users where id = @id", sql.Named("id", u.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting user: %s", err)
+	}
+
+	return nil
+}
+
+func (u *User) namedArgs() []any {
+	return []any{
+		sql.Named("username", u.Username),
+		sql.Named("password_hash", u.PasswordHash),
+		sql.Named("is_admin", u.IsAdmin),
+		sql.Named("created", u.Created.Unix()),
+	}
+}
+
+func GetUser(id int) (*User, error) {
+	users, err := queryUsers(Q_USERS+" where id = @id", sql.Named("id", id))
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+
+	return &users[0], nil
+}
+
+// GetUserByName finds a user ignoring case, so Alice and alice can't both
+// sign up.
+func GetUserByName(username string) (*User, error) {
+	users, err := queryUsers(Q_USERS+" where lower(username) = lower(@username)", sql.Named("username", username))
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+
+	return &users[0], nil
+}
+
+func FetchAllUsers() ([]User, error) {
+	return queryUsers(Q_USERS + " order by lower(username)")
+}
+
+func CountUsers() (int, error) {
+	if err := checkStore(); err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := store.QueryRow("select count(*) from users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("Error counting users: %s", err)
+	}
+
+	return count, nil
+}
+
+func queryUsers(query string, args ...any) ([]User, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching users: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []User
+	for rows.Next() {
+		var u User
+		var created int64
+		if err = rows.Scan(&u.Id, &u.Username, &u.PasswordHash, &u.IsAdmin, &created); err != nil {
+			return nil, fmt.Errorf("Error reading users: %s", err)
+		}
+
+		u.Created = time.Unix(created, 0)
+		results = append(results, u)
+	}
+
+	return results, nil
+}
+
+func SaveSession(s *Session) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	_, err := store.Exec(INS_SESSION,
+		sql.Named("token_hash", s.TokenHash),
+		sql.Named("user_id", s.UserId),
+		sql.Named("created", s.Created.Unix()),
+		sql.Named("expires", s.Expires.Unix()))
+	if err != nil {
+		return fmt.Errorf("Error saving session: %s", err)
+	}
+
+	return nil
+}
+
+// FindSession returns the unexpired session with the given token hash, or nil.
+func FindSession(tokenHash string) (*Session, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	s := Session{TokenHash: tokenHash}
+	var created, expires int64
+	err := store.QueryRow(Q_SESSION, sql.Named("token_hash", tokenHash), sql.Named("now", time.Now().Unix())).
+		Scan(&s.UserId, &created, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading session: %s", err)
+	}
+
+	s.Created = time.Unix(created, 0)
+	s.Expires = time.Unix(expires, 0)
+	return &s, nil
+}
+
+func DeleteSession(tokenHash string) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if _, err := store.Exec("delete from sessions where token_hash = @token_hash", sql.Named("token_hash", tokenHash)); err != nil {
+		return fmt.Errorf("Error deleting session: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteUserSessions logs the user out everywhere.
+func DeleteUserSessions(userId int) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if _, err := store.Exec("delete from sessions where user_id = @user_id", sql.Named("user_id", userId)); err != nil {
+		return fmt.Errorf("Error deleting sessions: %s", err)
+	}
+
+	return nil
+}
+
+func DeleteExpiredSessions() error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	if _, err := store.Exec("delete from sessions where expires <= @now", sql.Named("now", time.Now().Unix())); err != nil {
+		return fmt.Errorf("Error deleting expired sessions: %s", err)
+	}
+
+	return nil
+}
+
+const CT_USERS = `
+	create table if not exists users (
+		id integer primary key,
+		username varchar(100),
+		password_hash varchar(100),
+		is_admin integer,
+		created integer
+	);
+`
+
+const CT_SESSIONS = `
+	create table if not exists sessions (
+		token_hash varchar(64) primary key,
+		user_id integer,
+		created integer,
+		expires integer
+	);
+`
+
+const Q_USERS = `
+	select id
+	     , username
+	     , password_hash
+	     , is_admin
+	     , created
+	from users
+`
+
+const INS_USER = `
+	insert into users (username, password_hash, is_admin, created)
+	values (@username, @password_hash, @is_admin, @created)
+`
+
+const UPD_USER = `
+	update users
+	set username = @username,
+	    password_hash = @password_hash,
+	    is_admin = @is_admin,
+	    created = @created
+	where id = @id;
+`
+
+const INS_SESSION = `
+	insert into sessions (token_hash, user_id, created, expires)
+	values (@token_hash, @user_id, @created, @expires)
+`
+
+const Q_SESSION = `
+	select user_id
+	     , created
+	     , expires
+	from sessions
+	where token_hash = @token_hash
+	  and expires > @now
+`
@@ -0,0 +1,314 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// Webhook is an address the server POSTs to when one of Events happens.
+// Events is a comma separated list. AccountId limits it to one account, 0
+// meaning any, and Threshold is the balance, in cents, below which a
+// balance.low event is sent. Each request is signed with Secret.
+type Webhook struct {
+	Id        int
+	Name      string
+	Url       string
+	Secret    string
+	Events    string
+	AccountId int
+	Threshold utils.Money
+	Enabled   bool
+}
+
+// WebhookDelivery is one attempt at sending an event to a webhook.
+type WebhookDelivery struct {
+	Id         int
+	WebhookId  int
+	DeliveryId string
+	Event      string
+	Attempt    int
+	Time       time.Time
+	StatusCode int
+	Error      string
+	Delivered  bool
+}
+
+// deliveriesKept is how many attempts the delivery log keeps.
+const deliveriesKept = 500
+
+// Wants is whether the webhook is sent the event for the account. Events
+// about no account in particular go to every webhook that wants them.
+func (h *Webhook) Wants(event string, accountId int) bool {
+	if !h.Enabled || !slices.Contains(h.EventList(), event) {
+		return false
+	}
+	return h.AccountId == 0 || accountId == 0 || h.AccountId == accountId
+}
+
+func (h *Webhook) EventList() []string {
+	var events []string
+	for _, e := range strings.Split(h.Events, ",") {
+		if e = strings.TrimSpace(e); len(e) > 0 {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (h *Webhook) insert(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	result, err := store.Exec(INS_WEBHOOK, h.namedArgs()...)
+	if err != nil {
+		return fmt.Errorf("Error inserting webhook: %s", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		h.Id = int(id)
+	}
+
+	return nil
+}
+
+func (h *Webhook) update(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	args := append(h.namedArgs(), sql.Named("id", h.Id))
+	_, err := store.Exec(UPD_WEBHOOK, args...)
+	if err != nil {
+		return fmt.Errorf("Error updating webhook: %s", err)
+	}
+
+	return nil
+}
+
+func (h *Webhook) delete(*Ledger) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	_, err := store.Exec("delete from webhooks where id = @id", sql.Named("id", h.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting webhook: %s", err)
+	}
+
+	_, err = store.Exec("delete from webhook_deliveries where webhook_id = @id", sql.Named("id", h.Id))
+	if err != nil {
+		return fmt.Errorf("Error deleting webhook deliveries: %s", err)
+	}
+
+	return nil
+}
+
+func (h *Webhook) namedArgs() []any {
+	return []any{
+		sql.Named("name", h.Name),
+		sql.Named("url", h.Url),
+		sql.Named("secret", h.Secret),
+		sql.Named("events", h.Events),
+		sql.Named("account_id", h.AccountId),
+		sql.Named("threshold", h.Threshold),
+		sql.Named("enabled", h.Enabled),
+	}
+}
+
+func GetWebhook(id int) (*Webhook, error) {
+	hooks, err := queryWebhooks(Q_WEBHOOKS+" where id = @id", sql.Named("id", id))
+	if err != nil || len(hooks) == 0 {
+		return nil, err
+	}
+
+	return &hooks[0], nil
+}
+
+func FetchAllWebhooks() ([]Webhook, error) {
+	return queryWebhooks(Q_WEBHOOKS + " order by name, id")
+}
+
+func queryWebhooks(query string, args ...any) ([]Webhook, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching webhooks: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []Webhook
+	for rows.Next() {
+		var h Webhook
+		err = rows.Scan(&h.Id, &h.Name, &h.Url, &h.Secret, &h.Events, &h.AccountId, &h.Threshold, &h.Enabled)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading webhooks: %s", err)
+		}
+
+		results = append(results, h)
+	}
+
+	return results, nil
+}
+
+// RecordDelivery adds an attempt to the delivery log, dropping the oldest
+// once it holds more than deliveriesKept.
+func RecordDelivery(d *WebhookDelivery) error {
+	if err := checkStore(); err != nil {
+		return err
+	}
+
+	result, err := store.Exec(INS_WEBHOOK_DELIVERY,
+		sql.Named("webhook_id", d.WebhookId),
+		sql.Named("delivery_id", d.DeliveryId),
+		sql.Named("event", d.Event),
+		sql.Named("attempt", d.Attempt),
+		sql.Named("time", d.Time.UnixMilli()),
+		sql.Named("status_code", d.StatusCode),
+		sql.Named("error", d.Error),
+		sql.Named("delivered", d.Delivered),
+	)
+	if err != nil {
+		return fmt.Errorf("Error recording webhook delivery: %s", err)
+	}
+
+	if id, err := result.LastInsertId(); err == nil {
+		d.Id = int(id)
+	}
+
+	_, err = store.Exec("delete from webhook_deliveries where id <= @id", sql.Named("id", d.Id-deliveriesKept))
+	if err != nil {
+		return fmt.Errorf("Error trimming webhook deliveries: %s", err)
+	}
+
+	return nil
+}
+
+// FetchDeliveries returns the newest limit attempts, newest first.
+func FetchDeliveries(limit int) ([]WebhookDelivery, error) {
+	if err := checkStore(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.Query(Q_WEBHOOK_DELIVERIES, sql.Named("limit", limit))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching webhook deliveries: %s", err)
+	}
+
+	defer rows.Close()
+
+	var results []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var at int64
+		err = rows.Scan(&d.Id, &d.WebhookId, &d.DeliveryId, &d.Event, &d.Attempt, &at, &d.StatusCode, &d.Error, &d.Delivered)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading webhook deliveries: %s", err)
+		}
+
+		d.Time = time.UnixMilli(at)
+		results = append(results, d)
+	}
+
+	return results, nil
+}
+
+const CT_WEBHOOKS = `
+	create table if not exists webhooks (
+		id integer primary key,
+		name varchar(100),
+		url varchar(1000),
+		secret varchar(100),
+		events varchar(255),
+		account_id integer,
+		threshold integer,
+		enabled integer
+	);
+`
+
+const CT_WEBHOOK_DELIVERIES = `
+	create table if not exists webhook_deliveries (
+		id integer primary key,
+		webhook_id integer,
+		delivery_id varchar(64),
+		event varchar(50),
+		attempt integer,
+		time integer,
+		status_code integer,
+		error varchar(1000),
+		delivered integer
+	);
+`
+
+const Q_WEBHOOKS = `
+	select id
+	     , name
+	     , url
+	     , secret
+	     , events
+	     , account_id
+	     , threshold
+	     , enabled
+	from webhooks
+`
+
+const INS_WEBHOOK = `
+	insert into webhooks (
+		  name
+		, url
+		, secret
+		, events
+		, account_id
+		, threshold
+		, enabled)
+	values (@name, @url, @secret, @events, @account_id, @threshold, @enabled)
+`
+
+const UPD_WEBHOOK = `
+	update webhooks
+	set name = @name,
+	    url = @url,
+	    secret = @secret,
+	    events = @events,
+	    account_id = @account_id,
+	    threshold = @threshold,
+	    enabled = @enabled
+	where id = @id;
+`
+
+const INS_WEBHOOK_DELIVERY = `
+	insert into webhook_deliveries (
+		  webhook_id
+		, delivery_id
+		, event
+		, attempt
+		, time
+		, status_code
+		, error
+		, delivered)
+	values (@webhook_id, @delivery_id, @event, @attempt, @time, @status_code, @error, @delivered)
+`
+
+const Q_WEBHOOK_DELIVERIES = `
+	select id
+	     , webhook_id
+	     , delivery_id
+	     , event
+	     , attempt
+	     , time
+	     , status_code
+	     , error
+	     , delivered
+	from webhook_deliveries
+	order by id desc
+	limit @limit
+`
@@ -0,0 +1,194 @@
+package duplicates
+
+import (
+	"sort"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	"unicode"
+)
+
+const (
+	// WindowDays is how far apart two dates can be and still match. Card
+	// purchases usually post within a few days of being entered by hand.
+	WindowDays = 5
+
+	// Threshold is the lowest score reported as a likely duplicate.
+	Threshold = 0.6
+
+	dateWeight = 0.4
+	nameWeight = 0.6
+)
+
+const (
+	ActionSkip  = "skip"
+	ActionKeep  = "keep"
+	ActionMerge = "merge"
+)
+
+type Candidate struct {
+	Existing db.Transaction
+	Score    float64
+}
+
+// Score rates how likely a and b are the same transaction, from 0 to 1.
+// Amounts have to match to the cent; after that the closeness of the dates
+// and the similarity of the names decide.
+func Score(a db.Transaction, b db.Transaction) float64 {
+	if a.Amount != b.Amount {
+		return 0
+	}
+
+	days := a.Date.Sub(b.Date).Hours() / 24
+	if days < 0 {
+		days = -days
+	}
+	if days > WindowDays {
+		return 0
+	}
+
+	dateScore := 1 - days/(WindowDays+1)
+	return dateWeight*dateScore + nameWeight*NameSimilarity(a.Name, b.Name)
+}
+
+// NameSimilarity compares two payee names, from 0 to 1. Bank descriptions
+// carry extra noise like "POS PURCHASE 4411 SAFEWAY #1234", so a short name
+// whose words all appear in the longer one counts as a full match.
+func NameSimilarity(a string, b string) float64 {
+	ta := tokens(a)
+	tb := tokens(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	if len(ta) > len(tb) {
+		ta, tb = tb, ta
+	}
+
+	found := 0
+	for _, t := range ta {
+		for _, u := range tb {
+			if t == u || (len(t) > 3 && ratio(t, u) >= 0.8) {
+				found++
+				break
+			}
+		}
+	}
+
+	containment := float64(found) / float64(len(ta))
+	whole := ratio(strings.Join(ta, " "), strings.Join(tb, " "))
+	if containment > whole {
+		return containment
+	}
+	return whole
+}
+
+// tokens lowercases a name and drops numbers and punctuation, which are
+// mostly store and terminal ids.
+func tokens(name string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	var results []string
+	for _, f := range fields {
+		if len(f) > 1 {
+			results = append(results, f)
+		}
+	}
+	return results
+}
+
+// ratio is the Levenshtein distance between a and b scaled to a similarity
+// between 0 and 1.
+func ratio(a string, b string) float64 {
+	ra := []rune(a)
+	rb := []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	longest := max(len(ra), len(rb))
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+type Matcher struct {
+	existing []db.Transaction
+	used     map[int]bool
+}
+
+// Load reads the account's transactions around the given dates from every
+// period so a batch of imported rows can be checked with a single pass over
+// the data directory.
+func Load(dir string, accountId int, first time.Time, last time.Time) (*Matcher, error) {
+	from := first.AddDate(0, 0, -WindowDays)
+	to := last.AddDate(0, 0, WindowDays+1)
+
+	transactions, err := db.FetchTransactionsBetween(dir, from, to, db.AccountSet{accountId: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Matcher{existing: transactions, used: map[int]bool{}}, nil
+}
+
+// Best returns the strongest match for t at or above Threshold. Each
+// existing transaction is only handed out once, so two identical coffees on
+// the same day can't both match the same entry.
+func (m *Matcher) Best(t db.Transaction) *Candidate {
+	var candidates []Candidate
+	for i, e := range m.existing {
+		if m.used[i] {
+			continue
+		}
+
+		if score := Score(t, e); score >= Threshold {
+			candidates = append(candidates, Candidate{Existing: e, Score: score})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	for i, e := range m.existing {
+		if e.Id == candidates[0].Existing.Id && e.Period == candidates[0].Existing.Period {
+			m.used[i] = true
+		}
+	}
+
+	return &candidates[0]
+}
+
+// Find checks a single transaction, as entered by hand, against the
+// account's existing ones.
+func Find(dir string, t db.Transaction) (*Candidate, error) {
+	m, err := Load(dir, t.AccountId, t.Date, t.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Best(t), nil
+}
@@ -0,0 +1,114 @@
+package duplicates
+
+import (
+	"math"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+var entered = db.Transaction{Id: 100, Name: "Safeway", Amount: -4510, Date: time.Date(2026, time.October, 7, 0, 0, 0, 0, time.UTC)}
+
+// like is entered from the bank's side, days later with a different name.
+func like(id int, days int, name string, amount utils.Money) db.Transaction {
+	return db.Transaction{Id: id, Name: name, Amount: amount, Date: entered.Date.AddDate(0, 0, days)}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		existing db.Transaction
+		score    float64
+		matches  bool
+	}{
+		{like(1, 0, "Safeway", -4510), 1, true},
+		{like(1, 1, "Safeway", -4510), 0.4*(1-1.0/6) + 0.6, true},
+		{like(1, -1, "Safeway", -4510), 0.4*(1-1.0/6) + 0.6, true},
+		{like(1, WindowDays, "Safeway", -4510), 0.4*(1-5.0/6) + 0.6, true},
+		{like(1, WindowDays+1, "Safeway", -4510), 0, false},
+		{like(1, -WindowDays-1, "Safeway", -4510), 0, false},
+		{like(1, 1, "POS PURCHASE 4411 SAFEWAY #1234", -4510), 0.4*(1-1.0/6) + 0.6, true},
+		{like(1, 0, "Shell Oil", -4510), -1, false},
+		{like(1, 0, "Safeway", -4511), 0, false},
+		{like(1, 0, "Safeway", 4510), 0, false},
+	}
+
+	for _, test := range tests {
+		score := Score(entered, test.existing)
+		if matches := score >= Threshold; matches != test.matches {
+			t.Errorf("%q %d days off, %d: score %.3f, wanted match %v", test.existing.Name,
+				int(test.existing.Date.Sub(entered.Date).Hours()/24), test.existing.Amount, score, test.matches)
+		}
+		if test.score >= 0 && math.Abs(score-test.score) > 1e-9 {
+			t.Errorf("%q %d days off, %d: score %.3f, want %.3f", test.existing.Name,
+				int(test.existing.Date.Sub(entered.Date).Hours()/24), test.existing.Amount, score, test.score)
+		}
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a       string
+		b       string
+		similar bool
+	}{
+		{"Safeway", "SAFEWAY", true},
+		{"Safeway", "POS PURCHASE 4411 SAFEWAY #1234", true},
+		{"Starbucks", "STARBUCK'S COFFEE 0412", true},
+		{"Trader Joes", "TRADER JOE'S #552", true},
+		{"Safeway", "Shell Oil", false},
+		{"Rent", "Netflix", false},
+		{"Safeway", "#1234 5678", false},
+		{"", "Safeway", false},
+	}
+
+	for _, test := range tests {
+		similarity := NameSimilarity(test.a, test.b)
+		if similarity < 0 || similarity > 1 {
+			t.Errorf("%q and %q: similarity %.3f is out of range", test.a, test.b, similarity)
+		}
+		if similar := similarity >= 0.8; similar != test.similar {
+			t.Errorf("%q and %q: similarity %.3f, wanted similar %v", test.a, test.b, similarity, test.similar)
+		}
+		if reverse := NameSimilarity(test.b, test.a); reverse != similarity {
+			t.Errorf("%q and %q: similarity %.3f one way and %.3f the other", test.a, test.b, similarity, reverse)
+		}
+	}
+}
+
+func TestMatcherBest(t *testing.T) {
+	october := db.Period{Year: 2026, Month: time.October}
+	september := db.Period{Year: 2026, Month: time.September}
+
+	m := &Matcher{used: map[int]bool{}}
+	for _, e := range []db.Transaction{
+		like(1, 3, "Safeway", -4510),
+		like(2, 0, "SAFEWAY #1234", -4510),
+		like(3, 0, "Safeway", -4999),
+		like(4, 0, "Shell Oil", -4510),
+		like(1, -4, "Safeway", -4510),
+	} {
+		e.Period = october
+		m.existing = append(m.existing, e)
+	}
+	// The last one is another period's transaction 1.
+	m.existing[4].Period = september
+
+	// Each call takes the best of what hasn't matched yet.
+	for _, want := range []struct {
+		id     int
+		period db.Period
+	}{{2, october}, {1, october}, {1, september}} {
+		best := m.Best(entered)
+		if best == nil {
+			t.Fatalf("No match, want %d in %s", want.id, want.period.Month)
+		}
+		if best.Existing.Id != want.id || best.Existing.Period != want.period {
+			t.Errorf("Matched %d in %s scoring %.3f, want %d in %s", best.Existing.Id, best.Existing.Period.Month, best.Score, want.id, want.period.Month)
+		}
+	}
+
+	if best := m.Best(entered); best != nil {
+		t.Errorf("Matched %d again after every likely duplicate was used", best.Existing.Id)
+	}
+}
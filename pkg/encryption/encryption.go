@@ -0,0 +1,168 @@
+// Package encryption seals backups and exports with a passphrase.
+//
+// An encrypted file is a fixed header followed by the sealed contents:
+//
+//	magic    8 bytes   "SACMENC" and a format version byte, 1
+//	time     4 bytes   argon2id passes, big endian
+//	memory   4 bytes   argon2id memory in KiB, big endian
+//	threads  1 byte    argon2id parallelism
+//	salt     16 bytes
+//	nonce    24 bytes
+//	sealed   the rest  XChaCha20-Poly1305 ciphertext and tag
+//
+// The key is argon2id(passphrase, salt) with the parameters in the header,
+// and the whole header is authenticated along with the contents, so any
+// change to the file is caught the same way a wrong passphrase is.
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	version = 1
+
+	saltSize   = 16
+	keySize    = chacha20poly1305.KeySize
+	headerSize = 8 + 4 + 4 + 1 + saltSize + chacha20poly1305.NonceSizeX
+
+	// Extension is added to the names of encrypted downloads.
+	Extension = ".enc"
+)
+
+var magic = []byte("SACMENC")
+
+// The argon2id cost used for new files. Files keep the cost they were written
+// with, so these can be raised without breaking older backups.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+
+	// Reading refuses more than four times the cost of writing, 256 MiB and
+	// 12 passes, so a crafted header can't make a restore allocate gigabytes
+	// before the passphrase is even checked.
+	maxArgonTime    = 4 * argonTime
+	maxArgonMemory  = 4 * argonMemory
+	maxArgonThreads = 4 * argonThreads
+)
+
+var (
+	ErrWrongPassphrase = errors.New("Wrong passphrase, or the file has been changed since it was encrypted.")
+	ErrNotEncrypted    = errors.New("The file is not encrypted.")
+	ErrEmptyPassphrase = errors.New("A passphrase is required.")
+)
+
+// IsEncrypted reports whether data starts like an encrypted file.
+func IsEncrypted(data []byte) bool {
+	return len(data) > len(magic) && bytes.Equal(data[:len(magic)], magic)
+}
+
+func deriveKey(passphrase string, salt []byte, time uint32, memory uint32, threads uint8) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, time, memory, threads, keySize)
+}
+
+// Encrypt seals plaintext with a key derived from passphrase.
+func Encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	binary.BigEndian.PutUint32(header[8:], argonTime)
+	binary.BigEndian.PutUint32(header[12:], argonMemory)
+	header[16] = argonThreads
+
+	salt := header[17 : 17+saltSize]
+	nonce := header[17+saltSize:]
+	if _, err := rand.Read(header[17:]); err != nil {
+		return nil, fmt.Errorf("Error generating salt: %s", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(deriveKey(passphrase, salt, argonTime, argonMemory, argonThreads))
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// Decrypt opens data sealed by Encrypt. A wrong passphrase and a damaged
+// file both return ErrWrongPassphrase; there is no way to tell them apart.
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+
+	if len(data) < headerSize+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("The encrypted file is truncated.")
+	}
+
+	if data[len(magic)] != version {
+		return nil, fmt.Errorf("Encryption version %d is not supported, this sacmoney reads version %d.", data[len(magic)], version)
+	}
+
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	header := data[:headerSize]
+	time := binary.BigEndian.Uint32(header[8:])
+	memory := binary.BigEndian.Uint32(header[12:])
+	threads := header[16]
+	if time == 0 || time > maxArgonTime || memory == 0 || memory > maxArgonMemory || threads == 0 || threads > maxArgonThreads {
+		return nil, ErrWrongPassphrase
+	}
+
+	salt := header[17 : 17+saltSize]
+	nonce := header[17+saltSize:]
+
+	aead, err := chacha20poly1305.NewX(deriveKey(passphrase, salt, time, memory, threads))
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, data[headerSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return plaintext, nil
+}
+
+type writer struct {
+	out        io.Writer
+	passphrase string
+	buffer     bytes.Buffer
+}
+
+// NewWriter returns a writer that encrypts everything written to it and
+// writes the result to w on Close. Nothing reaches w until then, since the
+// contents are sealed as a whole.
+func NewWriter(w io.Writer, passphrase string) io.WriteCloser {
+	return &writer{out: w, passphrase: passphrase}
+}
+
+func (e *writer) Write(p []byte) (int, error) {
+	return e.buffer.Write(p)
+}
+
+func (e *writer) Close() error {
+	sealed, err := Encrypt(e.buffer.Bytes(), e.passphrase)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.out.Write(sealed)
+	return err
+}
@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+const passphrase = "correct horse battery staple"
+
+var plaintext = []byte(`{"version":1,"periods":["2026-10"]}`)
+
+func TestRoundTrip(t *testing.T) {
+	for _, contents := range [][]byte{plaintext, {}, bytes.Repeat([]byte{0, 0xff}, 100000)} {
+		sealed, err := Encrypt(contents, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(sealed) || IsEncrypted(contents) {
+			t.Errorf("IsEncrypted can't tell sealed from plain")
+		}
+		if len(contents) > 0 && bytes.Contains(sealed, contents) {
+			t.Errorf("The sealed file holds the plaintext")
+		}
+
+		opened, err := Decrypt(sealed, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, contents) {
+			t.Errorf("Decrypted %d bytes, want %d", len(opened), len(contents))
+		}
+	}
+
+	// Every file gets its own salt and nonce.
+	first, _ := Encrypt(plaintext, passphrase)
+	second, _ := Encrypt(plaintext, passphrase)
+	if bytes.Equal(first, second) {
+		t.Errorf("Encrypting twice gave the same file")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, passphrase)
+	w.Write(plaintext[:10])
+	w.Write(plaintext[10:])
+	if out.Len() != 0 {
+		t.Errorf("Wrote %d bytes before Close", out.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := Decrypt(out.Bytes(), passphrase)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Decrypted %q, %v", opened, err)
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	sealed, err := Encrypt(plaintext, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changed copies sealed and lets change alter the copy.
+	changed := func(change func(data []byte) []byte) []byte {
+		return change(append([]byte{}, sealed...))
+	}
+	flip := func(i int) []byte {
+		return changed(func(data []byte) []byte {
+			data[i] ^= 0x01
+			return data
+		})
+	}
+	cost := func(offset int, value uint32) []byte {
+		return changed(func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[offset:], value)
+			return data
+		})
+	}
+	threads := func(value byte) []byte {
+		return changed(func(data []byte) []byte {
+			data[16] = value
+			return data
+		})
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		err        error
+	}{
+		{"wrong passphrase", sealed, "correct horse battery stapler", ErrWrongPassphrase},
+		{"empty passphrase", sealed, "", ErrEmptyPassphrase},
+		{"not encrypted", plaintext, passphrase, ErrNotEncrypted},
+		{"flipped magic", flip(0), passphrase, ErrNotEncrypted},
+		{"flipped time", flip(11), passphrase, ErrWrongPassphrase},
+		{"flipped salt", flip(17), passphrase, ErrWrongPassphrase},
+		{"flipped nonce", flip(headerSize - 1), passphrase, ErrWrongPassphrase},
+		{"flipped body", flip(headerSize), passphrase, ErrWrongPassphrase},
+		{"flipped tag", flip(len(sealed) - 1), passphrase, ErrWrongPassphrase},
+		{"truncated body", sealed[:len(sealed)-1], passphrase, ErrWrongPassphrase},
+		{"truncated header", sealed[:headerSize], passphrase, nil},
+		{"magic only", sealed[:len(magic)+1], passphrase, nil},
+		{"unknown version", flip(len(magic)), passphrase, nil},
+		{"no time", cost(8, 0), passphrase, ErrWrongPassphrase},
+		{"too much time", cost(8, maxArgonTime+1), passphrase, ErrWrongPassphrase},
+		{"no memory", cost(12, 0), passphrase, ErrWrongPassphrase},
+		{"too much memory", cost(12, maxArgonMemory+1), passphrase, ErrWrongPassphrase},
+		{"far too much memory", cost(12, 0xffffffff), passphrase, ErrWrongPassphrase},
+		{"no threads", threads(0), passphrase, ErrWrongPassphrase},
+		{"too many threads", threads(maxArgonThreads + 1), passphrase, ErrWrongPassphrase},
+	}
+
+	for _, test := range tests {
+		opened, err := Decrypt(test.data, test.passphrase)
+		if err == nil || opened != nil {
+			t.Errorf("%s: decrypted %q, %v", test.name, opened, err)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: error %q, want %q", test.name, err, test.err)
+		}
+	}
+
+	if _, err = Encrypt(plaintext, ""); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("Encrypting without a passphrase: %v", err)
+	}
+}
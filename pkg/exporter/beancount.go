@@ -0,0 +1,95 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// beancountCurrency is written on every amount. sacmoney doesn't track
+// currencies, so everything is assumed to be in dollars.
+const beancountCurrency = "USD"
+
+// WriteBeancount writes the ledger in beancount syntax. Every account is
+// opened on the first day of the export, before anything posts to it.
+func (l *Ledger) WriteBeancount(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	entries := l.entries()
+
+	fmt.Fprintf(out, "; Exported from sacmoney, %s to %s\n\n",
+		l.From.Format("2006-01-02"), l.To.AddDate(0, 0, -1).Format("2006-01-02"))
+	fmt.Fprintf(out, "option \"operating_currency\" \"%s\"\n\n", beancountCurrency)
+
+	for _, name := range usedAccounts(entries, beancountAccount) {
+		fmt.Fprintf(out, "%s open %s %s\n", l.From.Format("2006-01-02"), name, beancountCurrency)
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(out, "\n%s * %s %s", e.Date.Format("2006-01-02"),
+			beancountString(e.Payee), beancountString(e.Memo))
+
+		for _, tag := range e.Tags {
+			if tag = beancountTag(tag); len(tag) > 0 {
+				fmt.Fprintf(out, " #%s", tag)
+			}
+		}
+		fmt.Fprintf(out, "\n")
+
+		if len(e.CheckNumber) > 0 {
+			fmt.Fprintf(out, "  check: %s\n", beancountString(e.CheckNumber))
+		}
+
+		for _, p := range e.Postings {
+			fmt.Fprintf(out, "  %s  %s %s\n", beancountAccount(p.Account), p.Amount.String(), beancountCurrency)
+		}
+	}
+
+	return out.Flush()
+}
+
+// beancountAccount spells a path the way beancount requires: every
+// component starts with a capital letter or digit and holds only letters,
+// digits and dashes. "Gas & fuel" becomes "Gas-Fuel".
+func beancountAccount(path []string) string {
+	var parts []string
+	for _, p := range path {
+		words := strings.FieldsFunc(p, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+
+		for i, word := range words {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+
+		component := strings.Join(words, "-")
+		if len(component) == 0 || !(unicode.IsUpper([]rune(component)[0]) || unicode.IsDigit([]rune(component)[0])) {
+			component = "X" + component
+		}
+		parts = append(parts, component)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+func beancountString(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return `"` + value + `"`
+}
+
+// beancountTag keeps the characters beancount allows in a tag.
+func beancountTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_/.", r) {
+			return r
+		}
+		if unicode.IsSpace(r) {
+			return '-'
+		}
+		return -1
+	}, strings.TrimSpace(tag))
+}
@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"fmt"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// Ledger is everything an exporter needs for a date range: the accounts with
+// TotalAvailable holding their opening balance at From, and the transactions
+// dated in [From, To).
+type Ledger struct {
+	From         time.Time
+	To           time.Time
+	Accounts     []db.Account
+	Transactions []db.Transaction
+
+	allAccounts []db.Account
+}
+
+// Load gathers the ledger for the accounts in the set. Transfers to accounts
+// outside it are still named, but their own entries are left out.
+func Load(dir string, accounts db.AccountSet, from time.Time, to time.Time) (Ledger, error) {
+	if !to.After(from) {
+		return Ledger{}, fmt.Errorf("Export end date must be after the start date.")
+	}
+
+	closing, err := db.NetWorthAsOf(dir, to, nil)
+	if err != nil {
+		return Ledger{}, err
+	}
+
+	opening, err := db.NetWorthAsOf(dir, from, accounts)
+	if err != nil {
+		return Ledger{}, err
+	}
+
+	openingBalances := map[int]utils.Money{}
+	for _, a := range opening.Accounts {
+		openingBalances[a.Id] = a.TotalAvailable
+	}
+
+	ledger := Ledger{From: from, To: to, allAccounts: closing.Accounts}
+	for _, a := range closing.Accounts {
+		if !accounts.Allows(a.Id) {
+			continue
+		}
+
+		a.TotalAvailable = openingBalances[a.Id]
+		ledger.Accounts = append(ledger.Accounts, a)
+	}
+
+	if len(ledger.Accounts) == 0 {
+		return Ledger{}, fmt.Errorf("There are no accounts to export.")
+	}
+
+	ledger.Transactions, err = db.FetchTransactionsBetween(dir, from, to, accounts)
+	if err != nil {
+		return Ledger{}, err
+	}
+
+	return ledger, nil
+}
+
+func (l *Ledger) account(id int) db.Account {
+	for _, a := range l.Accounts {
+		if a.Id == id {
+			return a
+		}
+	}
+	return db.Account{Id: id, Name: fmt.Sprintf("Account %d", id), Kind: db.AccountAsset}
+}
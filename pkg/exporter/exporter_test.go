@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+func date(day int) time.Time {
+	return time.Date(2026, time.October, day, 0, 0, 0, 0, time.UTC)
+}
+
+// fixtureLedger has opening balances on both sides of the balance sheet, a
+// transfer recorded in both accounts, a transfer to an account that isn't
+// exported, and splits that don't quite add up to their transaction.
+func fixtureLedger() Ledger {
+	checking := db.Account{Id: 1, Name: "Checking", Kind: db.AccountAsset, TotalAvailable: 150000}
+	visa := db.Account{Id: 2, Name: "Visa", Kind: db.AccountLiability, TotalAvailable: -32050}
+	savings := db.Account{Id: 3, Name: "Savings", Kind: db.AccountAsset}
+
+	return Ledger{
+		From:        date(1),
+		To:          date(31),
+		Accounts:    []db.Account{checking, visa},
+		allAccounts: []db.Account{checking, visa, savings},
+		Transactions: []db.Transaction{
+			{Id: 1, AccountId: 1, Date: date(2), Name: "Paycheck", Amount: 250000, Category: "Salary"},
+			{Id: 2, AccountId: 1, Date: date(3), Name: "Safeway", Amount: -8523, Tags: "food, weekly",
+				Splits: []db.Split{
+					{Category: "Groceries", Amount: -6000},
+					{Category: "Household:Cleaning", Amount: -2000},
+				}},
+			{Id: 3, AccountId: 1, Date: date(5), Name: "Card payment", Amount: -32050, Category: "[Visa]"},
+			{Id: 4, AccountId: 2, Date: date(6), Name: "Payment thanks", Amount: 32050, Category: "[Checking]"},
+			{Id: 5, AccountId: 1, Date: date(9), Name: "To savings", Amount: -10000, Category: "[Savings]"},
+			{Id: 6, AccountId: 2, Date: date(12), Name: "Gas & fuel", Amount: -4512, Category: "Auto:Gas", CheckNumber: "1001", Memo: "road trip"},
+		},
+	}
+}
+
+// entryTotals reads the postings of every entry in an export, one entry per
+// blank-line separated block, and returns what each adds up to.
+func entryTotals(t *testing.T, out string, amount func(string) string) []utils.Money {
+	var totals []utils.Money
+	inEntry := false
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			inEntry = false
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			if len(line) > 10 && line[4] == '-' && (strings.Contains(line, " * ") || strings.HasSuffix(line, " *")) {
+				totals = append(totals, 0)
+				inEntry = true
+			}
+			continue
+		}
+
+		if !inEntry || strings.HasPrefix(strings.TrimSpace(line), ";") || strings.HasPrefix(strings.TrimSpace(line), "check:") {
+			continue
+		}
+
+		_, value, found := strings.Cut(strings.TrimSpace(line), "  ")
+		if !found {
+			t.Fatalf("Posting without an amount: %q", line)
+		}
+
+		cents, err := utils.ParseMoney(amount(strings.TrimSpace(value)))
+		if err != nil {
+			t.Fatalf("Reading %q: %s", line, err)
+		}
+		totals[len(totals)-1] += cents
+	}
+
+	return totals
+}
+
+func journalValue(value string) string {
+	return strings.TrimPrefix(value, "$")
+}
+
+func beancountValue(value string) string {
+	return strings.TrimSuffix(value, " "+beancountCurrency)
+}
+
+func TestExportsBalance(t *testing.T) {
+	formats := []struct {
+		name   string
+		file   string
+		write  func(*Ledger, io.Writer) error
+		amount func(string) string
+		check  []string
+	}{
+		{"ledger", "export.ledger", (*Ledger).WriteLedger, journalValue, []string{"ledger", "-f", "", "balance"}},
+		{"hledger", "export.journal", (*Ledger).WriteHledger, journalValue, []string{"hledger", "check", "-f", ""}},
+		{"beancount", "export.beancount", (*Ledger).WriteBeancount, beancountValue, []string{"bean-check", ""}},
+	}
+
+	// Two opening balances, and every transaction but the second side of the
+	// Checking and Visa transfer.
+	const wantEntries = 2 + 5
+
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			ledger := fixtureLedger()
+			var out bytes.Buffer
+			if err := f.write(&ledger, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			totals := entryTotals(t, out.String(), f.amount)
+			if len(totals) != wantEntries {
+				t.Errorf("Got %d entries, want %d:\n%s", len(totals), wantEntries, out.String())
+			}
+			for i, total := range totals {
+				if total != 0 {
+					t.Errorf("Entry %d adds up to %s:\n%s", i+1, total, out.String())
+				}
+			}
+
+			runChecker(t, f.file, out.Bytes(), f.check)
+		})
+	}
+}
+
+// runChecker hands the export to the tool it is meant for, when it's
+// installed, so its own parser gets the final say.
+func runChecker(t *testing.T, file string, contents []byte, command []string) {
+	if _, err := exec.LookPath(command[0]); err != nil {
+		t.Logf("%s isn't installed, only checked the sums", command[0])
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), file)
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args := append([]string{}, command[1:]...)
+	for i, arg := range args {
+		if len(arg) == 0 {
+			args[i] = path
+		}
+	}
+
+	if out, err := exec.Command(command[0], args...).CombinedOutput(); err != nil {
+		t.Errorf("%s rejected the export: %s\n%s", command[0], err, out)
+	}
+}
@@ -0,0 +1,287 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// transferWindowDays is how far apart the two sides of a transfer can be
+// dated and still be written as one entry.
+const transferWindowDays = 5
+
+var openingBalancesAccount = []string{"Equity", "Opening Balances"}
+
+type posting struct {
+	Account []string
+	Amount  utils.Money
+}
+
+// entry is one balanced transaction in double-entry form. Account names are
+// kept as path components so each output syntax can spell them its own way.
+type entry struct {
+	Date        time.Time
+	Payee       string
+	Memo        string
+	CheckNumber string
+	Tags        []string
+	Postings    []posting
+}
+
+func accountPath(a db.Account) []string {
+	if a.Kind == db.AccountLiability {
+		return []string{"Liabilities", a.Name}
+	}
+	return []string{"Assets", a.Name}
+}
+
+// categoryPath maps a category to an expense or income account, depending on
+// which way the money moved. Quicken style "Parent:Child" categories become
+// sub-accounts.
+func categoryPath(category string, amount utils.Money) []string {
+	root := "Expenses"
+	if amount > 0 {
+		root = "Income"
+	}
+
+	path := []string{root}
+	category = strings.Trim(strings.TrimSpace(category), "[]")
+	for _, part := range strings.Split(category, ":") {
+		if part = strings.TrimSpace(part); len(part) > 0 {
+			path = append(path, part)
+		}
+	}
+
+	if len(path) == 1 {
+		path = append(path, "Uncategorized")
+	}
+	return path
+}
+
+// transferTarget returns the account named by a "[Account]" category, the
+// way QIF marks transfers. The account doesn't have to be part of the export.
+func (l *Ledger) transferTarget(category string) (db.Account, bool) {
+	category = strings.TrimSpace(category)
+	if !strings.HasPrefix(category, "[") || !strings.HasSuffix(category, "]") {
+		return db.Account{}, false
+	}
+
+	name := strings.TrimSpace(category[1 : len(category)-1])
+	for _, a := range l.allAccounts {
+		if strings.EqualFold(a.Name, name) {
+			return a, true
+		}
+	}
+
+	return db.Account{}, false
+}
+
+// counterPath is where the other side of a transaction or split goes: the
+// account a transfer points at, or an expense or income account.
+func (l *Ledger) counterPath(category string, amount utils.Money) []string {
+	if a, ok := l.transferTarget(category); ok {
+		return accountPath(a)
+	}
+	return categoryPath(category, amount)
+}
+
+// entries turns the ledger into balanced double-entry transactions: an
+// opening balance against equity for every account, then every transaction
+// with its category or splits on the other side. Both sides of a transfer
+// between two exported accounts are recorded in sacmoney, so the second one
+// found is dropped rather than counted twice.
+func (l *Ledger) entries() []entry {
+	var results []entry
+
+	for _, a := range l.Accounts {
+		if a.TotalAvailable == 0 {
+			continue
+		}
+
+		results = append(results, entry{
+			Date:  l.From,
+			Payee: "Opening Balance",
+			Postings: []posting{
+				{Account: accountPath(a), Amount: a.TotalAvailable},
+				{Account: openingBalancesAccount, Amount: -a.TotalAvailable},
+			},
+		})
+	}
+
+	skip := map[int]bool{}
+	for i, t := range l.Transactions {
+		if skip[i] {
+			continue
+		}
+
+		if target, ok := l.transferTarget(t.Category); ok && len(t.Splits) == 0 {
+			if j := l.findTransferPair(i, target); j >= 0 {
+				skip[j] = true
+			}
+		}
+
+		results = append(results, l.transactionEntry(&t))
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Date.Before(results[j].Date)
+	})
+
+	return results
+}
+
+// findTransferPair looks for the other side of transaction i in target: the
+// opposite amount, dated close by, and not already written.
+func (l *Ledger) findTransferPair(i int, target db.Account) int {
+	t := l.Transactions[i]
+	for j := i + 1; j < len(l.Transactions); j++ {
+		other := l.Transactions[j]
+		if other.AccountId != target.Id || other.Amount != -t.Amount || len(other.Splits) > 0 {
+			continue
+		}
+
+		days := other.Date.Sub(t.Date).Hours() / 24
+		if days < -transferWindowDays || days > transferWindowDays {
+			continue
+		}
+
+		if back, ok := l.transferTarget(other.Category); ok && back.Id == t.AccountId {
+			return j
+		}
+	}
+
+	return -1
+}
+
+func (l *Ledger) transactionEntry(t *db.Transaction) entry {
+	e := entry{
+		Date:        t.Date,
+		Payee:       t.PayeeName(),
+		Memo:        t.Memo,
+		CheckNumber: t.CheckNumber,
+		Postings:    []posting{{Account: accountPath(l.account(t.AccountId)), Amount: t.Amount}},
+	}
+
+	for _, tag := range strings.Split(t.Tags, ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			e.Tags = append(e.Tags, tag)
+		}
+	}
+
+	// Splits should add up to the amount, but whatever they leave over is
+	// posted to the transaction's own category so the entry always balances.
+	remaining := t.Amount
+	for _, s := range t.Splits {
+		e.Postings = append(e.Postings, posting{Account: l.counterPath(s.Category, s.Amount), Amount: -s.Amount})
+		remaining -= s.Amount
+	}
+
+	if remaining != 0 || len(t.Splits) == 0 {
+		e.Postings = append(e.Postings, posting{Account: l.counterPath(t.Category, remaining), Amount: -remaining})
+	}
+
+	return e
+}
+
+// usedAccounts lists every account path the entries post to, sorted, so
+// they can be declared before use.
+func usedAccounts(entries []entry, name func([]string) string) []string {
+	seen := map[string]bool{}
+	var results []string
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			n := name(p.Account)
+			if !seen[n] {
+				seen[n] = true
+				results = append(results, n)
+			}
+		}
+	}
+
+	sort.Strings(results)
+	return results
+}
+
+// WriteLedger writes the ledger in ledger-cli journal syntax.
+func (l *Ledger) WriteLedger(w io.Writer) error {
+	return l.writeJournal(w, func(tags []string) string {
+		return ":" + strings.Join(tags, ":") + ":"
+	})
+}
+
+// WriteHledger writes the ledger as an hledger journal. The syntax is the
+// same as ledger's apart from how tags are written.
+func (l *Ledger) WriteHledger(w io.Writer) error {
+	return l.writeJournal(w, func(tags []string) string {
+		return strings.Join(tags, ":, ") + ":"
+	})
+}
+
+func (l *Ledger) writeJournal(w io.Writer, formatTags func([]string) string) error {
+	out := bufio.NewWriter(w)
+	entries := l.entries()
+
+	fmt.Fprintf(out, "; Exported from sacmoney, %s to %s\n\n",
+		l.From.Format("2006-01-02"), l.To.AddDate(0, 0, -1).Format("2006-01-02"))
+
+	for _, name := range usedAccounts(entries, journalAccount) {
+		fmt.Fprintf(out, "account %s\n", name)
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(out, "\n%s *", e.Date.Format("2006-01-02"))
+		if len(e.CheckNumber) > 0 {
+			fmt.Fprintf(out, " (%s)", journalText(e.CheckNumber))
+		}
+		fmt.Fprintf(out, " %s\n", journalText(e.Payee))
+
+		if len(e.Memo) > 0 {
+			fmt.Fprintf(out, "    ; %s\n", journalText(e.Memo))
+		}
+
+		var tags []string
+		for _, tag := range e.Tags {
+			tags = append(tags, journalTag(tag))
+		}
+		if len(tags) > 0 {
+			fmt.Fprintf(out, "    ; %s\n", formatTags(tags))
+		}
+
+		for _, p := range e.Postings {
+			fmt.Fprintf(out, "    %s  %s\n", journalAccount(p.Account), journalAmount(p.Amount))
+		}
+	}
+
+	return out.Flush()
+}
+
+// journalAccount joins the path with colons. Two spaces or a tab would end
+// the account name early, and a semicolon would start a comment, so those
+// are squeezed out.
+func journalAccount(path []string) string {
+	var parts []string
+	for _, p := range path {
+		p = strings.NewReplacer(":", " ", ";", " ").Replace(p)
+		parts = append(parts, journalText(p))
+	}
+	return strings.Join(parts, ":")
+}
+
+func journalText(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func journalTag(tag string) string {
+	return strings.Join(strings.FieldsFunc(tag, func(r rune) bool {
+		return r == ' ' || r == ':' || r == ',' || r == '\t'
+	}), "-")
+}
+
+func journalAmount(cents utils.Money) string {
+	return "$" + cents.String()
+}
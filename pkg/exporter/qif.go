@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+const qifDate = "01/02/2006"
+
+// WriteQif writes each account in the ledger as its own register, starting
+// with an Opening Balance entry the way Quicken does, so the file can be
+// imported into another program without losing the carried balance.
+func (l *Ledger) WriteQif(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	for _, account := range l.Accounts {
+		qifType := "Bank"
+		if account.Kind == db.AccountLiability {
+			qifType = "CCard"
+		}
+
+		fmt.Fprintf(out, "!Account\nN%s\nT%s\n^\n", qifLine(account.Name), qifType)
+		fmt.Fprintf(out, "!Type:%s\n", qifType)
+
+		if account.TotalAvailable != 0 {
+			fmt.Fprintf(out, "D%s\nT%s\nPOpening Balance\nL[%s]\n^\n",
+				l.From.Format(qifDate),
+				account.TotalAvailable.String(),
+				qifLine(account.Name))
+		}
+
+		for _, t := range l.Transactions {
+			if t.AccountId != account.Id {
+				continue
+			}
+
+			writeQifTransaction(out, &t)
+		}
+	}
+
+	return out.Flush()
+}
+
+func writeQifTransaction(out *bufio.Writer, t *db.Transaction) {
+	fmt.Fprintf(out, "D%s\n", t.Date.Format(qifDate))
+	fmt.Fprintf(out, "T%s\n", t.Amount.String())
+	fmt.Fprintf(out, "P%s\n", qifLine(t.PayeeName()))
+
+	if len(t.Memo) > 0 {
+		fmt.Fprintf(out, "M%s\n", qifLine(t.Memo))
+	}
+
+	if len(t.CheckNumber) > 0 {
+		fmt.Fprintf(out, "N%s\n", qifLine(t.CheckNumber))
+	}
+
+	if len(t.Category) > 0 {
+		fmt.Fprintf(out, "L%s\n", qifLine(t.Category))
+	}
+
+	for _, s := range t.Splits {
+		fmt.Fprintf(out, "S%s\n", qifLine(s.Category))
+		if len(s.Memo) > 0 {
+			fmt.Fprintf(out, "E%s\n", qifLine(s.Memo))
+		}
+		fmt.Fprintf(out, "$%s\n", s.Amount.String())
+	}
+
+	fmt.Fprintf(out, "^\n")
+}
+
+// qifLine keeps a value on one line since QIF is line oriented.
+func qifLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
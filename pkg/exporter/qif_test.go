@@ -0,0 +1,73 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	importer "tjdickerson/sacmoney/pkg/importer"
+)
+
+func TestQifRoundTrip(t *testing.T) {
+	from := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	ledger := Ledger{
+		From:     from,
+		To:       from.AddDate(0, 1, 0),
+		Accounts: []db.Account{{Id: 1, Name: "Checking", Kind: db.AccountAsset, TotalAvailable: 150000}},
+		Transactions: []db.Transaction{
+			{
+				Id:          1,
+				AccountId:   1,
+				Name:        "Costco",
+				Amount:      -10000,
+				Date:        from.AddDate(0, 0, 4),
+				Memo:        "Monthly\nrun",
+				CheckNumber: "1042",
+				Category:    "Shopping",
+				Splits: []db.Split{
+					{Category: "Groceries", Memo: "Food", Amount: -6000},
+					{Category: "Household", Amount: -4000},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := ledger.WriteQif(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := importer.ParseQif(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Read back %d rows, want 2", len(rows))
+	}
+
+	opening := rows[0]
+	if opening.Name != "Opening Balance" || opening.Amount != 150000 || opening.Category != "[Checking]" || !opening.Date.Equal(from) {
+		t.Errorf("Opening balance read back as %+v", opening)
+	}
+
+	want := ledger.Transactions[0]
+	got := rows[1].Transaction(1)
+	if len(rows[1].Error) > 0 {
+		t.Errorf("The transaction read back with error %q", rows[1].Error)
+	}
+
+	// Memos are kept on one line since QIF is line oriented.
+	if got.Name != want.Name || got.Amount != want.Amount || !got.Date.Equal(want.Date) ||
+		got.Memo != "Monthly run" || got.CheckNumber != want.CheckNumber || got.Category != want.Category {
+		t.Errorf("Read back %+v, want %+v", got, want)
+	}
+
+	if len(got.Splits) != len(want.Splits) {
+		t.Fatalf("Read back %d splits, want %d", len(got.Splits), len(want.Splits))
+	}
+	for i, s := range want.Splits {
+		if got.Splits[i].Category != s.Category || got.Splits[i].Memo != s.Memo || got.Splits[i].Amount != s.Amount {
+			t.Errorf("Split %d read back as %+v, want %+v", i, got.Splits[i], s)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// DateFormats lists the layouts offered when setting up a mapping. Any Go
+// time layout is accepted, these are just the ones banks tend to use.
+var DateFormats = []string{
+	"2006-01-02",
+	"01/02/2006",
+	"1/2/2006",
+	"02/01/2006",
+	"2/1/2006",
+	"01/02/06",
+	"01-02-2006",
+	"20060102",
+	"Jan 2, 2006",
+	"02 Jan 2006",
+}
+
+func ValidateMapping(m *db.CsvMapping) error {
+	outErr := ""
+	if m.DateColumn < 1 {
+		outErr = outErr + "Date column required. "
+	}
+
+	if m.DescriptionColumn < 1 {
+		outErr = outErr + "Description column required. "
+	}
+
+	if m.AmountColumn < 1 && m.DebitColumn < 1 && m.CreditColumn < 1 {
+		outErr = outErr + "Amount column or debit/credit columns required. "
+	}
+
+	if m.AmountColumn > 0 && (m.DebitColumn > 0 || m.CreditColumn > 0) {
+		outErr = outErr + "Use either an amount column or debit/credit columns, not both. "
+	}
+
+	if len(m.DateFormat) == 0 {
+		outErr = outErr + "Date format required. "
+	}
+
+	if len([]rune(m.Delimiter)) > 1 {
+		outErr = outErr + "Delimiter must be a single character. "
+	}
+
+	if len(outErr) > 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(outErr))
+	}
+
+	return nil
+}
+
+// ParseCsv reads every record in r using the mapping. Records that can't be
+// understood are still returned, with Error set, so they can be shown in the
+// preview instead of silently dropped.
+func ParseCsv(r io.Reader, m db.CsvMapping) ([]Row, error) {
+	if err := ValidateMapping(&m); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if len(m.Delimiter) > 0 {
+		reader.Comma = []rune(m.Delimiter)[0]
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading csv: %s", err)
+	}
+
+	var rows []Row
+	for i, record := range records {
+		if i == 0 && m.HasHeader {
+			continue
+		}
+
+		if isBlank(record) {
+			continue
+		}
+
+		rows = append(rows, parseRecord(i+1, record, &m))
+	}
+
+	return rows, nil
+}
+
+func parseRecord(line int, record []string, m *db.CsvMapping) Row {
+	row := Row{Line: line}
+	var problems []string
+
+	date := column(record, m.DateColumn)
+	parsed, err := time.Parse(m.DateFormat, date)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("date %q doesn't match %s", date, m.DateFormat))
+	} else {
+		row.Date = parsed.UTC()
+	}
+
+	row.Name = strings.Join(strings.Fields(column(record, m.DescriptionColumn)), " ")
+	if len(row.Name) == 0 {
+		problems = append(problems, "missing description")
+	}
+
+	row.CheckNumber = column(record, m.CheckNumberColumn)
+
+	if m.AmountColumn > 0 {
+		amount, err := utils.ParseMoney(column(record, m.AmountColumn))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s", err))
+		}
+		if m.NegateAmounts {
+			amount = -amount
+		}
+		row.Amount = amount
+	} else {
+		// Some banks write 0.00 in the column a row doesn't use, so the
+		// amount is whichever of the two isn't zero.
+		debit, debitErr := optionalAmount(column(record, m.DebitColumn))
+		credit, creditErr := optionalAmount(column(record, m.CreditColumn))
+		for _, err := range []error{debitErr, creditErr} {
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s", err))
+			}
+		}
+
+		switch {
+		case debit == nil && credit == nil && debitErr == nil && creditErr == nil:
+			problems = append(problems, "missing debit or credit amount")
+		case debit != nil && credit != nil && *debit != 0 && *credit != 0:
+			problems = append(problems, "both a debit and a credit amount")
+		case debit != nil && *debit != 0:
+			row.Amount = -debit.Abs()
+		case credit != nil:
+			row.Amount = credit.Abs()
+		}
+	}
+
+	if len(problems) > 0 {
+		row.Error = fmt.Sprintf("Line %d: %s", line, strings.Join(problems, ", "))
+	}
+
+	return row
+}
+
+// optionalAmount parses a debit or credit cell, which is nil when it's empty.
+func optionalAmount(value string) (*utils.Money, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	amount, err := utils.ParseMoney(value)
+	if err != nil {
+		return nil, err
+	}
+	return &amount, nil
+}
+
+func column(record []string, number int) string {
+	if number < 1 || number > len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[number-1])
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if len(strings.TrimSpace(field)) > 0 {
+			return false
+		}
+	}
+	return true
+}
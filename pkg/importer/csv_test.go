@@ -0,0 +1,51 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+func TestParseCsvDebitCredit(t *testing.T) {
+	mapping := db.CsvMapping{
+		DateColumn:        1,
+		DescriptionColumn: 2,
+		DebitColumn:       3,
+		CreditColumn:      4,
+		DateFormat:        "2006-01-02",
+	}
+
+	tests := []struct {
+		record string
+		amount utils.Money
+		fails  bool
+	}{
+		{"2026-10-07,Safeway,45.10,", -4510, false},
+		{"2026-10-08,Deposit,,200.00", 20000, false},
+		{"2026-10-08,Deposit,0.00,200.00", 20000, false},
+		{"2026-10-09,Coffee,4.50,0.00", -450, false},
+		{"2026-10-09,Nothing,0.00,0.00", 0, false},
+		{"2026-10-10,Both,4.50,200.00", 0, true},
+		{"2026-10-10,Neither,,", 0, true},
+		{"2026-10-10,Garbled,x,", 0, true},
+	}
+
+	for _, test := range tests {
+		rows, err := ParseCsv(strings.NewReader(test.record), mapping)
+		if err != nil {
+			t.Fatalf("%s: %s", test.record, err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("%s: got %d rows", test.record, len(rows))
+		}
+
+		row := rows[0]
+		if failed := len(row.Error) > 0; failed != test.fails {
+			t.Errorf("%s: error %q, wanted failure %v", test.record, row.Error, test.fails)
+		}
+		if !test.fails && row.Amount != test.amount {
+			t.Errorf("%s: amount %s, want %s", test.record, row.Amount, test.amount)
+		}
+	}
+}
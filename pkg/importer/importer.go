@@ -0,0 +1,171 @@
+package importer
+
+import (
+	"fmt"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	duplicates "tjdickerson/sacmoney/pkg/duplicates"
+	rules "tjdickerson/sacmoney/pkg/rules"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+type Row struct {
+	Line        int
+	Date        time.Time
+	Name        string
+	Amount      utils.Money
+	Category    string
+	Memo        string
+	CheckNumber string
+	FitId       string
+	Payee       string
+	DisplayName string
+	Tags        string
+	Splits      []db.Split
+	Duplicate   string
+	Match       *duplicates.Candidate
+	Action      string
+	Error       string
+}
+
+func (r *Row) Transaction(accountId int) db.Transaction {
+	return db.Transaction{
+		AccountId:   accountId,
+		Name:        r.Name,
+		Amount:      r.Amount,
+		Date:        r.Date,
+		Category:    r.Category,
+		Memo:        r.Memo,
+		CheckNumber: r.CheckNumber,
+		FitId:       r.FitId,
+		Payee:       r.Payee,
+		DisplayName: r.DisplayName,
+		Tags:        r.Tags,
+		Splits:      r.Splits,
+	}
+}
+
+// MarkImported flags rows whose FITID was already imported into the account
+// so re-importing an overlapping statement doesn't double them up.
+func MarkImported(rows []Row, dir string, accountId int) error {
+	existing, err := db.FetchImportedFitIds(dir, accountId)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for i := range rows {
+		fitId := rows[i].FitId
+		if len(fitId) == 0 {
+			continue
+		}
+
+		if existing[fitId] || seen[fitId] {
+			rows[i].Duplicate = fmt.Sprintf("Already imported (FITID %s)", fitId)
+		}
+		seen[fitId] = true
+	}
+
+	return nil
+}
+
+// ApplyRules runs the saved rules over the rows so the preview shows the
+// cleaned up names and categories that will be imported.
+func ApplyRules(rows []Row, accountId int) error {
+	engine, err := rules.Load()
+	if err != nil {
+		return err
+	}
+
+	for i := range rows {
+		if len(rows[i].Error) > 0 {
+			continue
+		}
+
+		t := rows[i].Transaction(accountId)
+		if engine.Apply(&t) {
+			rows[i].Category = t.Category
+			rows[i].Payee = t.Payee
+			rows[i].DisplayName = t.DisplayName
+			rows[i].Tags = t.Tags
+		}
+	}
+
+	return nil
+}
+
+// MatchExisting looks for transactions already in the account that a row
+// likely duplicates, such as a purchase entered by hand before the statement
+// was downloaded. Matched rows are skipped on import unless their Action says
+// to keep or merge them.
+func MatchExisting(rows []Row, dir string, accountId int) error {
+	var first, last time.Time
+	for _, row := range rows {
+		if len(row.Error) > 0 || len(row.Duplicate) > 0 {
+			continue
+		}
+		if first.IsZero() || row.Date.Before(first) {
+			first = row.Date
+		}
+		if last.IsZero() || row.Date.After(last) {
+			last = row.Date
+		}
+	}
+
+	if first.IsZero() {
+		return nil
+	}
+
+	matcher, err := duplicates.Load(dir, accountId, first, last)
+	if err != nil {
+		return err
+	}
+
+	for i := range rows {
+		if len(rows[i].Error) > 0 || len(rows[i].Duplicate) > 0 {
+			continue
+		}
+		rows[i].Match = matcher.Best(rows[i].Transaction(accountId))
+	}
+
+	return nil
+}
+
+// Import adds every row without an error or duplicate flag to the account in
+// l through the regular transaction insert and returns how many were added
+// or merged.
+// Rows matched to an existing transaction follow their Action: merged rows
+// fill in the existing transaction, kept rows are added alongside it and
+// anything else is skipped. userId is recorded as the one who added them.
+func Import(l *db.Ledger, dir string, rows []Row, accountId int, userId int) (int, error) {
+	count := 0
+	for _, row := range rows {
+		if len(row.Error) > 0 || len(row.Duplicate) > 0 {
+			continue
+		}
+
+		transaction := row.Transaction(accountId)
+		transaction.CreatedBy = userId
+
+		if row.Match != nil {
+			switch row.Action {
+			case duplicates.ActionMerge:
+				if err := db.MergeTransaction(dir, row.Match.Existing, transaction, false); err != nil {
+					return count, fmt.Errorf("Error merging line %d: %s", row.Line, err)
+				}
+				count++
+				continue
+			case duplicates.ActionKeep:
+			default:
+				continue
+			}
+		}
+
+		if err := l.Insert(&transaction); err != nil {
+			return count, fmt.Errorf("Error importing line %d: %s", row.Line, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
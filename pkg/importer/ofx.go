@@ -0,0 +1,223 @@
+package importer
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+type Statement struct {
+	BankAccount      string
+	Currency         string
+	Rows             []Row
+	HasLedgerBalance bool
+	LedgerBalance    utils.Money
+	LedgerDate       time.Time
+}
+
+type ofxNode struct {
+	Name     string
+	Value    string
+	Children []*ofxNode
+}
+
+func (n *ofxNode) child(name string) *ofxNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *ofxNode) value(name string) string {
+	if c := n.child(name); c != nil {
+		return c.Value
+	}
+	return ""
+}
+
+func (n *ofxNode) findAll(name string, results []*ofxNode) []*ofxNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			results = append(results, c)
+		}
+		results = c.findAll(name, results)
+	}
+	return results
+}
+
+// IsOfx reports whether content looks like an OFX or QFX download rather
+// than a CSV file.
+func IsOfx(content string) bool {
+	return strings.Contains(strings.ToUpper(content), "<OFX>")
+}
+
+// ParseOfx reads the bank and credit card statements in an OFX 1.x (SGML)
+// or 2.x (XML) file. SGML leaves have no closing tags, so a tag followed by
+// text is always treated as a leaf and closing tags are matched against the
+// nearest open aggregate of the same name.
+func ParseOfx(r io.Reader) (Statement, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return Statement{}, fmt.Errorf("Error reading OFX file: %s", err)
+	}
+
+	root, err := parseOfxTree(string(content))
+	if err != nil {
+		return Statement{}, err
+	}
+
+	statement := Statement{}
+	for _, from := range root.findAll("BANKACCTFROM", root.findAll("CCACCTFROM", nil)) {
+		statement.BankAccount = from.value("ACCTID")
+	}
+
+	for _, rs := range root.findAll("STMTRS", root.findAll("CCSTMTRS", nil)) {
+		if len(rs.value("CURDEF")) > 0 {
+			statement.Currency = rs.value("CURDEF")
+		}
+	}
+
+	for i, trn := range root.findAll("STMTTRN", nil) {
+		statement.Rows = append(statement.Rows, parseStmtTrn(i+1, trn))
+	}
+
+	for _, bal := range root.findAll("LEDGERBAL", nil) {
+		amount, err := utils.ParseMoney(bal.value("BALAMT"))
+		if err != nil {
+			return statement, fmt.Errorf("Error reading ledger balance: %s", err)
+		}
+
+		statement.HasLedgerBalance = true
+		statement.LedgerBalance = amount
+		statement.LedgerDate, _ = parseOfxDate(bal.value("DTASOF"))
+	}
+
+	return statement, nil
+}
+
+func parseStmtTrn(line int, trn *ofxNode) Row {
+	row := Row{
+		Line:        line,
+		FitId:       trn.value("FITID"),
+		CheckNumber: trn.value("CHECKNUM"),
+	}
+	var problems []string
+
+	date, err := parseOfxDate(trn.value("DTPOSTED"))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s", err))
+	}
+	row.Date = date
+
+	amount, err := utils.ParseMoney(trn.value("TRNAMT"))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s", err))
+	}
+	row.Amount = amount
+
+	name := trn.value("NAME")
+	if payee := trn.child("PAYEE"); len(name) == 0 && payee != nil {
+		name = payee.value("NAME")
+	}
+	if len(name) == 0 {
+		name = trn.value("MEMO")
+	} else {
+		row.Memo = trn.value("MEMO")
+	}
+
+	row.Name = strings.Join(strings.Fields(name), " ")
+	if len(row.Name) == 0 {
+		problems = append(problems, "missing name")
+	}
+
+	if len(row.FitId) == 0 {
+		problems = append(problems, "missing FITID")
+	}
+
+	if len(problems) > 0 {
+		row.Error = fmt.Sprintf("Transaction %d: %s", line, strings.Join(problems, ", "))
+	}
+
+	return row
+}
+
+// parseOfxDate only keeps the calendar date, matching how transactions are
+// entered by hand.
+func parseOfxDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("Invalid OFX date %q", value)
+	}
+
+	t, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid OFX date %q", value)
+	}
+
+	return t, nil
+}
+
+func parseOfxTree(content string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(content), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("No <OFX> element found, is this an OFX or QFX file?")
+	}
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	rest := content[start:]
+
+	for len(rest) > 0 {
+		open := strings.Index(rest, "<")
+		if open < 0 {
+			break
+		}
+
+		end := strings.Index(rest[open:], ">")
+		if end < 0 {
+			return nil, fmt.Errorf("Unterminated tag in OFX file")
+		}
+
+		tag := strings.TrimSpace(rest[open+1 : open+end])
+		rest = rest[open+end+1:]
+
+		if len(tag) == 0 || tag[0] == '?' || tag[0] == '!' {
+			continue
+		}
+
+		if tag[0] == '/' {
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].Name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		selfClosing := strings.HasSuffix(tag, "/")
+		name := strings.ToUpper(strings.Fields(strings.TrimSuffix(tag, "/"))[0])
+
+		text := rest
+		if next := strings.Index(rest, "<"); next >= 0 {
+			text = rest[:next]
+		}
+		text = strings.TrimSpace(text)
+
+		node := &ofxNode{Name: name, Value: html.UnescapeString(text)}
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, node)
+
+		if len(text) == 0 && !selfClosing {
+			stack = append(stack, node)
+		}
+	}
+
+	return root, nil
+}
@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// sgmlStatement is an OFX 1.x download: a header, then SGML where leaf
+// elements such as <TRNAMT> are never closed. A1 is sent twice, the way some
+// banks repeat a pending transaction once it posts.
+const sgmlStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+SECURITY:NONE
+ENCODING:USASCII
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20261010120000[-5:EST]<LANGUAGE>ENG</SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>1<STMTRS><CURDEF>USD
+<BANKACCTFROM><BANKID>121000358<ACCTID>98765<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST><DTSTART>20261001<DTEND>20261010
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20261007120000.000[-5:EST]<TRNAMT>-45.10<FITID>A1<NAME>SAFEWAY  #123</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20261008<TRNAMT>200.00<FITID>A2<NAME>PAYROLL &amp; CO</STMTTRN>
+<STMTTRN><TRNTYPE>CHECK<DTPOSTED>20261009235959[-8:PST]<TRNAMT>(12.00)<FITID>A3<CHECKNUM>1042<MEMO>Check 1042</STMTTRN>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20261007120000.000[-5:EST]<TRNAMT>-45.10<FITID>A1<NAME>SAFEWAY  #123</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>1234.56<DTASOF>20261010120000[-5:EST]</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+// xmlStatement is an OFX 2.x credit card download, where every element is
+// closed.
+const xmlStatement = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1>
+    <CCSTMTTRNRS>
+      <CCSTMTRS>
+        <CURDEF>CAD</CURDEF>
+        <CCACCTFROM><ACCTID>4111</ACCTID></CCACCTFROM>
+        <BANKTRANLIST>
+          <STMTTRN>
+            <TRNTYPE>DEBIT</TRNTYPE>
+            <DTPOSTED>20261002000000[+1:CET]</DTPOSTED>
+            <TRNAMT>-4.50</TRNAMT>
+            <FITID>C1</FITID>
+            <PAYEE><NAME>Blue Bottle</NAME></PAYEE>
+          </STMTTRN>
+          <STMTTRN>
+            <TRNTYPE>CREDIT</TRNTYPE>
+            <DTPOSTED>20261003</DTPOSTED>
+            <TRNAMT>+20</TRNAMT>
+            <FITID/>
+            <NAME>Refund</NAME>
+          </STMTTRN>
+        </BANKTRANLIST>
+      </CCSTMTRS>
+    </CCSTMTTRNRS>
+  </CREDITCARDMSGSRSV1>
+</OFX>
+`
+
+type ofxRow struct {
+	date   string
+	name   string
+	amount utils.Money
+	fitId  string
+	check  string
+	fails  bool
+}
+
+func checkOfxRows(t *testing.T, rows []Row, want []ofxRow) {
+	t.Helper()
+	if len(rows) != len(want) {
+		t.Fatalf("Got %d rows, want %d", len(rows), len(want))
+	}
+
+	for i, w := range want {
+		row := rows[i]
+		if failed := len(row.Error) > 0; failed != w.fails {
+			t.Errorf("Row %d: error %q, wanted failure %v", i+1, row.Error, w.fails)
+		}
+
+		date, _ := time.Parse("2006-01-02", w.date)
+		if !row.Date.Equal(date) || row.Name != w.name || row.Amount != w.amount || row.FitId != w.fitId || row.CheckNumber != w.check {
+			t.Errorf("Row %d is %s %q %s %q %q, want %s %q %s %q %q", i+1,
+				row.Date.Format("2006-01-02"), row.Name, row.Amount, row.FitId, row.CheckNumber,
+				w.date, w.name, w.amount, w.fitId, w.check)
+		}
+	}
+}
+
+func TestParseOfxSgml(t *testing.T) {
+	if !IsOfx(sgmlStatement) || IsOfx("date,name,amount\n") {
+		t.Errorf("IsOfx can't tell OFX from CSV")
+	}
+
+	statement, err := ParseOfx(strings.NewReader(sgmlStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statement.BankAccount != "98765" || statement.Currency != "USD" {
+		t.Errorf("Statement is for account %q in %q", statement.BankAccount, statement.Currency)
+	}
+	if !statement.HasLedgerBalance || statement.LedgerBalance != 123456 || statement.LedgerDate.Format("2006-01-02") != "2026-10-10" {
+		t.Errorf("Ledger balance is %v %s on %s", statement.HasLedgerBalance, statement.LedgerBalance, statement.LedgerDate)
+	}
+
+	// The dates keep the day the bank wrote, whatever the zone after it.
+	checkOfxRows(t, statement.Rows, []ofxRow{
+		{"2026-10-07", "SAFEWAY #123", -4510, "A1", "", false},
+		{"2026-10-08", "PAYROLL & CO", 20000, "A2", "", false},
+		{"2026-10-09", "Check 1042", -1200, "A3", "1042", false},
+		{"2026-10-07", "SAFEWAY #123", -4510, "A1", "", false},
+	})
+}
+
+func TestParseOfxXml(t *testing.T) {
+	statement, err := ParseOfx(strings.NewReader(xmlStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statement.BankAccount != "4111" || statement.Currency != "CAD" || statement.HasLedgerBalance {
+		t.Errorf("Statement is for account %q in %q", statement.BankAccount, statement.Currency)
+	}
+
+	checkOfxRows(t, statement.Rows, []ofxRow{
+		{"2026-10-02", "Blue Bottle", -450, "C1", "", false},
+		{"2026-10-03", "Refund", 2000, "", "", true},
+	})
+}
+
+func TestParseOfxBadInput(t *testing.T) {
+	tests := []string{
+		"date,name,amount\n2026-10-07,Safeway,-45.10\n",
+		"<OFX><STMTTRN><TRNAMT>-45.10",
+		"<OFX><STMTTRN",
+	}
+
+	for _, content := range tests {
+		statement, err := ParseOfx(strings.NewReader(content))
+		if err == nil && len(statement.Rows) > 0 && len(statement.Rows[0].Error) == 0 {
+			t.Errorf("%q: parsed without an error", content)
+		}
+	}
+
+	statement, err := ParseOfx(strings.NewReader(strings.Replace(sgmlStatement, "<DTPOSTED>20261008", "<DTPOSTED>2026", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Rows[1].Error) == 0 {
+		t.Errorf("A short DTPOSTED parsed as %s", statement.Rows[1].Date)
+	}
+}
+
+func TestMarkImported(t *testing.T) {
+	dir := t.TempDir()
+	period := db.PeriodOf(time.Now())
+	if err := db.InitDatabase(filepath.Join(dir, period.FileName()), false); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseDatabase()
+
+	account := &db.Account{Name: "Checking"}
+	if err := db.Insert(account); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert(&db.Transaction{AccountId: account.Id, Name: "Payroll", Amount: 20000, Date: period.Start(), FitId: "A2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := ParseOfx(strings.NewReader(sgmlStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A2 is already in the account and the second A1 repeats the first.
+	if err = MarkImported(statement.Rows, dir, account.Id); err != nil {
+		t.Fatal(err)
+	}
+	for i, duplicate := range []bool{false, true, false, true} {
+		if got := len(statement.Rows[i].Duplicate) > 0; got != duplicate {
+			t.Errorf("Row %d (FITID %s) duplicate %q, want %v", i+1, statement.Rows[i].FitId, statement.Rows[i].Duplicate, duplicate)
+		}
+	}
+
+	// FITIDs only count within the account they were imported into.
+	statement, _ = ParseOfx(strings.NewReader(sgmlStatement))
+	if err = MarkImported(statement.Rows, dir, account.Id+1); err != nil {
+		t.Fatal(err)
+	}
+	for i, duplicate := range []bool{false, false, false, true} {
+		if got := len(statement.Rows[i].Duplicate) > 0; got != duplicate {
+			t.Errorf("Another account: row %d (FITID %s) duplicate %q, want %v", i+1, statement.Rows[i].FitId, statement.Rows[i].Duplicate, duplicate)
+		}
+	}
+}
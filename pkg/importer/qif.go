@@ -0,0 +1,172 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// qifDateFormats covers the date styles written by Quicken, MS Money and
+// GnuCash. Dates are read month first.
+var qifDateFormats = []string{
+	"1/2/2006",
+	"1/2'06",
+	"1/2'2006",
+	"1/2/06",
+	"1-2-2006",
+	"1.2.2006",
+	"2006-01-02",
+}
+
+// IsQif reports whether content looks like a QIF register rather than a CSV
+// file.
+func IsQif(content string) bool {
+	header := strings.TrimSpace(strings.TrimPrefix(content, "\uFEFF"))
+	return strings.HasPrefix(header, "!Type:") || strings.HasPrefix(header, "!Account") || strings.HasPrefix(header, "!Option")
+}
+
+// ParseQif reads the bank, cash and credit card registers in a QIF file.
+// Sections for other record types, such as investment accounts or category
+// lists, are skipped.
+func ParseQif(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	var current *Row
+	var split *db.Split
+	register := false
+	lineNumber := 0
+	start := 0
+	var problems []string
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+
+		if len(current.Name) == 0 {
+			current.Name = current.Memo
+		}
+		if len(current.Name) == 0 {
+			problems = append(problems, "missing payee")
+		}
+		if current.Date.IsZero() {
+			problems = append(problems, "missing date")
+		}
+
+		var total utils.Money
+		for _, s := range current.Splits {
+			total += s.Amount
+		}
+		if len(current.Splits) > 0 && total != current.Amount {
+			problems = append(problems, fmt.Sprintf("splits add up to %s, not %s", total, current.Amount))
+		}
+
+		if len(problems) > 0 {
+			current.Error = fmt.Sprintf("Line %d: %s", start, strings.Join(problems, ", "))
+		}
+
+		rows = append(rows, *current)
+		current = nil
+		split = nil
+		problems = nil
+	}
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			finish()
+			if strings.HasPrefix(line, "!Type:") {
+				kind := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "!Type:")))
+				register = kind == "bank" || kind == "cash" || kind == "ccard" || kind == "oth a" || kind == "oth l"
+			}
+			continue
+		}
+
+		if !register {
+			continue
+		}
+
+		if line == "^" {
+			finish()
+			continue
+		}
+
+		if current == nil {
+			current = &Row{Line: lineNumber}
+			start = lineNumber
+		}
+
+		code, value := line[0], strings.TrimSpace(line[1:])
+		switch code {
+		case 'D':
+			date, err := parseQifDate(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s", err))
+			}
+			current.Date = date
+		case 'T', 'U':
+			amount, err := utils.ParseMoney(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s", err))
+			}
+			current.Amount = amount
+		case 'P':
+			current.Name = strings.Join(strings.Fields(value), " ")
+		case 'M':
+			current.Memo = value
+		case 'N':
+			current.CheckNumber = value
+		case 'L':
+			current.Category = value
+		case 'S':
+			current.Splits = append(current.Splits, db.Split{Category: value})
+			split = &current.Splits[len(current.Splits)-1]
+		case 'E':
+			if split != nil {
+				split.Memo = value
+			}
+		case '$':
+			if split != nil {
+				amount, err := utils.ParseMoney(value)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s", err))
+				}
+				split.Amount = amount
+			}
+		}
+	}
+
+	finish()
+
+	if err := scanner.Err(); err != nil {
+		return rows, fmt.Errorf("Error reading QIF file: %s", err)
+	}
+
+	return rows, nil
+}
+
+func parseQifDate(value string) (time.Time, error) {
+	clean := strings.ReplaceAll(value, " ", "")
+	for _, layout := range qifDateFormats {
+		if t, err := time.Parse(layout, clean); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid QIF date %q", value)
+}
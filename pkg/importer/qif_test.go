@@ -0,0 +1,108 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQifDates(t *testing.T) {
+	tests := []struct {
+		value string
+		date  string
+		fails bool
+	}{
+		{"10/7/2026", "2026-10-07", false},
+		{"10/07/2026", "2026-10-07", false},
+		{"10/ 7'26", "2026-10-07", false},
+		{"1/5'26", "2026-01-05", false},
+		{"12/31'99", "1999-12-31", false},
+		{"10/7'2026", "2026-10-07", false},
+		{"10/7/26", "2026-10-07", false},
+		{"10-7-2026", "2026-10-07", false},
+		{"10.7.2026", "2026-10-07", false},
+		{"2026-10-07", "2026-10-07", false},
+		{"13/7/2026", "", true},
+		{"7 Oct 2026", "", true},
+		{"", "", true},
+	}
+
+	for _, test := range tests {
+		date, err := parseQifDate(test.value)
+		if failed := err != nil; failed != test.fails {
+			t.Errorf("%q: error %v, wanted failure %v", test.value, err, test.fails)
+		}
+		if !test.fails && date.Format("2006-01-02") != test.date {
+			t.Errorf("%q: date %s, want %s", test.value, date.Format("2006-01-02"), test.date)
+		}
+	}
+}
+
+const qifRegister = `!Type:Cat
+NGroceries
+^
+!Type:Bank
+D10/ 1'26
+T-100.00
+PCostco
+MMonthly run
+N1042
+SGroceries
+EFood
+$-60.00
+SHousehold
+$-40.00
+^
+D10/2'26
+T-50.00
+PHardware store
+SHome
+$-30.00
+SGarden
+$-15.00
+^
+D10/3/2026
+T1,200.00
+PPayroll
+LSalary
+^
+!Type:Invst
+D10/4/2026
+T-1.00
+PIgnored
+^
+`
+
+func TestParseQif(t *testing.T) {
+	if !IsQif(qifRegister) || IsQif("date,name,amount\n") {
+		t.Errorf("IsQif can't tell QIF from CSV")
+	}
+
+	rows, err := ParseQif(strings.NewReader(qifRegister))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Got %d rows, want 3", len(rows))
+	}
+
+	costco := rows[0]
+	if len(costco.Error) > 0 || costco.Name != "Costco" || costco.Memo != "Monthly run" || costco.CheckNumber != "1042" || costco.Amount != -10000 {
+		t.Errorf("Row 1 is %+v", costco)
+	}
+	if len(costco.Splits) != 2 ||
+		costco.Splits[0].Category != "Groceries" || costco.Splits[0].Memo != "Food" || costco.Splits[0].Amount != -6000 ||
+		costco.Splits[1].Category != "Household" || costco.Splits[1].Memo != "" || costco.Splits[1].Amount != -4000 {
+		t.Errorf("Row 1 splits are %+v", costco.Splits)
+	}
+
+	// The splits leave 5.00 of the 50.00 unaccounted for.
+	if hardware := rows[1]; !strings.Contains(hardware.Error, "splits add up to -45.00, not -50.00") {
+		t.Errorf("Row 2 error is %q", hardware.Error)
+	}
+
+	payroll := rows[2]
+	if len(payroll.Error) > 0 || payroll.Category != "Salary" || payroll.Amount != 120000 || payroll.Date != time.Date(2026, time.October, 3, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("Row 3 is %+v", payroll)
+	}
+}
@@ -0,0 +1,357 @@
+package reports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+const Uncategorized = "Uncategorized"
+
+type MonthSummary struct {
+	Year    int
+	Month   time.Month
+	Income  utils.Money
+	Expense utils.Money
+	Net     utils.Money
+	Count   int
+}
+
+type Breakdown struct {
+	Label   string
+	Total   utils.Money
+	Count   int
+	Average utils.Money
+}
+
+type Report struct {
+	From                  time.Time
+	To                    time.Time
+	Months                []MonthSummary
+	ByCategory            []Breakdown
+	ByPayee               []Breakdown
+	TopExpenses           []db.Transaction
+	TopIncome             []db.Transaction
+	TotalIncome           utils.Money
+	TotalExpense          utils.Money
+	Net                   utils.Money
+	AverageMonthlyIncome  utils.Money
+	AverageMonthlyExpense utils.Money
+	AverageExpense        utils.Money
+}
+
+// Build collects every transaction in [from, to) across all period files in
+// dir, for the accounts in the set. from and to are truncated to whole days.
+// top limits how many of the largest transactions are kept.
+func Build(dir string, from time.Time, to time.Time, top int, accounts db.AccountSet) (Report, error) {
+	from = truncateDay(from)
+	to = truncateDay(to)
+	if !to.After(from) {
+		return Report{}, fmt.Errorf("Report end date must be after the start date.")
+	}
+
+	transactions, err := db.FetchTransactionsBetween(dir, from, to, accounts)
+	if err != nil {
+		return Report{}, fmt.Errorf("Error building report: %s", err)
+	}
+
+	return summarize(from, to, transactions, top), nil
+}
+
+func summarize(from time.Time, to time.Time, transactions []db.Transaction, top int) Report {
+	r := Report{From: from, To: to}
+
+	months := map[db.Period]*MonthSummary{}
+	for p := db.PeriodOf(from); p.Start().Before(to); p = p.Next() {
+		r.Months = append(r.Months, MonthSummary{Year: p.Year, Month: p.Month})
+	}
+	for i := range r.Months {
+		months[db.Period{Year: r.Months[i].Year, Month: r.Months[i].Month}] = &r.Months[i]
+	}
+
+	categories := map[string]*Breakdown{}
+	payees := map[string]*Breakdown{}
+	var expenses, income []db.Transaction
+	expenseCount := 0
+
+	for _, t := range transactions {
+		m := months[db.PeriodOf(t.Date)]
+		if m == nil {
+			continue
+		}
+
+		m.Count++
+		if t.Amount < 0 {
+			m.Expense += -t.Amount
+			r.TotalExpense += -t.Amount
+			expenses = append(expenses, t)
+			expenseCount++
+
+			if len(t.Splits) > 0 {
+				for _, split := range t.Splits {
+					addTo(categories, categoryOf(split.Category), -split.Amount)
+				}
+			} else {
+				addTo(categories, categoryOf(t.Category), -t.Amount)
+			}
+			addTo(payees, payeeOf(t), -t.Amount)
+		} else {
+			m.Income += t.Amount
+			r.TotalIncome += t.Amount
+			income = append(income, t)
+		}
+		m.Net = m.Income - m.Expense
+	}
+
+	r.Net = r.TotalIncome - r.TotalExpense
+	if len(r.Months) > 0 {
+		r.AverageMonthlyIncome = r.TotalIncome.Div(len(r.Months))
+		r.AverageMonthlyExpense = r.TotalExpense.Div(len(r.Months))
+	}
+	if expenseCount > 0 {
+		r.AverageExpense = r.TotalExpense.Div(expenseCount)
+	}
+
+	r.ByCategory = sortedBreakdowns(categories)
+	r.ByPayee = sortedBreakdowns(payees)
+
+	sort.SliceStable(expenses, func(i, j int) bool {
+		return expenses[i].Amount < expenses[j].Amount
+	})
+	sort.SliceStable(income, func(i, j int) bool {
+		return income[i].Amount > income[j].Amount
+	})
+	r.TopExpenses = limit(expenses, top)
+	r.TopIncome = limit(income, top)
+
+	return r
+}
+
+func categoryOf(category string) string {
+	if len(strings.TrimSpace(category)) == 0 {
+		return Uncategorized
+	}
+	return category
+}
+
+func payeeOf(t db.Transaction) string {
+	return strings.TrimSpace(t.PayeeName())
+}
+
+func addTo(m map[string]*Breakdown, label string, amount utils.Money) {
+	key := strings.ToLower(label)
+	b, ok := m[key]
+	if !ok {
+		b = &Breakdown{Label: label}
+		m[key] = b
+	}
+
+	b.Total += amount
+	b.Count++
+}
+
+func sortedBreakdowns(m map[string]*Breakdown) []Breakdown {
+	results := make([]Breakdown, 0, len(m))
+	for _, b := range m {
+		b.Average = b.Total.Div(b.Count)
+		results = append(results, *b)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Total == results[j].Total {
+			return results[i].Label < results[j].Label
+		}
+		return results[i].Total > results[j].Total
+	})
+
+	return results
+}
+
+func limit(transactions []db.Transaction, top int) []db.Transaction {
+	if top >= 0 && len(transactions) > top {
+		return transactions[:top]
+	}
+	return transactions
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+type jsonTransaction struct {
+	Id       int    `json:"id"`
+	Date     string `json:"date"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Amount   string `json:"amount"`
+}
+
+type jsonMonth struct {
+	Month   string `json:"month"`
+	Income  string `json:"income"`
+	Expense string `json:"expense"`
+	Net     string `json:"net"`
+	Count   int    `json:"count"`
+}
+
+type jsonBreakdown struct {
+	Label   string `json:"label"`
+	Total   string `json:"total"`
+	Count   int    `json:"count"`
+	Average string `json:"average"`
+}
+
+type jsonReport struct {
+	From                  string            `json:"from"`
+	To                    string            `json:"to"`
+	TotalIncome           string            `json:"totalIncome"`
+	TotalExpense          string            `json:"totalExpense"`
+	Net                   string            `json:"net"`
+	AverageMonthlyIncome  string            `json:"averageMonthlyIncome"`
+	AverageMonthlyExpense string            `json:"averageMonthlyExpense"`
+	AverageExpense        string            `json:"averageExpense"`
+	Months                []jsonMonth       `json:"months"`
+	ByCategory            []jsonBreakdown   `json:"byCategory"`
+	ByPayee               []jsonBreakdown   `json:"byPayee"`
+	TopExpenses           []jsonTransaction `json:"topExpenses"`
+	TopIncome             []jsonTransaction `json:"topIncome"`
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	out := jsonReport{
+		From:                  r.From.Format("2006-01-02"),
+		To:                    r.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		TotalIncome:           r.TotalIncome.String(),
+		TotalExpense:          r.TotalExpense.String(),
+		Net:                   r.Net.String(),
+		AverageMonthlyIncome:  r.AverageMonthlyIncome.String(),
+		AverageMonthlyExpense: r.AverageMonthlyExpense.String(),
+		AverageExpense:        r.AverageExpense.String(),
+		Months:                []jsonMonth{},
+		ByCategory:            toJsonBreakdowns(r.ByCategory),
+		ByPayee:               toJsonBreakdowns(r.ByPayee),
+		TopExpenses:           toJsonTransactions(r.TopExpenses),
+		TopIncome:             toJsonTransactions(r.TopIncome),
+	}
+
+	for _, m := range r.Months {
+		out.Months = append(out.Months, jsonMonth{
+			Month:   fmt.Sprintf("%d-%02d", m.Year, int(m.Month)),
+			Income:  m.Income.String(),
+			Expense: m.Expense.String(),
+			Net:     m.Net.String(),
+			Count:   m.Count,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func toJsonBreakdowns(breakdowns []Breakdown) []jsonBreakdown {
+	results := []jsonBreakdown{}
+	for _, b := range breakdowns {
+		results = append(results, jsonBreakdown{
+			Label:   b.Label,
+			Total:   b.Total.String(),
+			Count:   b.Count,
+			Average: b.Average.String(),
+		})
+	}
+	return results
+}
+
+func toJsonTransactions(transactions []db.Transaction) []jsonTransaction {
+	results := []jsonTransaction{}
+	for _, t := range transactions {
+		results = append(results, jsonTransaction{
+			Id:       t.Id,
+			Date:     t.Date.Format("2006-01-02"),
+			Name:     t.Display(),
+			Category: t.Category,
+			Amount:   t.Amount.String(),
+		})
+	}
+	return results
+}
+
+// WriteCSV writes the report as a single CSV table. The first column names
+// the section each row belongs to so the output can be filtered in a
+// spreadsheet.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{
+		{"section", "label", "income", "expense", "net", "count", "average"},
+		{"total", fmt.Sprintf("%s to %s", r.From.Format("2006-01-02"), r.To.AddDate(0, 0, -1).Format("2006-01-02")),
+			r.TotalIncome.String(), r.TotalExpense.String(), r.Net.String(), "", r.AverageExpense.String()},
+		{"monthly average", "", r.AverageMonthlyIncome.String(), r.AverageMonthlyExpense.String(),
+			(r.AverageMonthlyIncome - r.AverageMonthlyExpense).String(), "", ""},
+	}
+
+	for _, m := range r.Months {
+		rows = append(rows, []string{"month", fmt.Sprintf("%d-%02d", m.Year, int(m.Month)),
+			m.Income.String(), m.Expense.String(), m.Net.String(), strconv.Itoa(m.Count), ""})
+	}
+
+	for _, b := range r.ByCategory {
+		rows = append(rows, []string{"category", b.Label, "", b.Total.String(), "",
+			strconv.Itoa(b.Count), b.Average.String()})
+	}
+
+	for _, b := range r.ByPayee {
+		rows = append(rows, []string{"payee", b.Label, "", b.Total.String(), "",
+			strconv.Itoa(b.Count), b.Average.String()})
+	}
+
+	for _, t := range r.TopExpenses {
+		rows = append(rows, []string{"top expense", fmt.Sprintf("%s %s", t.Date.Format("2006-01-02"), t.Display()),
+			"", (-t.Amount).String(), "", "", ""})
+	}
+
+	for _, t := range r.TopIncome {
+		rows = append(rows, []string{"top income", fmt.Sprintf("%s %s", t.Date.Format("2006-01-02"), t.Display()),
+			t.Amount.String(), "", "", "", ""})
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return fmt.Errorf("Error writing report csv: %s", err)
+	}
+
+	return nil
+}
+
+// ParseRange reads an inclusive yyyy-mm-dd date range as typed by a user and
+// returns it as the half open range Build expects. Missing dates default to
+// the twelve months ending with the current one.
+func ParseRange(from string, to string) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	end := db.PeriodOf(now).End()
+	start := end.AddDate(-1, 0, 0)
+
+	if len(strings.TrimSpace(to)) > 0 {
+		t, err := time.Parse("2006-01-02", strings.TrimSpace(to))
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid end date %s, expected yyyy-mm-dd.", to)
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+
+	if len(strings.TrimSpace(from)) > 0 {
+		t, err := time.Parse("2006-01-02", strings.TrimSpace(from))
+		if err != nil {
+			return start, end, fmt.Errorf("Invalid start date %s, expected yyyy-mm-dd.", from)
+		}
+		start = t
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,133 @@
+package reports
+
+import (
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// sampleTransactions span January to March 2026 with nothing in February,
+// and one transaction either side of the range.
+func sampleTransactions() []db.Transaction {
+	return []db.Transaction{
+		{Id: 1, Date: day(2025, time.December, 31), Name: "Gift", Category: "Gifts", Amount: -99999},
+		{Id: 2, Date: day(2026, time.January, 1), Name: "Paycheck", Category: "Salary", Amount: 300000},
+		{Id: 3, Date: day(2026, time.January, 3), Name: "Safeway", Category: "Groceries", Amount: -4510},
+		{Id: 4, Date: day(2026, time.January, 17), Name: "Safeway ", Category: "groceries", Amount: -2490},
+		{Id: 5, Date: day(2026, time.January, 20), Name: "Rent", Category: "Housing", Amount: -120000},
+		{Id: 6, Date: day(2026, time.January, 31), Name: "Coffee", Amount: -450},
+		{Id: 7, Date: day(2026, time.March, 1), Name: "Paycheck", Category: "Salary", Amount: 300000},
+		{Id: 8, Date: day(2026, time.March, 20), Name: "Rent", Category: "Housing", Amount: -120000},
+		{Id: 9, Date: day(2026, time.April, 1), Name: "Gift", Category: "Gifts", Amount: -88888},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	r := summarize(day(2026, time.January, 1), day(2026, time.April, 1), sampleTransactions(), 2)
+
+	if r.TotalIncome != 600000 || r.TotalExpense != 247450 || r.Net != 352550 {
+		t.Errorf("Totals are income %d expense %d net %d", r.TotalIncome, r.TotalExpense, r.Net)
+	}
+
+	// February counts towards the averages even though nothing happened in it.
+	if r.AverageMonthlyIncome != 200000 || r.AverageMonthlyExpense != 82483 {
+		t.Errorf("Monthly averages are income %d expense %d", r.AverageMonthlyIncome, r.AverageMonthlyExpense)
+	}
+	if r.AverageExpense != 49490 {
+		t.Errorf("Average expense is %d, want 49490", r.AverageExpense)
+	}
+
+	months := []MonthSummary{
+		{Year: 2026, Month: time.January, Income: 300000, Expense: 127450, Net: 172550, Count: 5},
+		{Year: 2026, Month: time.February},
+		{Year: 2026, Month: time.March, Income: 300000, Expense: 120000, Net: 180000, Count: 2},
+	}
+	if len(r.Months) != len(months) {
+		t.Fatalf("Got %d months, want %d", len(r.Months), len(months))
+	}
+	for i, want := range months {
+		if r.Months[i] != want {
+			t.Errorf("Month %d is %+v, want %+v", i, r.Months[i], want)
+		}
+	}
+
+	checkBreakdowns(t, "category", r.ByCategory, []Breakdown{
+		{Label: "Housing", Total: 240000, Count: 2, Average: 120000},
+		{Label: "Groceries", Total: 7000, Count: 2, Average: 3500},
+		{Label: Uncategorized, Total: 450, Count: 1, Average: 450},
+	})
+	checkBreakdowns(t, "payee", r.ByPayee, []Breakdown{
+		{Label: "Rent", Total: 240000, Count: 2, Average: 120000},
+		{Label: "Safeway", Total: 7000, Count: 2, Average: 3500},
+		{Label: "Coffee", Total: 450, Count: 1, Average: 450},
+	})
+
+	checkIds(t, "top expenses", r.TopExpenses, 5, 8)
+	checkIds(t, "top income", r.TopIncome, 2, 7)
+}
+
+func TestSummarizeNothing(t *testing.T) {
+	r := summarize(day(2026, time.May, 1), day(2026, time.June, 1), sampleTransactions(), 5)
+
+	if r.TotalIncome != 0 || r.TotalExpense != 0 || r.AverageExpense != 0 || len(r.ByCategory) != 0 || len(r.TopExpenses) != 0 {
+		t.Errorf("A month with nothing in it summarized as %+v", r)
+	}
+	if len(r.Months) != 1 || r.Months[0].Count != 0 {
+		t.Errorf("Months are %+v", r.Months)
+	}
+}
+
+func checkBreakdowns(t *testing.T, kind string, got []Breakdown, want []Breakdown) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("By %s is %+v, want %+v", kind, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("By %s %d is %+v, want %+v", kind, i, got[i], want[i])
+		}
+	}
+}
+
+func checkIds(t *testing.T, kind string, got []db.Transaction, ids ...int) {
+	t.Helper()
+	if len(got) != len(ids) {
+		t.Fatalf("Got %d %s, want %d", len(got), kind, len(ids))
+	}
+	for i, id := range ids {
+		if got[i].Id != id {
+			t.Errorf("%s %d is transaction %d, want %d", kind, i, got[i].Id, id)
+		}
+	}
+}
+
+func TestSummarizeSplits(t *testing.T) {
+	transactions := []db.Transaction{
+		{Id: 1, Date: day(2026, time.October, 4), Name: "Costco", Category: "Shopping", Amount: -10000, Splits: []db.Split{
+			{Category: "Groceries", Amount: -6000},
+			{Category: "Household", Amount: -3500},
+			{Amount: -500},
+		}},
+		{Id: 2, Date: day(2026, time.October, 5), Name: "Safeway", Category: "groceries", Amount: -500},
+	}
+
+	r := summarize(day(2026, time.October, 1), day(2026, time.November, 1), transactions, 5)
+
+	// Each split counts towards its own category, not the transaction's.
+	checkBreakdowns(t, "category", r.ByCategory, []Breakdown{
+		{Label: "Groceries", Total: 6500, Count: 2, Average: 3250},
+		{Label: "Household", Total: 3500, Count: 1, Average: 3500},
+		{Label: Uncategorized, Total: 500, Count: 1, Average: 500},
+	})
+	checkBreakdowns(t, "payee", r.ByPayee, []Breakdown{
+		{Label: "Costco", Total: 10000, Count: 1, Average: 10000},
+		{Label: "Safeway", Total: 500, Count: 1, Average: 500},
+	})
+	if r.TotalExpense != 10500 || r.Months[0].Count != 2 {
+		t.Errorf("Total expense %d over %d transactions", r.TotalExpense, r.Months[0].Count)
+	}
+}
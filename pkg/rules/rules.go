@@ -0,0 +1,190 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+type compiled struct {
+	rule    db.Rule
+	pattern *regexp.Regexp
+}
+
+type Engine struct {
+	rules []compiled
+}
+
+// Validate checks that a rule can be saved: it needs a pattern, a valid
+// regular expression when IsRegex is set, a sensible amount range and at
+// least one action.
+func Validate(r *db.Rule) error {
+	var problems []string
+
+	if len(strings.TrimSpace(r.Pattern)) == 0 {
+		problems = append(problems, "a name pattern is required")
+	} else if r.IsRegex {
+		if _, err := regexp.Compile("(?i)" + r.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid regular expression: %s", err))
+		}
+	}
+
+	if r.MinAmount < 0 || r.MaxAmount < 0 {
+		problems = append(problems, "amounts are compared without their sign, so use positive bounds")
+	}
+	if r.MaxAmount > 0 && r.MinAmount > r.MaxAmount {
+		problems = append(problems, "the minimum amount is larger than the maximum")
+	}
+
+	if len(r.Category) == 0 && len(r.Tags) == 0 && len(r.Payee) == 0 && len(r.DisplayName) == 0 {
+		problems = append(problems, "set at least one of category, tags, payee or display name")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Rule %s: %s.", r.Name, strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// New compiles the enabled rules, in order. Rules that no longer compile
+// are an error rather than silently skipped.
+func New(rules []db.Rule) (*Engine, error) {
+	e := &Engine{}
+	for _, r := range rules {
+		if !r.Enabled {
+			continue
+		}
+
+		c := compiled{rule: r}
+		if r.IsRegex {
+			pattern, err := regexp.Compile("(?i)" + r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Rule %s has an invalid regular expression: %s", r.Name, err)
+			}
+			c.pattern = pattern
+		}
+
+		e.rules = append(e.rules, c)
+	}
+
+	return e, nil
+}
+
+// Load builds an engine from the rules saved in the store.
+func Load() (*Engine, error) {
+	rules, err := db.FetchAllRules()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(rules)
+}
+
+func (c *compiled) matches(t *db.Transaction) bool {
+	if c.rule.AccountId != 0 && c.rule.AccountId != t.AccountId {
+		return false
+	}
+
+	amount := t.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	if c.rule.MinAmount > 0 && amount < c.rule.MinAmount {
+		return false
+	}
+	if c.rule.MaxAmount > 0 && amount > c.rule.MaxAmount {
+		return false
+	}
+
+	if c.pattern != nil {
+		return c.pattern.MatchString(t.Name)
+	}
+
+	return strings.Contains(strings.ToLower(t.Name), strings.ToLower(strings.TrimSpace(c.rule.Pattern)))
+}
+
+// Apply runs every matching rule over t and reports whether anything
+// changed. Rules are matched against the original name, so renaming a
+// transaction never stops a later rule from seeing it.
+func (e *Engine) Apply(t *db.Transaction) bool {
+	changed := false
+	for i := range e.rules {
+		c := &e.rules[i]
+		if !c.matches(t) {
+			continue
+		}
+
+		if len(c.rule.Category) > 0 && c.rule.Category != t.Category {
+			t.Category = c.rule.Category
+			t.CategoryId = 0
+			changed = true
+		}
+		if len(c.rule.Tags) > 0 {
+			if tags, added := mergeTags(t.Tags, c.rule.Tags); added {
+				t.Tags = tags
+				changed = true
+			}
+		}
+		if len(c.rule.Payee) > 0 && c.rule.Payee != t.Payee {
+			t.Payee = c.rule.Payee
+			changed = true
+		}
+		if len(c.rule.DisplayName) > 0 && c.rule.DisplayName != t.DisplayName {
+			t.DisplayName = c.rule.DisplayName
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// mergeTags adds the comma separated tags in add to those in existing,
+// skipping ones already present, and reports whether any were new. Tags that
+// are only spaced differently aren't a change, so existing is left as it is.
+func mergeTags(existing string, add string) (string, bool) {
+	var tags []string
+	seen := map[string]bool{}
+	added := false
+	for i, list := range []string{existing, add} {
+		for _, tag := range strings.Split(list, ",") {
+			tag = strings.TrimSpace(tag)
+			if len(tag) == 0 || seen[strings.ToLower(tag)] {
+				continue
+			}
+			seen[strings.ToLower(tag)] = true
+			tags = append(tags, tag)
+			added = added || i == 1
+		}
+	}
+
+	if !added {
+		return existing, false
+	}
+	return strings.Join(tags, ", "), true
+}
+
+// ApplyHistory re-runs the rules over transactions already saved between
+// from and to, in every period, for the accounts in the set, and returns the
+// ones that changed. Nothing is written when dryRun is set.
+func (e *Engine) ApplyHistory(dir string, accounts db.AccountSet, from time.Time, to time.Time, dryRun bool) ([]db.Transaction, error) {
+	transactions, err := db.FetchTransactionsBetween(dir, from, to, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []db.Transaction
+	for _, t := range transactions {
+		if e.Apply(&t) {
+			changed = append(changed, t)
+		}
+	}
+
+	if dryRun {
+		return changed, nil
+	}
+
+	return changed, db.ApplyRuleFields(dir, changed)
+}
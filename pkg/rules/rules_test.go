@@ -0,0 +1,26 @@
+package rules
+
+import "testing"
+
+func TestMergeTags(t *testing.T) {
+	tests := []struct {
+		existing string
+		add      string
+		want     string
+		added    bool
+	}{
+		{"", "food", "food", true},
+		{"a,b", "b", "a,b", false},
+		{"a,b", "A, b", "a,b", false},
+		{"a ,b", "", "a ,b", false},
+		{"a,b", "c", "a, b, c", true},
+		{"a", "b, a, c", "a, b, c", true},
+	}
+
+	for _, test := range tests {
+		got, added := mergeTags(test.existing, test.add)
+		if got != test.want || added != test.added {
+			t.Errorf("mergeTags(%q, %q) = %q, %v, want %q, %v", test.existing, test.add, got, added, test.want, test.added)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// Each user only sees the accounts they have a role on. The pages work on
+// one account at a time: the one in the page's ?account=, so two tabs can
+// show different accounts, or else the one last picked on the accounts page
+// in this login, or else the user's first account. Rules, backups,
+// snapshots, rollover and users affect everyone, so only admins manage them.
+
+var (
+	// selectedAccounts is the account picked in each login, by the hash of
+	// its session token.
+	selectedLock     sync.Mutex
+	selectedAccounts = map[string]int{}
+)
+
+// userRoles is the logged-in user's role on each account.
+func userRoles(r *http.Request) (db.AccountRoles, error) {
+	user := currentUser(r)
+	if user == nil {
+		return db.AccountRoles{}, nil
+	}
+
+	return db.FetchUserRoles(user.Id)
+}
+
+// accountsWith is the set of accounts the user has at least min on. It is
+// empty when their roles can't be read, so an error never shows more.
+func accountsWith(r *http.Request, min db.Role) db.AccountSet {
+	roles, err := userRoles(r)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return db.AccountSet{}
+	}
+
+	return roles.With(min)
+}
+
+func userId(r *http.Request) int {
+	if user := currentUser(r); user != nil {
+		return user.Id
+	}
+	return 0
+}
+
+func isAdmin(r *http.Request) bool {
+	user := currentUser(r)
+	return user != nil && user.IsAdmin
+}
+
+// sessionKey identifies the login that made the request.
+func sessionKey(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return hashToken(cookie.Value)
+}
+
+func selectAccount(r *http.Request, accountId int) {
+	selectedLock.Lock()
+	defer selectedLock.Unlock()
+	selectedAccounts[sessionKey(r)] = accountId
+}
+
+// forgetSelection drops what a login picked when it ends.
+func forgetSelection(r *http.Request) {
+	selectedLock.Lock()
+	defer selectedLock.Unlock()
+	delete(selectedAccounts, sessionKey(r))
+}
+
+// userAccount is the account the pages show the user, with its balance, and
+// their role on it. It is nil when they can't see any account.
+func userAccount(r *http.Request) (*db.Account, db.Role, error) {
+	roles, err := userRoles(r)
+	if err != nil {
+		return nil, db.RoleNone, err
+	}
+
+	id := viewAccountId(r)
+	if id == 0 {
+		selectedLock.Lock()
+		id = selectedAccounts[sessionKey(r)]
+		selectedLock.Unlock()
+	}
+
+	if !roles.Can(id, db.RoleViewer) {
+		ids := roles.AccountIds()
+		if len(ids) == 0 {
+			return nil, db.RoleNone, nil
+		}
+		id = ids[0]
+	}
+
+	account, err := ledger(r).GetAccount(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, db.RoleNone, nil
+	}
+	if err != nil {
+		return nil, db.RoleNone, err
+	}
+
+	return &account, roles[id], nil
+}
+
+// errNoPermission is returned when the user's role doesn't allow a change.
+var errNoPermission = errors.New("You don't have permission to do that.")
+
+// forbid answers a page's post when the user's role on the account doesn't
+// allow what they asked for.
+func forbid(w http.ResponseWriter) {
+	http.Error(w, errNoPermission.Error(), http.StatusForbidden)
+}
+
+// usernames maps user ids to names for showing who changed what. Changes
+// made from the command line have no user and show no name.
+func usernames() map[int]string {
+	names := map[int]string{}
+	users, err := db.FetchAllUsers()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+	for _, u := range users {
+		names[u.Id] = u.Username
+	}
+	return names
+}
+
+// requireAdmin answers with a 403 and returns false unless an admin made
+// the request.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if isAdmin(r) {
+		return true
+	}
+
+	http.Error(w, "Only an admin can do that.", http.StatusForbidden)
+	return false
+}
+
+// claimAccounts gives admins ownership of any account without an owner.
+func claimAccounts() {
+	accounts, err := db.FetchAllAccounts()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return
+	}
+
+	var ids []int
+	for _, a := range accounts {
+		ids = append(ids, a.Id)
+	}
+
+	if err = db.ClaimUnownedAccounts(ids); err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+}
@@ -1,11 +1,8 @@
 package server
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"html"
-	"html/template"
 	"io"
 	"log"
 	"net/http"
@@ -17,11 +14,25 @@ import (
 type AccountData struct {
 	Id   string
 	Name string
+	Kind string
+	Role string
+}
+
+// MemberData is someone with access to an account.
+type MemberData struct {
+	UserId   string
+	Username string
+	Role     string
 }
 
 type AccountMain struct {
 	CurrentAccount string
+	CurrentId      string
+	IsOwner        bool
+	Members        []MemberData
+	Roles          []string
 	Accounts       []AccountData
+	Message        string
 	Error          string
 }
 
@@ -29,68 +40,154 @@ func convertAccount(a *db.Account) AccountData {
 	return AccountData{
 		Id:   strconv.Itoa(a.Id),
 		Name: a.Name,
+		Kind: a.Kind,
 	}
 }
 
 func (r *AccountData) toDbAccount() (db.Account, error) {
 	name := html.EscapeString(strings.TrimSpace(r.Name))
+	kind := strings.ToLower(strings.TrimSpace(r.Kind))
+	if len(kind) == 0 {
+		kind = db.AccountAsset
+	}
 
 	outErr := ""
 	if len(name) == 0 {
 		outErr = outErr + "Name required.\n"
 	}
 
+	if !db.ValidAccountKind(kind) {
+		outErr = outErr + "Kind must be asset or liability.\n"
+	}
+
 	if len(outErr) > 0 {
 		return db.Account{}, fmt.Errorf("%s", outErr)
 	}
 
 	return db.Account{
 		Name: name,
+		Kind: kind,
 	}, nil
 }
 
 func AccountMainHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles(
-		"templates/accounts/accounts_main_tmpl.html",
-		"templates/core/title_tmpl.html")
+	data := AccountMain{}
+	for _, role := range db.Roles {
+		data.Roles = append(data.Roles, string(role))
+	}
 
+	if r.Method == http.MethodPost {
+		handleAccountAction(r, &data)
+	}
+
+	roles, err := userRoles(r)
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error parsing template: %s", err))
+		data.Error = fmt.Sprintf("%s", err)
+		log.Println(data.Error)
 	}
 
-	outError := ""
-	accounts, err := db.FetchAllAccounts()
+	accounts, err := ledger(r).FetchAccounts(roles.With(db.RoleViewer))
 	if err != nil {
-		outError = fmt.Sprintf("%s", err)
-		log.Println(outError)
+		data.Error = fmt.Sprintf("%s", err)
+		log.Println(data.Error)
 	}
 
-	accountData := []AccountData{}
 	for _, dbAccount := range accounts {
-		accountData = append(accountData, convertAccount(&dbAccount))
+		account := convertAccount(&dbAccount)
+		account.Role = string(roles[dbAccount.Id])
+		data.Accounts = append(data.Accounts, account)
+	}
+
+	current, role, err := userAccount(r)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
 	}
 
-	var currentAccountName string
-	if servctx.currentAccount == nil {
-		currentAccountName = "Create new account."
+	if current == nil {
+		data.CurrentAccount = "Create new account."
 	} else {
-		currentAccountName = servctx.currentAccount.Name
+		data.CurrentAccount = current.Name
+		data.CurrentId = strconv.Itoa(current.Id)
+		data.IsOwner = role.AtLeast(db.RoleOwner)
 	}
 
-	data := AccountMain{
-		CurrentAccount: currentAccountName,
-		Accounts:       accountData,
-		Error:          outError,
+	if data.IsOwner {
+		members, err := db.FetchAccountRoles(current.Id)
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			log.Println(data.Error)
+		}
+		for _, m := range members {
+			data.Members = append(data.Members, MemberData{
+				UserId:   strconv.Itoa(m.UserId),
+				Username: m.Username,
+				Role:     string(m.Role),
+			})
+		}
 	}
 
-	var outHtml bytes.Buffer
-	t.Execute(&outHtml, data)
-	io.WriteString(w, outHtml.String())
+	renderPage(w, r, "accounts/accounts_main_tmpl.html", data)
+}
+
+// handleAccountAction picks the account the pages show, or, for the
+// account's owners, shares it with someone or stops sharing it.
+func handleAccountAction(r *http.Request, data *AccountMain) {
+	roles, err := userRoles(r)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		return
+	}
+
+	accountId, _ := strconv.Atoi(r.FormValue("account"))
+	action := r.FormValue("action")
+
+	if action == "select" {
+		if !roles.Can(accountId, db.RoleViewer) {
+			data.Error = "No such account."
+			return
+		}
+		selectAccount(r, accountId)
+		return
+	}
+
+	if !roles.Can(accountId, db.RoleOwner) {
+		data.Error = "Only the account's owners can share it."
+		return
+	}
+
+	switch action {
+	case "share":
+		username := strings.TrimSpace(r.FormValue("username"))
+		user, err := db.GetUserByName(username)
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+		if user == nil {
+			data.Error = fmt.Sprintf("There's no user called %s.", username)
+			return
+		}
+
+		if err = db.SetAccountRole(accountId, user.Id, db.Role(r.FormValue("role"))); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+
+		data.Message = fmt.Sprintf("%s is now %s.", user.Username, r.FormValue("role"))
+	case "unshare":
+		memberId, _ := strconv.Atoi(r.FormValue("user"))
+		if err = db.RemoveAccountRole(accountId, memberId); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+
+		data.Message = "Removed access."
+	}
 }
 
 func AddAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var data AccountData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodePost(w, r, &data)
 	if err != nil {
 		outErr := fmt.Sprintf("Failed to decode recurring transaction: %s", err)
 		log.Printf("Error: %s\n", outErr)
@@ -106,7 +203,7 @@ func AddAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.Insert(&recurring)
+	err = ledger(r).Insert(&recurring)
 	if err != nil {
 		outErr := fmt.Sprintf("Failed to add recurring transaction: %s", err)
 		log.Printf("Error: %s\n", outErr)
@@ -114,9 +211,12 @@ func AddAccountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if servctx.currentAccount == nil {
-		RefreshAccount()
+	// Whoever adds an account owns it and is switched to it.
+	if err = db.SetAccountRole(recurring.Id, userId(r), db.RoleOwner); err != nil {
+		log.Printf("Error: %s\n", err)
 	}
+	selectAccount(r, recurring.Id)
 
+	publish(r, EventAccountCreated, recurring.Id, recurring.Id)
 	io.WriteString(w, "SUCCESS")
 }
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// The JSON API lives under ApiPrefix. Every response is JSON: resources and
+// {"items": [...]} collections on success, and an ApiErrorBody with a
+// matching 4xx or 5xx status otherwise. Amounts are whole cents and dates
+// are yyyy-mm-dd.
+//
+// Every resource carries an ETag. A PUT must send the ETag it last read in
+// If-Match, and is refused with 412 if the resource changed since; DELETE
+// checks If-Match when it is sent. GET honours If-None-Match.
+const ApiPrefix = "/api/v1"
+
+// apiMaxBody is the largest request body the API reads.
+const apiMaxBody = 1 << 20
+
+type ApiError struct {
+	Status  int               `json:"status"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type ApiErrorBody struct {
+	Error ApiError `json:"error"`
+}
+
+type apiCollection struct {
+	Items any `json:"items"`
+}
+
+// apiRoute is one path of the API and the handler for each method it allows.
+type apiRoute struct {
+	Path    string
+	Methods map[string]http.HandlerFunc
+}
+
+var apiRoutes = []apiRoute{
+	{"/accounts", map[string]http.HandlerFunc{
+		http.MethodGet:  apiListAccounts,
+		http.MethodPost: apiCreateAccount,
+	}},
+	{"/accounts/{id}", map[string]http.HandlerFunc{
+		http.MethodGet:    apiGetAccount,
+		http.MethodPut:    apiUpdateAccount,
+		http.MethodDelete: apiDeleteAccount,
+	}},
+	{"/transactions", map[string]http.HandlerFunc{
+		http.MethodGet:  apiListTransactions,
+		http.MethodPost: apiCreateTransaction,
+	}},
+	{"/transactions/{id}", map[string]http.HandlerFunc{
+		http.MethodGet:    apiGetTransaction,
+		http.MethodPut:    apiUpdateTransaction,
+		http.MethodDelete: apiDeleteTransaction,
+	}},
+	{"/recurrings", map[string]http.HandlerFunc{
+		http.MethodGet:  apiListRecurrings,
+		http.MethodPost: apiCreateRecurring,
+	}},
+	{"/recurrings/{id}", map[string]http.HandlerFunc{
+		http.MethodGet:    apiGetRecurring,
+		http.MethodPut:    apiUpdateRecurring,
+		http.MethodDelete: apiDeleteRecurring,
+	}},
+	{"/categories", map[string]http.HandlerFunc{
+		http.MethodGet:  apiListCategories,
+		http.MethodPost: apiCreateCategory,
+	}},
+	{"/categories/{id}", map[string]http.HandlerFunc{
+		http.MethodGet:    apiGetCategory,
+		http.MethodPut:    apiUpdateCategory,
+		http.MethodDelete: apiDeleteCategory,
+	}},
+	{"/periods", map[string]http.HandlerFunc{
+		http.MethodGet: apiListPeriods,
+	}},
+	{"/periods/{period}", map[string]http.HandlerFunc{
+		http.MethodGet: apiGetPeriod,
+	}},
+	{"/periods/{period}/transactions", map[string]http.HandlerFunc{
+		http.MethodGet: apiListPeriodTransactions,
+	}},
+}
+
+// registerApi adds every API route to the mux, plus a catch-all so
+// unknown API paths get a JSON 404 rather than the transactions page.
+func registerApi() {
+	for _, route := range apiRoutes {
+		handleFunc(ApiPrefix+route.Path, apiDispatch(route))
+	}
+
+	handleFunc(ApiPrefix+"/openapi.json", ApiDocumentHandler)
+	handleFunc(ApiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeApiError(w, http.StatusNotFound, "not_found", fmt.Sprintf("No API endpoint at %s.", r.URL.Path))
+	})
+}
+
+func apiDispatch(route apiRoute) http.HandlerFunc {
+	var allowed []string
+	for method := range route.Methods {
+		allowed = append(allowed, method)
+	}
+	if _, ok := route.Methods[http.MethodGet]; ok {
+		allowed = append(allowed, http.MethodHead)
+	}
+	sort.Strings(allowed)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+
+		handler, ok := route.Methods[method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeApiError(w, http.StatusMethodNotAllowed, "method_not_allowed",
+				fmt.Sprintf("%s is not allowed on %s%s.", r.Method, ApiPrefix, route.Path))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeApiError(w http.ResponseWriter, status int, code string, message string) {
+	writeApiErrorFields(w, status, code, message, nil)
+}
+
+func writeApiErrorFields(w http.ResponseWriter, status int, code string, message string, fields map[string]string) {
+	body, _ := json.Marshal(ApiErrorBody{Error: ApiError{Status: status, Code: code, Message: message, Fields: fields}})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+// apiInternalError logs what went wrong and tells the client only that it
+// did, since the error may describe the server's files.
+func apiInternalError(w http.ResponseWriter, err error) {
+	log.Printf("Error: %s\n", err)
+	writeApiError(w, http.StatusInternalServerError, "internal_error", "Something went wrong on the server.")
+}
+
+func apiNotFound(w http.ResponseWriter, what string, id string) {
+	writeApiError(w, http.StatusNotFound, "not_found", fmt.Sprintf("No %s with id %s.", what, id))
+}
+
+func apiInvalid(w http.ResponseWriter, fields map[string]string) {
+	writeApiErrorFields(w, http.StatusUnprocessableEntity, "invalid", "The request has invalid fields.", fields)
+}
+
+// etagOf is a strong ETag for the JSON form of a resource, so it changes
+// whenever anything a client can see changes.
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+func resourceEtag(v any) string {
+	body, _ := json.Marshal(v)
+	return etagOf(body)
+}
+
+// writeApiJSON sends v with its ETag, or 304 when a GET already has it.
+func writeApiJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	etag := etagOf(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagListed(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+func writeApiCreated(w http.ResponseWriter, r *http.Request, location string, v any) {
+	w.Header().Set("Location", ApiPrefix+location)
+	writeApiJSON(w, r, http.StatusCreated, v)
+}
+
+func etagListed(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch compares If-Match with the resource as it is now. A PUT
+// without If-Match is refused with 428 so an update can never silently
+// overwrite someone else's change. The PUT and DELETE handlers that call it
+// have the ledger to themselves, so nothing changes between the check and
+// their write.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current any) bool {
+	header := r.Header.Get("If-Match")
+	if len(header) == 0 {
+		if r.Method == http.MethodPut {
+			writeApiError(w, http.StatusPreconditionRequired, "precondition_required",
+				"Send the ETag from your last read in If-Match.")
+			return false
+		}
+		return true
+	}
+
+	if !etagListed(header, resourceEtag(current)) {
+		writeApiError(w, http.StatusPreconditionFailed, "precondition_failed",
+			"The resource changed since you read it. Fetch it again and retry.")
+		return false
+	}
+
+	return true
+}
+
+// readApiBody decodes a JSON body into v. Unknown fields are refused so a
+// misspelled field isn't silently dropped.
+func readApiBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if contentType := r.Header.Get("Content-Type"); len(contentType) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			writeApiError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Send the body as application/json.")
+			return false
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeApiError(w, http.StatusRequestEntityTooLarge, "too_large",
+				fmt.Sprintf("The body is larger than %d bytes.", apiMaxBody))
+			return false
+		}
+
+		if err == io.EOF {
+			writeApiError(w, http.StatusBadRequest, "bad_request", "A JSON body is required.")
+			return false
+		}
+
+		writeApiError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("Error reading JSON body: %s", err))
+		return false
+	}
+
+	return true
+}
+
+// apiId reads a numeric {id} from the path, answering 404 when it isn't one.
+func apiId(w http.ResponseWriter, r *http.Request, what string) (int, bool) {
+	value := r.PathValue("id")
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		apiNotFound(w, what, value)
+		return 0, false
+	}
+	return id, true
+}
+
+// apiAccountFilter reads the optional ?account= filter; 0 means every account.
+func apiAccountFilter(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("account")
+	if len(value) == 0 {
+		return 0, true
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		writeApiError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("Invalid account id %s.", value))
+		return 0, false
+	}
+	return id, true
+}
+
+func parseApiDate(value string) (time.Time, error) {
+	return time.Parse("2006-01-02", value)
+}
+
+// apiRoles is the logged-in user's role on each account.
+func apiRoles(w http.ResponseWriter, r *http.Request) (db.AccountRoles, bool) {
+	roles, err := userRoles(r)
+	if err != nil {
+		apiInternalError(w, err)
+		return nil, false
+	}
+	return roles, true
+}
+
+// apiAllowed checks the user has at least min on the account something
+// belongs to. It answers 404 when they can't see the account at all, so ids
+// don't give away other people's accounts, and 403 when they can see it but
+// not change it.
+func apiAllowed(w http.ResponseWriter, roles db.AccountRoles, accountId int, min db.Role, what string, id string) bool {
+	if !roles.Can(accountId, db.RoleViewer) {
+		apiNotFound(w, what, id)
+		return false
+	}
+
+	if !roles.Can(accountId, min) {
+		writeApiError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("Your role on account %d doesn't allow that.", accountId))
+		return false
+	}
+
+	return true
+}
+
+// apiDefaultAccount is the account a new entry goes to when the body doesn't
+// name one: the account the pages show the user.
+func apiDefaultAccount(r *http.Request, accountId int) int {
+	if accountId != 0 {
+		return accountId
+	}
+	if account, _, err := userAccount(r); err == nil && account != nil {
+		return account.Id
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// AccountResource is an account as the API sends it. Balance and role, the
+// caller's own role on the account, are read-only.
+type AccountResource struct {
+	Id      int         `json:"id"`
+	Name    string      `json:"name"`
+	Kind    string      `json:"kind"`
+	Balance utils.Money `json:"balance"`
+	Role    string      `json:"role"`
+}
+
+func accountResource(a *db.Account, role db.Role) AccountResource {
+	return AccountResource{Id: a.Id, Name: a.Name, Kind: a.Kind, Balance: a.TotalAvailable, Role: string(role)}
+}
+
+func (a *AccountResource) validate() map[string]string {
+	fields := map[string]string{}
+	a.Name = strings.TrimSpace(a.Name)
+	if len(a.Name) == 0 {
+		fields["name"] = "A name is required."
+	}
+
+	if len(a.Kind) == 0 {
+		a.Kind = db.AccountAsset
+	}
+	if !db.ValidAccountKind(a.Kind) {
+		fields["kind"] = fmt.Sprintf("Kind must be %s or %s.", db.AccountAsset, db.AccountLiability)
+	}
+
+	return fields
+}
+
+// loadApiAccount reads the {id} account, answering 404 when there isn't one
+// the user can see and 403 when their role on it is below min.
+func loadApiAccount(w http.ResponseWriter, r *http.Request, min db.Role) (*db.Account, db.Role, bool) {
+	id, ok := apiId(w, r, "account")
+	if !ok {
+		return nil, db.RoleNone, false
+	}
+
+	roles, ok := apiRoles(w, r)
+	if !ok || !apiAllowed(w, roles, id, min, "account", r.PathValue("id")) {
+		return nil, db.RoleNone, false
+	}
+
+	account, err := ledger(r).FindAccount(id)
+	if err != nil {
+		apiInternalError(w, err)
+		return nil, db.RoleNone, false
+	}
+	if account == nil {
+		apiNotFound(w, "account", r.PathValue("id"))
+		return nil, db.RoleNone, false
+	}
+
+	return account, roles[id], true
+}
+
+func apiListAccounts(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	accounts, err := ledger(r).FetchAccounts(roles.With(db.RoleViewer))
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	items := []AccountResource{}
+	for _, a := range accounts {
+		// The list query doesn't total balances.
+		full, err := ledger(r).GetAccount(a.Id)
+		if err != nil {
+			apiInternalError(w, err)
+			return
+		}
+		items = append(items, accountResource(&full, roles[a.Id]))
+	}
+
+	writeApiJSON(w, r, http.StatusOK, apiCollection{Items: items})
+}
+
+func apiGetAccount(w http.ResponseWriter, r *http.Request) {
+	account, role, ok := loadApiAccount(w, r, db.RoleViewer)
+	if !ok {
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, accountResource(account, role))
+}
+
+func apiCreateAccount(w http.ResponseWriter, r *http.Request) {
+	var data AccountResource
+	if !readApiBody(w, r, &data) {
+		return
+	}
+
+	if fields := data.validate(); len(fields) > 0 {
+		apiInvalid(w, fields)
+		return
+	}
+
+	account := db.Account{Name: data.Name, Kind: data.Kind}
+	if err := ledger(r).Insert(&account); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	if err := db.SetAccountRole(account.Id, userId(r), db.RoleOwner); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	saved, err := ledger(r).GetAccount(account.Id)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventAccountCreated, saved.Id, saved.Id)
+	writeApiCreated(w, r, fmt.Sprintf("/accounts/%d", saved.Id), accountResource(&saved, db.RoleOwner))
+}
+
+func apiUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	account, role, ok := loadApiAccount(w, r, db.RoleOwner)
+	if !ok || !checkIfMatch(w, r, accountResource(account, role)) {
+		return
+	}
+
+	var data AccountResource
+	if !readApiBody(w, r, &data) {
+		return
+	}
+
+	if fields := data.validate(); len(fields) > 0 {
+		apiInvalid(w, fields)
+		return
+	}
+
+	account.Name = data.Name
+	account.Kind = data.Kind
+	if err := ledger(r).Update(account); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventAccountUpdated, account.Id, account.Id)
+	writeApiJSON(w, r, http.StatusOK, accountResource(account, role))
+}
+
+func apiDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	account, role, ok := loadApiAccount(w, r, db.RoleOwner)
+	if !ok || !checkIfMatch(w, r, accountResource(account, role)) {
+		return
+	}
+
+	err := ledger(r).Delete(account)
+	if errors.Is(err, db.ErrAccountInUse) {
+		writeApiError(w, http.StatusConflict, "conflict", fmt.Sprintf("%s", err))
+		return
+	}
+	if err == nil {
+		err = db.DeleteAccountRoles(account.Id)
+	}
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventAccountDeleted, account.Id, account.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
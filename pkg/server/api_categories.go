@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+type CategoryResource struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func categoryResource(c *db.Category) CategoryResource {
+	return CategoryResource{Id: c.Id, Name: c.Name}
+}
+
+// validateCategory checks the name is given and not taken by another
+// category, ignoring case the way transactions look categories up.
+func validateCategory(w http.ResponseWriter, r *http.Request, data *CategoryResource, id int) bool {
+	data.Name = strings.TrimSpace(data.Name)
+	if len(data.Name) == 0 {
+		apiInvalid(w, map[string]string{"name": "A name is required."})
+		return false
+	}
+
+	categories, err := ledger(r).FetchAllCategories()
+	if err != nil {
+		apiInternalError(w, err)
+		return false
+	}
+
+	for _, c := range categories {
+		if c.Id != id && strings.EqualFold(c.Name, data.Name) {
+			writeApiError(w, http.StatusConflict, "conflict", fmt.Sprintf("There's already a category named %s.", c.Name))
+			return false
+		}
+	}
+
+	return true
+}
+
+func loadApiCategory(w http.ResponseWriter, r *http.Request) (*db.Category, bool) {
+	id, ok := apiId(w, r, "category")
+	if !ok {
+		return nil, false
+	}
+
+	category, err := ledger(r).GetCategory(id)
+	if err != nil {
+		apiInternalError(w, err)
+		return nil, false
+	}
+	if category == nil {
+		apiNotFound(w, "category", r.PathValue("id"))
+		return nil, false
+	}
+
+	return category, true
+}
+
+func apiListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := ledger(r).FetchAllCategories()
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	items := []CategoryResource{}
+	for _, c := range categories {
+		items = append(items, categoryResource(&c))
+	}
+
+	writeApiJSON(w, r, http.StatusOK, apiCollection{Items: items})
+}
+
+func apiGetCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := loadApiCategory(w, r)
+	if !ok {
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, categoryResource(category))
+}
+
+func apiCreateCategory(w http.ResponseWriter, r *http.Request) {
+	var data CategoryResource
+	if !readApiBody(w, r, &data) || !validateCategory(w, r, &data, 0) {
+		return
+	}
+
+	category := db.Category{Name: data.Name}
+	if err := ledger(r).Insert(&category); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	writeApiCreated(w, r, fmt.Sprintf("/categories/%d", category.Id), categoryResource(&category))
+}
+
+// apiAdminOnly answers 403 unless an admin made the request. Categories are
+// shared by every account, so anyone can add one but only admins rename or
+// remove them.
+func apiAdminOnly(w http.ResponseWriter, r *http.Request) bool {
+	if isAdmin(r) {
+		return true
+	}
+
+	writeApiError(w, http.StatusForbidden, "forbidden", "Only an admin can do that.")
+	return false
+}
+
+func apiUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	if !apiAdminOnly(w, r) {
+		return
+	}
+
+	category, ok := loadApiCategory(w, r)
+	if !ok || !checkIfMatch(w, r, categoryResource(category)) {
+		return
+	}
+
+	var data CategoryResource
+	if !readApiBody(w, r, &data) || !validateCategory(w, r, &data, category.Id) {
+		return
+	}
+
+	category.Name = data.Name
+	if err := ledger(r).Update(category); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, categoryResource(category))
+}
+
+// apiDeleteCategory removes the category and clears it from the
+// transactions that used it.
+func apiDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	if !apiAdminOnly(w, r) {
+		return
+	}
+
+	category, ok := loadApiCategory(w, r)
+	if !ok || !checkIfMatch(w, r, categoryResource(category)) {
+		return
+	}
+
+	if err := ledger(r).Delete(category); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// PeriodResource is one month's ledger file. Id is yyyy-mm. Periods are
+// read-only; a new one is only started by rolling over.
+type PeriodResource struct {
+	Id      string `json:"id"`
+	Year    int    `json:"year"`
+	Month   int    `json:"month"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Current bool   `json:"current"`
+}
+
+func periodId(p db.Period) string {
+	if p.Year == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04d-%02d", p.Year, int(p.Month))
+}
+
+func periodResource(p db.Period) PeriodResource {
+	return PeriodResource{
+		Id:      periodId(p),
+		Year:    p.Year,
+		Month:   int(p.Month),
+		Start:   p.Start().Format("2006-01-02"),
+		End:     p.End().AddDate(0, 0, -1).Format("2006-01-02"),
+		Current: p == currentPeriod(),
+	}
+}
+
+// loadApiPeriod finds the {period} among the period files.
+func loadApiPeriod(w http.ResponseWriter, r *http.Request) (db.Period, bool) {
+	value := r.PathValue("period")
+	t, err := time.Parse("2006-01", value)
+	if err != nil {
+		apiNotFound(w, "period", value)
+		return db.Period{}, false
+	}
+
+	periods, err := db.ListPeriods(config.DataDir)
+	if err != nil {
+		apiInternalError(w, err)
+		return db.Period{}, false
+	}
+
+	wanted := db.PeriodOf(t)
+	for _, p := range periods {
+		if p == wanted {
+			return p, true
+		}
+	}
+
+	apiNotFound(w, "period", value)
+	return db.Period{}, false
+}
+
+func apiListPeriods(w http.ResponseWriter, r *http.Request) {
+	periods, err := db.ListPeriods(config.DataDir)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	items := []PeriodResource{}
+	for _, p := range periods {
+		items = append(items, periodResource(p))
+	}
+
+	writeApiJSON(w, r, http.StatusOK, apiCollection{Items: items})
+}
+
+func apiGetPeriod(w http.ResponseWriter, r *http.Request) {
+	p, ok := loadApiPeriod(w, r)
+	if !ok {
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, periodResource(p))
+}
+
+// apiListPeriodTransactions lists everything stored in one period,
+// including the starting balances carried in by rollover.
+func apiListPeriodTransactions(w http.ResponseWriter, r *http.Request) {
+	accountId, ok := apiAccountFilter(w, r)
+	if !ok {
+		return
+	}
+
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	p, ok := loadApiPeriod(w, r)
+	if !ok {
+		return
+	}
+
+	transactions, err := db.FetchPeriodTransactions(config.DataDir, p)
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	accounts := roles.With(db.RoleViewer).Only(accountId)
+	writeApiJSON(w, r, http.StatusOK, apiCollection{Items: transactionResources(transactions, accounts)})
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+type RecurringResource struct {
+	Id        int         `json:"id"`
+	AccountId int         `json:"accountId"`
+	Name      string      `json:"name"`
+	Amount    utils.Money `json:"amount"`
+	Day       int         `json:"day"`
+}
+
+func recurringResource(r *db.Recurring) RecurringResource {
+	return RecurringResource{Id: r.Id, AccountId: r.AccountId, Name: r.Name, Amount: r.Amount, Day: int(r.Day)}
+}
+
+// toDbRecurring checks the resource and copies it onto rec. The account has
+// to be one the user can see; whether they may write to it is checked
+// afterwards.
+func (data *RecurringResource) toDbRecurring(rec *db.Recurring, r *http.Request, roles db.AccountRoles) map[string]string {
+	fields := map[string]string{}
+
+	rec.Name = strings.TrimSpace(data.Name)
+	if len(rec.Name) == 0 {
+		fields["name"] = "A name is required."
+	}
+
+	if data.Day < 1 || data.Day > 28 {
+		fields["day"] = "Day needs to be between 1-28 inclusive."
+	}
+	rec.Day = uint8(data.Day)
+
+	rec.AccountId = apiDefaultAccount(r, data.AccountId)
+	if account, err := ledger(r).FindAccount(rec.AccountId); err != nil || account == nil || !roles.Can(rec.AccountId, db.RoleViewer) {
+		fields["accountId"] = fmt.Sprintf("No account with id %d.", rec.AccountId)
+	}
+
+	rec.Amount = data.Amount
+	return fields
+}
+
+// loadApiRecurring reads the {id} recurring transaction, checking the user
+// has at least min on its account.
+func loadApiRecurring(w http.ResponseWriter, r *http.Request, roles db.AccountRoles, min db.Role) (*db.Recurring, bool) {
+	id, ok := apiId(w, r, "recurring transaction")
+	if !ok {
+		return nil, false
+	}
+
+	recurring, err := ledger(r).GetRecurring(id)
+	if err != nil {
+		apiInternalError(w, err)
+		return nil, false
+	}
+	if recurring == nil {
+		apiNotFound(w, "recurring transaction", r.PathValue("id"))
+		return nil, false
+	}
+	if !apiAllowed(w, roles, recurring.AccountId, min, "recurring transaction", r.PathValue("id")) {
+		return nil, false
+	}
+
+	return recurring, true
+}
+
+// apiListRecurrings lists the recurring transactions of ?account=, or of
+// every account the user can see.
+func apiListRecurrings(w http.ResponseWriter, r *http.Request) {
+	accountId, ok := apiAccountFilter(w, r)
+	if !ok {
+		return
+	}
+
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	accounts, err := ledger(r).FetchAccounts(roles.With(db.RoleViewer).Only(accountId))
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	items := []RecurringResource{}
+	for _, a := range accounts {
+		recurrings, err := ledger(r).FetchRecurringsFor(a.Id)
+		if err != nil {
+			apiInternalError(w, err)
+			return
+		}
+		for _, recurring := range recurrings {
+			items = append(items, recurringResource(&recurring))
+		}
+	}
+
+	writeApiJSON(w, r, http.StatusOK, apiCollection{Items: items})
+}
+
+func apiGetRecurring(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	recurring, ok := loadApiRecurring(w, r, roles, db.RoleViewer)
+	if !ok {
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, recurringResource(recurring))
+}
+
+func apiCreateRecurring(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	var data RecurringResource
+	if !readApiBody(w, r, &data) {
+		return
+	}
+
+	var recurring db.Recurring
+	if fields := data.toDbRecurring(&recurring, r, roles); len(fields) > 0 {
+		apiInvalid(w, fields)
+		return
+	}
+	if !apiAllowed(w, roles, recurring.AccountId, db.RoleEditor, "account", fmt.Sprint(recurring.AccountId)) {
+		return
+	}
+
+	if err := ledger(r).Insert(&recurring); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventRecurringCreated, recurring.AccountId, recurring.Id)
+	writeApiCreated(w, r, fmt.Sprintf("/recurrings/%d", recurring.Id), recurringResource(&recurring))
+}
+
+func apiUpdateRecurring(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	recurring, ok := loadApiRecurring(w, r, roles, db.RoleEditor)
+	if !ok || !checkIfMatch(w, r, recurringResource(recurring)) {
+		return
+	}
+
+	var data RecurringResource
+	if !readApiBody(w, r, &data) {
+		return
+	}
+
+	moved := recurring.AccountId
+	if fields := data.toDbRecurring(recurring, r, roles); len(fields) > 0 {
+		apiInvalid(w, fields)
+		return
+	}
+	if !apiAllowed(w, roles, recurring.AccountId, db.RoleEditor, "account", fmt.Sprint(recurring.AccountId)) {
+		return
+	}
+
+	if err := ledger(r).Update(recurring); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventRecurringUpdated, recurring.AccountId, recurring.Id)
+	if moved != recurring.AccountId {
+		publish(r, EventRecurringDeleted, moved, recurring.Id)
+	}
+	writeApiJSON(w, r, http.StatusOK, recurringResource(recurring))
+}
+
+func apiDeleteRecurring(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	recurring, ok := loadApiRecurring(w, r, roles, db.RoleEditor)
+	if !ok || !checkIfMatch(w, r, recurringResource(recurring)) {
+		return
+	}
+
+	if err := ledger(r).Delete(recurring); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventRecurringDeleted, recurring.AccountId, recurring.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
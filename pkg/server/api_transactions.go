@@ -0,0 +1,357 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	rules "tjdickerson/sacmoney/pkg/rules"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// TransactionResource is a transaction as the API sends it. Id, period,
+// fitId, createdBy and editedBy are read-only; they're accepted in a body so
+// a client can send back what it read, but never changed. createdBy and
+// editedBy are usernames, empty when the change came from the command line.
+type TransactionResource struct {
+	Id          int             `json:"id"`
+	AccountId   int             `json:"accountId"`
+	Period      string          `json:"period"`
+	Date        string          `json:"date"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+	Payee       string          `json:"payee"`
+	Amount      utils.Money     `json:"amount"`
+	Category    string          `json:"category"`
+	Memo        string          `json:"memo"`
+	CheckNumber string          `json:"checkNumber"`
+	Tags        string          `json:"tags"`
+	FitId       string          `json:"fitId"`
+	Splits      []SplitResource `json:"splits"`
+	CreatedBy   string          `json:"createdBy"`
+	EditedBy    string          `json:"editedBy"`
+}
+
+type SplitResource struct {
+	Category string      `json:"category"`
+	Memo     string      `json:"memo"`
+	Amount   utils.Money `json:"amount"`
+}
+
+func transactionResource(t *db.Transaction, names map[int]string) TransactionResource {
+	resource := TransactionResource{
+		Id:          t.Id,
+		AccountId:   t.AccountId,
+		Period:      periodId(t.Period),
+		Date:        t.Date.Format("2006-01-02"),
+		Name:        t.Name,
+		DisplayName: t.DisplayName,
+		Payee:       t.Payee,
+		Amount:      t.Amount,
+		Category:    t.Category,
+		Memo:        t.Memo,
+		CheckNumber: t.CheckNumber,
+		Tags:        t.Tags,
+		FitId:       t.FitId,
+		Splits:      []SplitResource{},
+		CreatedBy:   names[t.CreatedBy],
+		EditedBy:    names[t.EditedBy],
+	}
+
+	for _, s := range t.Splits {
+		resource.Splits = append(resource.Splits, SplitResource{Category: s.Category, Memo: s.Memo, Amount: s.Amount})
+	}
+
+	return resource
+}
+
+// toDbTransaction checks the resource and copies its writable fields onto t.
+// The account has to be one the user can see; whether they may write to it
+// is checked afterwards.
+func (data *TransactionResource) toDbTransaction(t *db.Transaction, r *http.Request, roles db.AccountRoles) map[string]string {
+	fields := map[string]string{}
+
+	t.Name = strings.TrimSpace(data.Name)
+	if len(t.Name) == 0 {
+		fields["name"] = "A name is required."
+	}
+
+	if len(data.Date) == 0 && t.Id == 0 {
+		t.Date = time.Now()
+	} else if date, err := parseApiDate(data.Date); err != nil {
+		fields["date"] = "Dates are written yyyy-mm-dd."
+	} else {
+		t.Date = date
+	}
+
+	t.AccountId = apiDefaultAccount(r, data.AccountId)
+	if account, err := ledger(r).FindAccount(t.AccountId); err != nil || account == nil || !roles.Can(t.AccountId, db.RoleViewer) {
+		fields["accountId"] = fmt.Sprintf("No account with id %d.", t.AccountId)
+	}
+
+	t.Amount = data.Amount
+	t.DisplayName = strings.TrimSpace(data.DisplayName)
+	t.Payee = strings.TrimSpace(data.Payee)
+	t.Memo = strings.TrimSpace(data.Memo)
+	t.CheckNumber = strings.TrimSpace(data.CheckNumber)
+	t.Tags = strings.TrimSpace(data.Tags)
+
+	if category := strings.TrimSpace(data.Category); category != t.Category {
+		t.Category = category
+		t.CategoryId = 0
+	}
+
+	t.Splits = nil
+	var total utils.Money
+	for _, s := range data.Splits {
+		t.Splits = append(t.Splits, db.Split{Category: strings.TrimSpace(s.Category), Memo: strings.TrimSpace(s.Memo), Amount: s.Amount})
+		total += s.Amount
+	}
+	if len(t.Splits) > 0 && total != t.Amount {
+		fields["splits"] = fmt.Sprintf("The splits add up to %d but the amount is %d.", total, t.Amount)
+	}
+
+	return fields
+}
+
+// loadApiTransaction reads the {id} transaction from the current period,
+// checking the user has at least min on its account.
+func loadApiTransaction(w http.ResponseWriter, r *http.Request, roles db.AccountRoles, min db.Role) (*db.Transaction, bool) {
+	id, ok := apiId(w, r, "transaction")
+	if !ok {
+		return nil, false
+	}
+
+	t, err := ledger(r).GetTransaction(id)
+	if err != nil {
+		apiInternalError(w, err)
+		return nil, false
+	}
+	if t == nil {
+		apiNotFound(w, "transaction", r.PathValue("id"))
+		return nil, false
+	}
+	if !apiAllowed(w, roles, t.AccountId, min, "transaction", r.PathValue("id")) {
+		return nil, false
+	}
+
+	t.Period = currentPeriod()
+	return t, true
+}
+
+// apiListTransactions lists the current period, or every period's
+// transactions between ?from and ?to when either is given.
+func apiListTransactions(w http.ResponseWriter, r *http.Request) {
+	accountId, ok := apiAccountFilter(w, r)
+	if !ok {
+		return
+	}
+
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+	accounts := roles.With(db.RoleViewer).Only(accountId)
+
+	query := r.URL.Query()
+	var transactions []db.Transaction
+	var err error
+	if len(query.Get("from")) > 0 || len(query.Get("to")) > 0 {
+		from, to, ok := apiRange(w, query.Get("from"), query.Get("to"))
+		if !ok {
+			return
+		}
+		transactions, err = db.FetchTransactionsBetween(config.DataDir, from, to, accounts)
+	} else {
+		transactions, err = db.FetchPeriodTransactions(config.DataDir, currentPeriod())
+	}
+
+	if err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, apiCollection{Items: transactionResources(transactions, accounts)})
+}
+
+func transactionResources(transactions []db.Transaction, accounts db.AccountSet) []TransactionResource {
+	names := usernames()
+	items := []TransactionResource{}
+	for _, t := range transactions {
+		if accounts.Allows(t.AccountId) {
+			items = append(items, transactionResource(&t, names))
+		}
+	}
+	return items
+}
+
+// apiRange reads a from/to pair where to is the last day included. A missing
+// end runs from the start of time or to today.
+func apiRange(w http.ResponseWriter, from string, to string) (time.Time, time.Time, bool) {
+	start := time.Time{}
+	end := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+
+	var err error
+	if len(from) > 0 {
+		if start, err = parseApiDate(from); err != nil {
+			writeApiError(w, http.StatusBadRequest, "bad_request", "from is written yyyy-mm-dd.")
+			return start, end, false
+		}
+	}
+	if len(to) > 0 {
+		if end, err = parseApiDate(to); err != nil {
+			writeApiError(w, http.StatusBadRequest, "bad_request", "to is written yyyy-mm-dd.")
+			return start, end, false
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if !start.Before(end) {
+		writeApiError(w, http.StatusBadRequest, "bad_request", "from must be before to.")
+		return start, end, false
+	}
+
+	return start, end, true
+}
+
+func apiGetTransaction(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	t, ok := loadApiTransaction(w, r, roles, db.RoleViewer)
+	if !ok {
+		return
+	}
+
+	writeApiJSON(w, r, http.StatusOK, transactionResource(t, usernames()))
+}
+
+// apiCreateTransaction adds a transaction to the current period. Rules fill
+// in whatever the client left empty, the same as entering it on the page.
+func apiCreateTransaction(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	var data TransactionResource
+	if !readApiBody(w, r, &data) {
+		return
+	}
+
+	t := db.Transaction{CreatedBy: userId(r)}
+	if fields := data.toDbTransaction(&t, r, roles); len(fields) > 0 {
+		apiInvalid(w, fields)
+		return
+	}
+	if !apiAllowed(w, roles, t.AccountId, db.RoleEditor, "account", fmt.Sprint(t.AccountId)) {
+		return
+	}
+
+	engine, err := rules.Load()
+	if err != nil {
+		log.Printf("Error loading rules: %s\n", err)
+	} else {
+		ruled := t
+		engine.Apply(&ruled)
+		if len(t.Category) == 0 {
+			t.Category = ruled.Category
+		}
+		if len(t.Tags) == 0 {
+			t.Tags = ruled.Tags
+		}
+		if len(t.Payee) == 0 {
+			t.Payee = ruled.Payee
+		}
+		if len(t.DisplayName) == 0 {
+			t.DisplayName = ruled.DisplayName
+		}
+	}
+
+	if err = ledger(r).Insert(&t); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	saved, err := ledger(r).GetTransaction(t.Id)
+	if err != nil || saved == nil {
+		apiInternalError(w, fmt.Errorf("Error reading transaction %d back: %v", t.Id, err))
+		return
+	}
+	saved.Period = currentPeriod()
+
+	publish(r, EventTransactionCreated, saved.AccountId, saved.Id)
+	writeApiCreated(w, r, fmt.Sprintf("/transactions/%d", saved.Id), transactionResource(saved, usernames()))
+}
+
+// apiUpdateTransaction needs editor on the transaction's account, and on the
+// account it's moved to when that changes.
+func apiUpdateTransaction(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	names := usernames()
+	t, ok := loadApiTransaction(w, r, roles, db.RoleEditor)
+	if !ok || !checkIfMatch(w, r, transactionResource(t, names)) {
+		return
+	}
+
+	var data TransactionResource
+	if !readApiBody(w, r, &data) {
+		return
+	}
+
+	moved := t.AccountId
+	if fields := data.toDbTransaction(t, r, roles); len(fields) > 0 {
+		apiInvalid(w, fields)
+		return
+	}
+	if !apiAllowed(w, roles, t.AccountId, db.RoleEditor, "account", fmt.Sprint(t.AccountId)) {
+		return
+	}
+
+	t.EditedBy = userId(r)
+	if err := ledger(r).Update(t); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	saved, err := ledger(r).GetTransaction(t.Id)
+	if err != nil || saved == nil {
+		apiInternalError(w, fmt.Errorf("Error reading transaction %d back: %v", t.Id, err))
+		return
+	}
+	saved.Period = currentPeriod()
+
+	publish(r, EventTransactionUpdated, saved.AccountId, saved.Id)
+	if moved != saved.AccountId {
+		publish(r, EventTransactionDeleted, moved, saved.Id)
+	}
+	writeApiJSON(w, r, http.StatusOK, transactionResource(saved, names))
+}
+
+func apiDeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	roles, ok := apiRoles(w, r)
+	if !ok {
+		return
+	}
+
+	t, ok := loadApiTransaction(w, r, roles, db.RoleEditor)
+	if !ok || !checkIfMatch(w, r, transactionResource(t, usernames())) {
+		return
+	}
+
+	if err := ledger(r).Delete(t); err != nil {
+		apiInternalError(w, err)
+		return
+	}
+
+	publish(r, EventTransactionDeleted, t.AccountId, t.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// Everything the server serves needs a login except the login and setup
+// pages and the static files they use. Until the first user is created,
+// every page sends you to /setup to create one; after that /setup is closed.
+
+const (
+	sessionCookieName = "sacmoney_session"
+	sessionLifetime   = 30 * 24 * time.Hour
+
+	// failedLoginDelay slows down guessing passwords.
+	failedLoginDelay = time.Second
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	csrfContextKey
+	ledgerContextKey
+	releaseContextKey
+)
+
+type LoginMain struct {
+	Setup    bool
+	Username string
+	Next     string
+	Error    string
+}
+
+var (
+	setupLock sync.Mutex
+
+	// failedLoginWait is how a failed login waits out failedLoginDelay. Tests
+	// swap it to know when a login has got that far.
+	failedLoginWait = func() { time.Sleep(failedLoginDelay) }
+
+	// dummyHash is checked against when a username doesn't exist, so a
+	// wrong username takes as long as a wrong password.
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+func isPublicPath(path string) bool {
+	return path == "/login" || path == "/setup" || strings.HasPrefix(path, "/static/")
+}
+
+// requireLogin lets a request through only with a valid session cookie, and
+// puts the logged-in user on its context. It only holds the ledger while it
+// reads the store; lockLedger takes it again once the user is known.
+func requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ledgerLock.RLock()
+		user, err := sessionUser(r)
+		ledgerLock.RUnlock()
+		if err != nil {
+			log.Printf("Error: %s\n", err)
+			http.Error(w, "Error checking login.", http.StatusInternalServerError)
+			return
+		}
+
+		if user == nil {
+			ledgerLock.RLock()
+			refuseAnonymous(w, r)
+			ledgerLock.RUnlock()
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+// refuseAnonymous answers a request without a login the way its caller
+// expects: a JSON error for the API, a redirect for a page and a plain 401
+// for the pages' own posts.
+func refuseAnonymous(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, ApiPrefix+"/") {
+		writeApiError(w, http.StatusUnauthorized, "unauthorized", "Log in first.")
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Your login has expired. Log in again.", http.StatusUnauthorized)
+		return
+	}
+
+	target := "/login"
+	if count, err := db.CountUsers(); err == nil && count == 0 {
+		target = "/setup"
+	} else if r.URL.RequestURI() != "/" {
+		target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// currentUser is the user who made the request, or nil on a public page.
+func currentUser(r *http.Request) *db.User {
+	user, _ := r.Context().Value(userContextKey).(*db.User)
+	return user
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionUser(r *http.Request) (*db.User, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return nil, nil
+	}
+
+	session, err := db.FindSession(hashToken(cookie.Value))
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	return db.GetUser(session.UserId)
+}
+
+func startSession(w http.ResponseWriter, r *http.Request, user *db.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("Error creating session: %s", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	session := db.Session{TokenHash: hashToken(token), UserId: user.Id, Created: now, Expires: now.Add(sessionLifetime)}
+	if err := db.SaveSession(&session); err != nil {
+		return err
+	}
+
+	if err := db.DeleteExpiredSessions(); err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+
+	http.SetCookie(w, sessionCookie(r, token, session.Expires))
+	return nil
+}
+
+// sessionCookie can't be read by scripts or sent by other sites' forms, and
+// is only sent over HTTPS when the server is being reached over HTTPS.
+func sessionCookie(r *http.Request, token string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(token) == 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// safeNext only allows redirecting back to a page on this server.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if count, err := db.CountUsers(); err == nil && count == 0 {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+
+	data := LoginMain{Next: safeNext(r.FormValue("next"))}
+
+	if r.Method == http.MethodPost {
+		data.Username = strings.TrimSpace(r.FormValue("username"))
+		user, err := checkLogin(data.Username, r.FormValue("password"))
+		if err != nil {
+			log.Printf("Error: %s\n", err)
+			data.Error = "Error checking login."
+		} else if user == nil {
+			// The rest of the page doesn't need the ledger, and a rollover
+			// shouldn't have to wait for someone guessing passwords.
+			releaseLedger(r)
+			failedLoginWait()
+			data.Error = "Wrong username or password."
+		} else if err = startSession(w, r, user); err != nil {
+			log.Printf("Error: %s\n", err)
+			data.Error = "Error starting session."
+		} else {
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return
+		}
+	}
+
+	renderLogin(w, withCsrfCookie(w, r), data)
+}
+
+// checkLogin returns the user if the password is theirs, or nil.
+func checkLogin(username string, password string) (*db.User, error) {
+	user, err := db.GetUserByName(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		dummyHashOnce.Do(func() {
+			dummy := db.User{}
+			dummy.SetPassword(hashToken(time.Now().String()))
+			dummyHash = dummy.PasswordHash
+		})
+		(&db.User{PasswordHash: dummyHash}).CheckPassword(password)
+		return nil, nil
+	}
+
+	if !user.CheckPassword(password) {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	forgetSelection(r)
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err = db.DeleteSession(hashToken(cookie.Value)); err != nil {
+			log.Printf("Error: %s\n", err)
+		}
+	}
+
+	http.SetCookie(w, sessionCookie(r, "", time.Unix(0, 0)))
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// SetupHandler creates the first user, who is an admin. It only works while
+// there are no users at all.
+func SetupHandler(w http.ResponseWriter, r *http.Request) {
+	setupLock.Lock()
+	defer setupLock.Unlock()
+
+	count, err := db.CountUsers()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		http.Error(w, "Error checking users.", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := LoginMain{Setup: true, Next: "/"}
+
+	if r.Method == http.MethodPost {
+		data.Username = strings.TrimSpace(r.FormValue("username"))
+		user := db.User{Username: data.Username, IsAdmin: true}
+
+		if len(user.Username) == 0 {
+			data.Error = "A username is required."
+		} else if r.FormValue("password") != r.FormValue("confirm") {
+			data.Error = "The passwords don't match."
+		} else if err = user.SetPassword(r.FormValue("password")); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+		} else if err = db.Insert(&user); err != nil {
+			log.Printf("Error: %s\n", err)
+			data.Error = fmt.Sprintf("%s", err)
+		} else if err = startSession(w, r, &user); err != nil {
+			log.Printf("Error: %s\n", err)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		} else {
+			infof("Created admin user %s.\n", user.Username)
+			claimAccounts()
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	renderLogin(w, withCsrfCookie(w, r), data)
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, data LoginMain) {
+	renderPage(w, r, "login/login_tmpl.html", data)
+}
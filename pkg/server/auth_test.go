@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// holdFailedLogins stops failed logins in their delay until the returned
+// release is called. waiting gets a value as each one gets there.
+func holdFailedLogins(t *testing.T) (waiting chan struct{}, release func()) {
+	waiting = make(chan struct{}, 10)
+	held := make(chan struct{})
+	saved := failedLoginWait
+	failedLoginWait = func() {
+		waiting <- struct{}{}
+		<-held
+	}
+
+	released := false
+	release = func() {
+		if !released {
+			released = true
+			close(held)
+		}
+	}
+	t.Cleanup(func() {
+		release()
+		failedLoginWait = saved
+	})
+
+	return waiting, release
+}
+
+func TestFailedLoginDoesntHoldUpOthers(t *testing.T) {
+	handler, cookie := testServer(t)
+	waiting, release := holdFailedLogins(t)
+
+	csrf := &http.Cookie{Name: csrfCookieName, Value: "token"}
+	form := url.Values{"username": {"admin"}, "password": {"wrong"}, csrfFieldName: {"token"}}
+
+	done := make(chan string)
+	go func() {
+		w := serve(handler, csrf, http.MethodPost, "/login", form.Encode(),
+			"Content-Type", "application/x-www-form-urlencoded")
+		done <- w.Body.String()
+	}()
+
+	select {
+	case <-waiting:
+	case body := <-done:
+		t.Fatalf("The failed login answered without waiting:\n%s", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The failed login never got to its delay")
+	}
+
+	// The login is in its delay and stays there until released.
+	answered := make(chan int)
+	go func() {
+		answered <- serve(handler, cookie, http.MethodGet, ApiPrefix+"/accounts", "").Code
+	}()
+	select {
+	case code := <-answered:
+		if code != http.StatusOK {
+			t.Errorf("Listing accounts answered %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("A request waited for the failed login's delay")
+	}
+
+	// Nor does anything that needs the ledger to itself, the way a rollover
+	// does.
+	locked := make(chan bool)
+	go func() {
+		ledgerLock.Lock()
+		ledgerLock.Unlock()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The failed login held on to the ledger through its delay")
+	}
+
+	release()
+	if body := <-done; !strings.Contains(body, "Wrong username or password.") {
+		t.Errorf("The failed login answered:\n%s", body)
+	}
+}
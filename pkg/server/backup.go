@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	backup "tjdickerson/sacmoney/pkg/backup"
+	encryption "tjdickerson/sacmoney/pkg/encryption"
+)
+
+// BackupHandler downloads the whole data directory as a backup archive.
+// Restoring needs the ledger closed, so that is only done from the cli. A
+// backup holds every account, so only admins can take one.
+func BackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	archive, err := backup.Create(config.DataDir)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
+	}
+
+	name := fmt.Sprintf("sacmoney-backup-%s.json", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = writeDownload(w, r, name, archive.Write); err != nil {
+		log.Printf("Error writing backup: %s\n", err)
+	}
+}
+
+// writeDownload sends what write produces as a file named name. When the
+// form has a passphrase the file is encrypted with it first. The passphrase
+// only comes from a posted form, so it never ends up in a url or a log.
+func writeDownload(w http.ResponseWriter, r *http.Request, name string, write func(io.Writer) error) error {
+	passphrase := ""
+	if r.Method == http.MethodPost {
+		passphrase = r.PostFormValue("passphrase")
+	}
+
+	if len(passphrase) == 0 {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+		return write(w)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s%s\"", name, encryption.Extension))
+
+	sealed := encryption.NewWriter(w, passphrase)
+	if err := write(sealed); err != nil {
+		return err
+	}
+	return sealed.Close()
+}
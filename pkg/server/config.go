@@ -0,0 +1,319 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything about the server that can change between installs.
+// Each setting comes from, in order of precedence: a command line flag, a
+// SACMONEY_* environment variable, the JSON file named by -config or
+// SACMONEY_CONFIG, and finally the defaults in DefaultConfig. Relative paths
+// in the file are relative to the file, so it can sit next to its data;
+// relative paths anywhere else are relative to the working directory.
+type Config struct {
+	Listen           string   `json:"listen"`
+	DataDir          string   `json:"dataDir"`
+	SnapshotDir      string   `json:"snapshotDir"`
+	SnapshotInterval string   `json:"snapshotInterval"`
+	Templates        string   `json:"templates"`
+	Static           string   `json:"static"`
+	LogLevel         string   `json:"logLevel"`
+	TLSCert          string   `json:"tlsCert"`
+	TLSKey           string   `json:"tlsKey"`
+	RedirectListen   string   `json:"redirectListen"`
+	Disable          []string `json:"disable"`
+	HTTPS            bool     `json:"https"`
+	Dev              bool     `json:"dev"`
+}
+
+// Features that can be turned off with Disable.
+const (
+	FeatureApi    = "api"
+	FeatureImport = "import"
+)
+
+var features = []string{FeatureApi, FeatureImport}
+
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogError = "error"
+)
+
+var logLevels = []string{LogDebug, LogInfo, LogError}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen:           ":8080",
+		DataDir:          "data",
+		SnapshotDir:      "backups",
+		SnapshotInterval: DefaultSnapshotInterval.String(),
+		LogLevel:         LogInfo,
+	}
+}
+
+// config is what Run was started with. It is only written before the server
+// starts listening.
+var config = DefaultConfig()
+
+// setting ties a Config field to its flag and environment variable.
+type setting struct {
+	name      string
+	usage     string
+	path      bool
+	field     func(c *Config) *string
+	boolField func(c *Config) *bool
+	isList    bool
+}
+
+var settings = []setting{
+	{name: "listen", usage: "address to listen on, such as :8080 or 127.0.0.1:8080",
+		field: func(c *Config) *string { return &c.Listen }},
+	{name: "data-dir", usage: "directory holding the ledger", path: true,
+		field: func(c *Config) *string { return &c.DataDir }},
+	{name: "snapshot-dir", usage: "directory snapshots are kept in", path: true,
+		field: func(c *Config) *string { return &c.SnapshotDir }},
+	{name: "snapshot-interval", usage: "how often to take snapshots, such as 6h, or off",
+		field: func(c *Config) *string { return &c.SnapshotInterval }},
+	{name: "templates", usage: "directory to load the page templates from instead of the built in ones", path: true,
+		field: func(c *Config) *string { return &c.Templates }},
+	{name: "static", usage: "directory to serve /static/ from instead of the built in files", path: true,
+		field: func(c *Config) *string { return &c.Static }},
+	{name: "log-level", usage: "debug, info or error",
+		field: func(c *Config) *string { return &c.LogLevel }},
+	{name: "https", usage: "serve HTTPS, with a self-signed certificate unless -tls-cert is set",
+		boolField: func(c *Config) *bool { return &c.HTTPS }},
+	{name: "tls-cert", usage: "certificate file to serve HTTPS with", path: true,
+		field: func(c *Config) *string { return &c.TLSCert }},
+	{name: "tls-key", usage: "private key file for -tls-cert", path: true,
+		field: func(c *Config) *string { return &c.TLSKey }},
+	{name: "redirect-listen", usage: "address to also listen on for plain HTTP and redirect to HTTPS, such as :80",
+		field: func(c *Config) *string { return &c.RedirectListen }},
+	{name: "disable", usage: "comma separated features to turn off: " + strings.Join(features, ", "), isList: true},
+	{name: "dev", usage: "read templates and static files from disk on every request",
+		boolField: func(c *Config) *bool { return &c.Dev }},
+}
+
+// envName is the environment variable for a setting, e.g. SACMONEY_DATA_DIR.
+func (s setting) envName() string {
+	return "SACMONEY_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func (s setting) isBool() bool {
+	return s.boolField != nil
+}
+
+func (s setting) set(c *Config, value string) error {
+	if s.isBool() {
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s setting %s, use true or false.", s.name, value)
+		}
+		*s.boolField(c) = on
+		return nil
+	}
+
+	if s.isList {
+		c.Disable = nil
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				c.Disable = append(c.Disable, v)
+			}
+		}
+		return nil
+	}
+
+	*s.field(c) = value
+	return nil
+}
+
+// settingFlag only records what was passed, so LoadConfig can apply flags
+// after the file and the environment.
+type settingFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *settingFlag) String() string {
+	return f.value
+}
+
+func (f *settingFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// LoadConfig builds the server's configuration from the defaults, the config
+// file, the environment and args, which are the command line flags.
+func LoadConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+
+	flags := flag.NewFlagSet("sacmoney-server", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("SACMONEY_CONFIG"), "JSON file to read settings from (SACMONEY_CONFIG)")
+	values := map[string]*settingFlag{}
+	for _, s := range settings {
+		values[s.name] = &settingFlag{isBool: s.isBool()}
+		flags.Var(values[s.name], s.name, fmt.Sprintf("%s (%s)", s.usage, s.envName()))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, fmt.Errorf("Unexpected argument %s.", flags.Arg(0))
+	}
+
+	if len(*configFile) > 0 {
+		if err := cfg.readFile(*configFile); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			if err := s.set(&cfg, value); err != nil {
+				return cfg, err
+			}
+		}
+	}
+
+	visited := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { visited[f.Name] = true })
+	for _, s := range settings {
+		if visited[s.name] {
+			if err := s.set(&cfg, values[s.name].value); err != nil {
+				return cfg, err
+			}
+		}
+	}
+
+	return cfg, cfg.check()
+}
+
+// readFile overlays the settings in a JSON config file. Settings it leaves
+// out keep their defaults.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error reading config file: %s", err)
+	}
+	defer f.Close()
+
+	var fromFile Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&fromFile); err != nil {
+		return fmt.Errorf("Error reading config file %s: %s", path, err)
+	}
+
+	base := filepath.Dir(path)
+	for _, s := range settings {
+		if s.isBool() {
+			*s.boolField(c) = *s.boolField(&fromFile)
+			continue
+		}
+		if s.isList {
+			continue
+		}
+		value := *s.field(&fromFile)
+		if len(value) == 0 {
+			continue
+		}
+		if s.path && !filepath.IsAbs(value) {
+			value = filepath.Join(base, value)
+		}
+		*s.field(c) = value
+	}
+
+	if fromFile.Disable != nil {
+		c.Disable = fromFile.Disable
+	}
+
+	return nil
+}
+
+func (c Config) check() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("Invalid listen address %s, use host:port or :port.", c.Listen)
+	}
+
+	if len(c.DataDir) == 0 {
+		return errors.New("The data directory can't be empty.")
+	}
+
+	if _, err := parseSnapshotInterval(c.SnapshotInterval); err != nil {
+		return err
+	}
+
+	if !slices.Contains(logLevels, c.LogLevel) {
+		return fmt.Errorf("Unknown log level %s, use one of %s.", c.LogLevel, strings.Join(logLevels, ", "))
+	}
+
+	if (len(c.TLSCert) == 0) != (len(c.TLSKey) == 0) {
+		return errors.New("HTTPS needs both a certificate and a key.")
+	}
+
+	if len(c.RedirectListen) > 0 {
+		if !c.ServesHTTPS() {
+			return errors.New("Redirecting to HTTPS needs HTTPS turned on.")
+		}
+		if _, _, err := net.SplitHostPort(c.RedirectListen); err != nil {
+			return fmt.Errorf("Invalid redirect address %s, use host:port or :port.", c.RedirectListen)
+		}
+	}
+
+	for _, f := range c.Disable {
+		if !slices.Contains(features, f) {
+			return fmt.Errorf("Unknown feature %s, use one of %s.", f, strings.Join(features, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ServesHTTPS is whether the server listens with HTTPS. Giving it a
+// certificate turns HTTPS on.
+func (c Config) ServesHTTPS() bool {
+	return c.HTTPS || len(c.TLSCert) > 0
+}
+
+func (c Config) Enabled(feature string) bool {
+	return !slices.Contains(c.Disable, feature)
+}
+
+// infof logs what the server is doing, unless the log level is error.
+// Errors are always logged.
+func infof(format string, args ...any) {
+	if config.LogLevel != LogError {
+		log.Printf(format, args...)
+	}
+}
+
+// logRequests logs every request when the log level is debug.
+func logRequests(next http.Handler) http.Handler {
+	if config.LogLevel != LogDebug {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s %s\n", r.Method, r.URL.RequestURI(), time.Since(start).Round(time.Microsecond))
+	})
+}
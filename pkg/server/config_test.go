@@ -0,0 +1,106 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// clearConfigEnv unsets every SACMONEY_* variable LoadConfig reads for the
+// rest of the test, so the machine running it can't change the outcome.
+func clearConfigEnv(t *testing.T) {
+	names := []string{"SACMONEY_CONFIG"}
+	for _, s := range settings {
+		names = append(names, s.envName())
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "sacmoney.json")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+
+	// listen is set everywhere, snapshot-interval everywhere but the flags,
+	// and log-level and data-dir only in the file.
+	path := writeConfigFile(t, `{
+		"listen": ":9001",
+		"snapshotInterval": "1h",
+		"logLevel": "debug",
+		"dataDir": "ledger",
+		"disable": ["import"]
+	}`)
+	t.Setenv("SACMONEY_CONFIG", path)
+	t.Setenv("SACMONEY_LISTEN", ":9002")
+	t.Setenv("SACMONEY_SNAPSHOT_INTERVAL", "2h")
+	t.Setenv("SACMONEY_DISABLE", "api, import")
+
+	cfg, err := LoadConfig([]string{"-listen", ":9003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultConfig()
+	want.Listen = ":9003"
+	want.SnapshotInterval = "2h"
+	want.LogLevel = LogDebug
+	want.DataDir = filepath.Join(filepath.Dir(path), "ledger")
+	want.Disable = []string{FeatureApi, FeatureImport}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Loaded\n%+v\nwant\n%+v", cfg, want)
+	}
+
+	// A flag beats the environment even when it names the file.
+	other := writeConfigFile(t, `{"logLevel": "error"}`)
+	if cfg, err = LoadConfig([]string{"-config", other}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != LogError || cfg.Listen != ":9002" || cfg.DataDir != "data" {
+		t.Errorf("With -config, loaded %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+	}{
+		{name: "unknown key", file: `{"listen": ":9001", "lisen": ":9002"}`},
+		{name: "malformed file", file: `{"listen": ":9001",`},
+		{name: "wrong type", file: `{"disable": "api"}`},
+		{name: "missing file", args: []string{"-config", filepath.Join(t.TempDir(), "missing.json")}},
+		{name: "bad value in file", file: `{"snapshotInterval": "often"}`},
+		{name: "bad value in environment", env: map[string]string{"SACMONEY_LOG_LEVEL": "loud"}},
+		{name: "unknown feature", args: []string{"-disable", "api,reports"}},
+		{name: "certificate without key", args: []string{"-tls-cert", "cert.pem"}},
+		{name: "argument", args: []string{"serve"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			if len(test.file) > 0 {
+				t.Setenv("SACMONEY_CONFIG", writeConfigFile(t, test.file))
+			}
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			if cfg, err := LoadConfig(test.args); err == nil {
+				t.Errorf("Loaded %+v", cfg)
+			}
+		})
+	}
+}
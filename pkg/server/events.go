@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// Every change to the ledger is published as an event, and /events streams
+// them to the open pages as server-sent events so they can refresh what they
+// show without a reload. Each event has an id; a browser that loses the
+// stream reconnects with the last id it saw in Last-Event-ID and is sent
+// whatever it missed. The last eventsKept events are remembered for that,
+// and a page that missed more than that, or was open across a restart, is
+// told to reload instead.
+
+const (
+	EventTransactionCreated = "transaction.created"
+	EventTransactionUpdated = "transaction.updated"
+	EventTransactionDeleted = "transaction.deleted"
+	EventRecurringCreated   = "recurring.created"
+	EventRecurringUpdated   = "recurring.updated"
+	EventRecurringDeleted   = "recurring.deleted"
+	EventAccountCreated     = "account.created"
+	EventAccountUpdated     = "account.updated"
+	EventAccountDeleted     = "account.deleted"
+	EventRollover           = "rollover"
+	EventRolloverUndone     = "rollover.undone"
+)
+
+const (
+	eventsKept = 256
+
+	// eventsBuffered is how far a stream may fall behind before it is
+	// dropped. The browser reconnects and catches up from the replay.
+	eventsBuffered = 32
+
+	eventsHeartbeat = 25 * time.Second
+	eventsRetry     = 3 * time.Second
+)
+
+// ChangeEvent says what changed, not what it changed to; pages fetch that
+// themselves. AccountId is 0 for changes to every account, and Id is 0 when
+// many things changed at once, as in an import.
+type ChangeEvent struct {
+	Type      string `json:"type"`
+	AccountId int    `json:"accountId,omitempty"`
+	Id        int    `json:"id,omitempty"`
+	Period    string `json:"period"`
+
+	seq    int64
+	userId int
+}
+
+type eventHub struct {
+	sync.Mutex
+	nextSeq     int64
+	recent      []ChangeEvent
+	subscribers map[chan ChangeEvent]bool
+}
+
+// Event ids start at the time the server started, so ids from before a
+// restart are always older than anything it remembers.
+var events = eventHub{
+	nextSeq:     time.Now().UnixMilli(),
+	subscribers: map[chan ChangeEvent]bool{},
+}
+
+// publish sends an event for a change the request made to the period its
+// page shows, and passes it on to the webhooks.
+func publish(r *http.Request, kind string, accountId int, id int) {
+	e := ChangeEvent{Type: kind, AccountId: accountId, Id: id, Period: periodId(shownPeriod(r)), userId: userId(r)}
+	events.publish(e)
+	notifyWebhooks(r, e)
+}
+
+func (h *eventHub) publish(e ChangeEvent) {
+	h.Lock()
+	defer h.Unlock()
+
+	e.seq = h.nextSeq
+	h.nextSeq++
+
+	h.recent = append(h.recent, e)
+	if len(h.recent) > eventsKept {
+		h.recent = h.recent[len(h.recent)-eventsKept:]
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe starts a stream. When lastSeq is set it also returns the events
+// published after it, or false when some of them are no longer remembered.
+func (h *eventHub) subscribe(lastSeq int64) (chan ChangeEvent, []ChangeEvent, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	ch := make(chan ChangeEvent, eventsBuffered)
+	h.subscribers[ch] = true
+
+	if lastSeq == 0 || lastSeq >= h.nextSeq-1 {
+		return ch, nil, true
+	}
+
+	if len(h.recent) == 0 || lastSeq < h.recent[0].seq-1 {
+		return ch, nil, false
+	}
+
+	var missed []ChangeEvent
+	for _, e := range h.recent {
+		if e.seq > lastSeq {
+			missed = append(missed, e)
+		}
+	}
+	return ch, missed, true
+}
+
+func (h *eventHub) unsubscribe(ch chan ChangeEvent) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.subscribers[ch] {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// EventsHandler streams change events to a page. It only sends events about
+// the accounts the user could see when the stream started, ones about every
+// account, and the user's own, such as adding an account.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported.", http.StatusInternalServerError)
+		return
+	}
+
+	user := userId(r)
+	roles, err := userRoles(r)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		http.Error(w, "Error reading your accounts.", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream stays open for as long as the page does, so it can't hold
+	// the ledger the whole time.
+	releaseLedger(r)
+
+	lastSeq, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	ch, missed, complete := events.subscribe(lastSeq)
+	defer events.unsubscribe(ch)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if !complete {
+		io.WriteString(w, "event: reset\ndata: {}\n\n")
+	}
+
+	send := func(e ChangeEvent) {
+		if e.AccountId != 0 && e.userId != user && !roles.Can(e.AccountId, db.RoleViewer) {
+			return
+		}
+
+		body, _ := json.Marshal(e)
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.seq, body)
+	}
+
+	for _, e := range missed {
+		send(e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, open := <-ch:
+			if !open {
+				return
+			}
+			send(e)
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
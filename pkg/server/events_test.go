@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// publishEvents publishes n events about the account from the first user and
+// returns their ids.
+func publishEvents(n int, accountId int) []int64 {
+	var ids []int64
+	for i := 0; i < n; i++ {
+		events.publish(ChangeEvent{Type: EventTransactionCreated, AccountId: accountId, Id: i + 1, userId: 1})
+
+		events.Lock()
+		ids = append(ids, events.recent[len(events.recent)-1].seq)
+		events.Unlock()
+	}
+	return ids
+}
+
+// replayedEvents reconnects to /events with the Last-Event-ID and a context
+// that is already done, so the handler sends what it replays and returns.
+func replayedEvents(t *testing.T, handler http.Handler, cookie *http.Cookie, lastId int64) (ids []int64, reset bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	r.AddCookie(cookie)
+	r.Header.Set("Last-Event-ID", strconv.FormatInt(lastId, 10))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/events answered %d %s", w.Code, w.Body)
+	}
+
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if line == "event: reset" {
+			reset = true
+		}
+		if id, found := strings.CutPrefix(line, "id: "); found {
+			seq, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, seq)
+		}
+	}
+	return ids, reset
+}
+
+func sameIds(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEventsReplayWhatWasMissed(t *testing.T) {
+	handler, cookie := testServer(t)
+	published := publishEvents(5, 0)
+
+	ids, reset := replayedEvents(t, handler, cookie, published[2])
+	if reset || !sameIds(ids, published[3:]) {
+		t.Errorf("Reconnecting after %d replayed %v (reset %t), want %v", published[2], ids, reset, published[3:])
+	}
+
+	ids, reset = replayedEvents(t, handler, cookie, published[4])
+	if reset || len(ids) != 0 {
+		t.Errorf("Reconnecting after the latest event replayed %v (reset %t)", ids, reset)
+	}
+}
+
+// TestEventsResetWhenTooFarBehind reconnects from just inside and just
+// outside what the hub remembers, and from before the server started.
+func TestEventsResetWhenTooFarBehind(t *testing.T) {
+	handler, cookie := testServer(t)
+	published := publishEvents(eventsKept+2, 0)
+
+	ids, reset := replayedEvents(t, handler, cookie, published[1])
+	if reset || !sameIds(ids, published[2:]) {
+		t.Errorf("Missing the last %d events replayed %d (reset %t), want them all", eventsKept, len(ids), reset)
+	}
+
+	tests := []struct {
+		name   string
+		lastId int64
+	}{
+		{"one too many missed", published[0]},
+		{"before a restart", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids, reset := replayedEvents(t, handler, cookie, test.lastId)
+			if !reset || len(ids) != 0 {
+				t.Errorf("Replayed %d events (reset %t), want only a reset", len(ids), reset)
+			}
+		})
+	}
+}
+
+// TestEventsOnlyShowVisibleAccounts has a user who can view one account of
+// two, and checks the other's events reach them neither when replayed nor
+// while they are connected.
+func TestEventsOnlyShowVisibleAccounts(t *testing.T) {
+	handler, cookie := testServer(t)
+	visible := createAccount(t, handler, cookie, "Checking")
+	hidden := createAccount(t, handler, cookie, "Savings")
+
+	viewer := &db.User{Username: "viewer"}
+	if err := viewer.SetPassword(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert(viewer); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAccountRole(visible, viewer.Id, db.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err := startSession(w, httptest.NewRequest(http.MethodGet, "/", nil), viewer); err != nil {
+		t.Fatal(err)
+	}
+	viewerCookie := w.Result().Cookies()[0]
+
+	before := publishEvents(1, visible)[0]
+	hiddenIds := publishEvents(1, hidden)
+	visibleIds := publishEvents(1, visible)
+	everyIds := publishEvents(1, 0)
+
+	ids, _ := replayedEvents(t, handler, viewerCookie, before)
+	if want := append(visibleIds, everyIds...); !sameIds(ids, want) {
+		t.Errorf("Replayed %v, want %v and not %v", ids, want, hiddenIds)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(viewerCookie)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The retry line comes once the stream is subscribed.
+	stream := bufio.NewReader(resp.Body)
+	if line, err := stream.ReadString('\n'); err != nil || !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("The stream started with %q: %v", line, err)
+	}
+
+	hiddenIds = publishEvents(1, hidden)
+	visibleIds = publishEvents(1, visible)
+
+	// Events arrive in order, so the hidden one would come first.
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, found := strings.CutPrefix(strings.TrimSpace(line), "id: "); found {
+			if want := strconv.FormatInt(visibleIds[0], 10); id != want {
+				t.Errorf("The stream sent %s, want %s and not %d", id, want, hiddenIds[0])
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+	duplicates "tjdickerson/sacmoney/pkg/duplicates"
+	exporter "tjdickerson/sacmoney/pkg/exporter"
+	importer "tjdickerson/sacmoney/pkg/importer"
+	reports "tjdickerson/sacmoney/pkg/reports"
+)
+
+const maxImportSize = 10 << 20
+
+type ImportRowData struct {
+	Line        string
+	Date        string
+	Name        string
+	Amount      string
+	Category    string
+	DisplayName string
+	Tags        string
+	Memo        string
+	Splits      string
+	CheckNumber string
+	IsNeg       bool
+	Duplicate   string
+	Match       string
+	Action      string
+	Error       string
+}
+
+type ImportMain struct {
+	AccountId      string
+	Accounts       []AccountData
+	ExportAccounts []AccountData
+	Mappings       []string
+	DateFormats    []string
+	Mapping        db.CsvMapping
+	Content        string
+	Rows           []ImportRowData
+	ValidRows      int
+	IsOfx          bool
+	IsCsv          bool
+	ExportFrom     string
+	ExportTo       string
+	Balance        *ImportBalance
+	IsAdmin        bool
+	CanImport      bool
+	Message        string
+	Error          string
+}
+
+type ImportBalance struct {
+	LedgerBalance  string
+	LedgerDate     string
+	CurrentBalance string
+	Difference     string
+	Matches        bool
+}
+
+func convertImportRow(r *importer.Row) ImportRowData {
+	data := ImportRowData{
+		Line:        strconv.Itoa(r.Line),
+		Name:        r.Name,
+		Amount:      r.Amount.String(),
+		Category:    r.Category,
+		DisplayName: r.DisplayName,
+		Tags:        r.Tags,
+		Memo:        r.Memo,
+		CheckNumber: r.CheckNumber,
+		IsNeg:       r.Amount < 0,
+		Duplicate:   r.Duplicate,
+		Action:      r.Action,
+		Error:       r.Error,
+	}
+
+	if r.Match != nil {
+		data.Match = fmt.Sprintf("Looks like %s on %s (%.0f%% match)", r.Match.Existing.Name,
+			r.Match.Existing.Date.Format("Mon 02 Jan 2006"), r.Match.Score*100)
+	}
+
+	if !r.Date.IsZero() {
+		data.Date = r.Date.Format("Mon 02 Jan 2006")
+	}
+
+	var splits []string
+	for _, s := range r.Splits {
+		splits = append(splits, fmt.Sprintf("%s %s", s.Category, s.Amount.String()))
+	}
+	data.Splits = strings.Join(splits, ", ")
+
+	return data
+}
+
+func mappingFromForm(r *http.Request) db.CsvMapping {
+	number := func(field string) int {
+		n, _ := strconv.Atoi(strings.TrimSpace(r.FormValue(field)))
+		return n
+	}
+
+	return db.CsvMapping{
+		Name:              strings.TrimSpace(r.FormValue("mappingName")),
+		HasHeader:         r.FormValue("hasHeader") == "on",
+		Delimiter:         r.FormValue("delimiter"),
+		DateColumn:        number("dateColumn"),
+		DescriptionColumn: number("descriptionColumn"),
+		AmountColumn:      number("amountColumn"),
+		DebitColumn:       number("debitColumn"),
+		CreditColumn:      number("creditColumn"),
+		CheckNumberColumn: number("checkNumberColumn"),
+		DateFormat:        strings.TrimSpace(r.FormValue("dateFormat")),
+		NegateAmounts:     r.FormValue("negateAmounts") == "on",
+	}
+}
+
+func readImportContent(r *http.Request) (string, error) {
+	file, _, err := r.FormFile("file")
+	if err == nil {
+		defer file.Close()
+		content, err := io.ReadAll(io.LimitReader(file, maxImportSize))
+		if err != nil {
+			return "", fmt.Errorf("Error reading uploaded file: %s", err)
+		}
+		return string(content), nil
+	}
+
+	content := r.FormValue("content")
+	if len(content) == 0 {
+		return "", fmt.Errorf("Choose a file to import.")
+	}
+
+	return content, nil
+}
+
+// newImportMain offers the accounts the user can add transactions to,
+// starting on the one the pages show.
+func newImportMain(r *http.Request) ImportMain {
+	data := ImportMain{
+		DateFormats: importer.DateFormats,
+		IsAdmin:     isAdmin(r),
+		CanImport:   config.Enabled(FeatureImport),
+		Mapping: db.CsvMapping{
+			HasHeader:         true,
+			Delimiter:         ",",
+			DateColumn:        1,
+			DescriptionColumn: 2,
+			AmountColumn:      3,
+			DateFormat:        importer.DateFormats[1],
+		},
+	}
+
+	editable := accountsWith(r, db.RoleEditor)
+	if account, _, err := userAccount(r); err == nil && account != nil && editable.Allows(account.Id) {
+		data.AccountId = strconv.Itoa(account.Id)
+	}
+
+	from, to, _ := reports.ParseRange("", "")
+	data.ExportFrom = from.Format("2006-01-02")
+	data.ExportTo = to.AddDate(0, 0, -1).Format("2006-01-02")
+
+	accounts, err := ledger(r).FetchAccounts(editable)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, a := range accounts {
+		data.Accounts = append(data.Accounts, convertAccount(&a))
+	}
+
+	viewable, err := ledger(r).FetchAccounts(accountsWith(r, db.RoleViewer))
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, a := range viewable {
+		data.ExportAccounts = append(data.ExportAccounts, convertAccount(&a))
+	}
+
+	mappings, err := db.FetchAllCsvMappings()
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, m := range mappings {
+		data.Mappings = append(data.Mappings, m.Name)
+	}
+
+	return data
+}
+
+func ImportMainHandler(w http.ResponseWriter, r *http.Request) {
+	data := newImportMain(r)
+
+	if r.Method != http.MethodPost {
+		if name := r.URL.Query().Get("mapping"); len(name) > 0 {
+			saved, err := db.GetCsvMapping(name)
+			if err != nil {
+				data.Error = fmt.Sprintf("%s", err)
+			} else if saved == nil {
+				data.Error = fmt.Sprintf("No saved mapping named %s.", name)
+			} else {
+				data.Mapping = *saved
+			}
+		}
+
+		renderImport(w, r, data)
+		return
+	}
+
+	if !data.CanImport {
+		data.Error = "Importing is turned off."
+		renderImport(w, r, data)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil && err != http.ErrNotMultipart {
+		data.Error = fmt.Sprintf("Error reading upload: %s", err)
+		renderImport(w, r, data)
+		return
+	}
+
+	data.Mapping = mappingFromForm(r)
+	data.AccountId = r.FormValue("account")
+
+	content, err := readImportContent(r)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		renderImport(w, r, data)
+		return
+	}
+	data.Content = content
+
+	accountId, err := strconv.Atoi(data.AccountId)
+	if err != nil || !accountsWith(r, db.RoleEditor).Allows(accountId) {
+		data.Error = "Choose an account to import into."
+		renderImport(w, r, data)
+		return
+	}
+
+	var rows []importer.Row
+	var statement importer.Statement
+	data.IsOfx = importer.IsOfx(content)
+	if data.IsOfx {
+		statement, err = importer.ParseOfx(strings.NewReader(content))
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			renderImport(w, r, data)
+			return
+		}
+
+		rows = statement.Rows
+		if err = importer.MarkImported(rows, config.DataDir, accountId); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			renderImport(w, r, data)
+			return
+		}
+	} else if importer.IsQif(content) {
+		rows, err = importer.ParseQif(strings.NewReader(content))
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			renderImport(w, r, data)
+			return
+		}
+	} else {
+		data.IsCsv = true
+		rows, err = importer.ParseCsv(strings.NewReader(content), data.Mapping)
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			renderImport(w, r, data)
+			return
+		}
+	}
+
+	if data.IsCsv && r.FormValue("saveMapping") == "on" {
+		if len(data.Mapping.Name) == 0 {
+			data.Error = "Name the mapping to save it."
+		} else if err = db.SaveCsvMapping(&data.Mapping); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+		} else {
+			data.Mappings = appendUnique(data.Mappings, data.Mapping.Name)
+		}
+	}
+
+	if err = importer.ApplyRules(rows, accountId); err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		renderImport(w, r, data)
+		return
+	}
+
+	if err = importer.MatchExisting(rows, config.DataDir, accountId); err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		renderImport(w, r, data)
+		return
+	}
+
+	for i := range rows {
+		if rows[i].Match == nil {
+			continue
+		}
+
+		rows[i].Action = duplicates.ActionSkip
+		switch action := r.FormValue(fmt.Sprintf("duplicate_%d", rows[i].Line)); action {
+		case duplicates.ActionKeep, duplicates.ActionMerge:
+			rows[i].Action = action
+		}
+	}
+
+	if statement.HasLedgerBalance {
+		data.Balance = importBalance(r, &statement, accountId)
+	}
+
+	if r.FormValue("action") == "import" {
+		count, err := importer.Import(ledger(r), config.DataDir, rows, accountId, userId(r))
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+		}
+		if count > 0 {
+			publish(r, EventTransactionCreated, accountId, 0)
+		}
+
+		data.Content = ""
+		data.Message = fmt.Sprintf("Imported %d transactions.", count)
+		renderImport(w, r, data)
+		return
+	}
+
+	for _, row := range rows {
+		if len(row.Error) == 0 && len(row.Duplicate) == 0 {
+			data.ValidRows++
+		}
+		data.Rows = append(data.Rows, convertImportRow(&row))
+	}
+
+	renderImport(w, r, data)
+}
+
+// importBalance compares the ledger balance the bank reported with what the
+// account adds up to after the statement's new rows are imported.
+func importBalance(r *http.Request, statement *importer.Statement, accountId int) *ImportBalance {
+	account, err := ledger(r).GetAccount(accountId)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return nil
+	}
+
+	current := account.TotalAvailable
+	for _, row := range statement.Rows {
+		if len(row.Error) > 0 || len(row.Duplicate) > 0 {
+			continue
+		}
+		if row.Match != nil && row.Action != duplicates.ActionKeep {
+			continue
+		}
+		current += row.Amount
+	}
+
+	return &ImportBalance{
+		LedgerBalance:  statement.LedgerBalance.String(),
+		LedgerDate:     statement.LedgerDate.Format("Mon 02 Jan 2006"),
+		CurrentBalance: current.String(),
+		Difference:     (statement.LedgerBalance - current).String(),
+		Matches:        statement.LedgerBalance == current,
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func renderImport(w http.ResponseWriter, r *http.Request, data ImportMain) {
+	renderPage(w, r, "import/import_main_tmpl.html", data)
+}
+
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reports.ParseRange(r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
+	}
+
+	accountId := 0
+	if len(r.FormValue("account")) > 0 {
+		if accountId, err = strconv.Atoi(r.FormValue("account")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, fmt.Sprintf("Invalid account id: %s", r.FormValue("account")))
+			return
+		}
+	}
+
+	ledger, err := exporter.Load(config.DataDir, accountsWith(r, db.RoleViewer).Only(accountId), from, to)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
+	}
+
+	name := fmt.Sprintf("sacmoney-%s-%s", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+	var write func(io.Writer) error
+	switch r.FormValue("format") {
+	case "qif":
+		w.Header().Set("Content-Type", "application/qif")
+		name += ".qif"
+		write = ledger.WriteQif
+	case "ledger":
+		w.Header().Set("Content-Type", "text/plain")
+		name += ".ledger"
+		write = ledger.WriteLedger
+	case "hledger":
+		w.Header().Set("Content-Type", "text/plain")
+		name += ".journal"
+		write = ledger.WriteHledger
+	case "beancount":
+		w.Header().Set("Content-Type", "text/plain")
+		name += ".beancount"
+		write = ledger.WriteBeancount
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, fmt.Sprintf("Unknown export format %s", r.FormValue("format")))
+		return
+	}
+
+	if err = writeDownload(w, r, name, write); err != nil {
+		log.Printf("Error writing export: %s\n", err)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// The database package keeps the latest period and the store open for the
+// whole server. Requests share them under ledgerLock; a rollover or a snapshot
+// restore, which close them and open others, waits for the requests in
+// flight and runs alone.
+//
+// An API update or delete runs alone as well, so no other write can land
+// between its If-Match check and its own write.
+//
+// Each request reads and writes its period through ledger(r). That is the
+// open period, unless a page views an earlier one with ?period=yyyy-mm, in
+// which case that period is opened on its own connection for the request, so
+// no other request sees any change.
+
+var (
+	ledgerLock sync.RWMutex
+
+	// ledgerPeriod is the open period, the one the pages show and writes go
+	// to. Only read or write it under ledgerLock.
+	ledgerPeriod db.Period
+
+	// exclusiveRoutes are the routes whose posts swap the ledger out.
+	exclusiveRoutes = map[string]bool{}
+)
+
+// currentPeriod is the period the server has open.
+func currentPeriod() db.Period {
+	return ledgerPeriod
+}
+
+// handleExclusive registers a route whose posts need the ledger to
+// themselves.
+func handleExclusive(pattern string, handler http.HandlerFunc, methods ...string) {
+	exclusiveRoutes[pattern] = true
+	handle(pattern, handler, methods...)
+}
+
+// lockLedger holds ledgerLock for the length of each request and puts the
+// request's period on its context. It runs after requireLogin, so only
+// someone logged in can make the server open a period.
+func lockLedger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unlock := ledgerLock.RUnlock
+		if exclusiveRequest(r) {
+			ledgerLock.Lock()
+			unlock = ledgerLock.Unlock
+		} else {
+			ledgerLock.RLock()
+		}
+
+		l := db.CurrentLedger()
+		release := unlock
+		if p, viewing := viewPeriod(r); viewing && p != ledgerPeriod &&
+			!strings.HasPrefix(r.URL.Path, ApiPrefix+"/") && !exclusiveRoutes[r.URL.Path] {
+			var err error
+			if l, err = openPeriodLedger(p); err != nil {
+				unlock()
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			release = func() {
+				l.Close()
+				unlock()
+			}
+		}
+
+		release = sync.OnceFunc(release)
+		defer release()
+
+		ctx := context.WithValue(r.Context(), ledgerContextKey, l)
+		ctx = context.WithValue(ctx, releaseContextKey, release)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ledger is the period the request reads and writes.
+func ledger(r *http.Request) *db.Ledger {
+	if l, ok := r.Context().Value(ledgerContextKey).(*db.Ledger); ok {
+		return l
+	}
+	return db.CurrentLedger()
+}
+
+// exclusiveRequest is whether the request needs the ledger to itself: a post
+// to an exclusive route, or an API call that checks If-Match before writing.
+func exclusiveRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return false
+	case http.MethodPut, http.MethodDelete:
+		if strings.HasPrefix(r.URL.Path, ApiPrefix+"/") {
+			return true
+		}
+	}
+	return exclusiveRoutes[r.URL.Path]
+}
+
+// releaseLedger lets go of the ledger before the request ends, for a handler
+// that is about to wait a long time. It can't touch the ledger afterwards.
+func releaseLedger(r *http.Request) {
+	if release, ok := r.Context().Value(releaseContextKey).(func()); ok {
+		release()
+	}
+}
+
+// openPeriodLedger opens one of the earlier periods for a request.
+func openPeriodLedger(p db.Period) (*db.Ledger, error) {
+	periods, err := db.ListPeriods(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(periods, p) || ledgerPeriod.Before(p) {
+		return nil, fmt.Errorf("There is no period %s %d.", p.Month, p.Year)
+	}
+
+	return db.OpenLedger(filepath.Join(config.DataDir, p.FileName()))
+}
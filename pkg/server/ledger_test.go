@@ -0,0 +1,272 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	"tjdickerson/sacmoney/pkg/duplicates"
+)
+
+// These are meant to be run with -race as well.
+
+// pageToken is the CSRF token the pages send for the holder of cookie.
+func pageToken(cookie *http.Cookie) string {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	return csrfToken(r)
+}
+
+func createAccount(t *testing.T, handler http.Handler, cookie *http.Cookie, name string) int {
+	t.Helper()
+	w := serve(handler, cookie, http.MethodPost, ApiPrefix+"/accounts", fmt.Sprintf(`{"name":%q,"kind":"asset"}`, name))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Creating account %s: %d %s", name, w.Code, w.Body)
+	}
+
+	var account AccountResource
+	if err := json.Unmarshal(w.Body.Bytes(), &account); err != nil {
+		t.Fatal(err)
+	}
+	return account.Id
+}
+
+func saveTransaction(handler http.Handler, cookie *http.Cookie, query string, name string, amount string, date time.Time) string {
+	body, _ := json.Marshal(TransactionData{
+		Id:        "0",
+		Name:      name,
+		Amount:    amount,
+		Date:      date.Format("2006-01-02"),
+		Duplicate: duplicates.ActionKeep,
+	})
+	w := serve(handler, cookie, http.MethodPost, "/saveTransaction"+query, string(body), csrfHeaderName, pageToken(cookie))
+	return w.Body.String()
+}
+
+func periodNames(t *testing.T, p db.Period) string {
+	t.Helper()
+	transactions, err := db.FetchPeriodTransactions(config.DataDir, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, tr := range transactions {
+		names = append(names, tr.Name)
+	}
+	return strings.Join(names, "\n")
+}
+
+// TestViewingEarlierPeriods has pages reading and writing last month while
+// others use this month, and checks neither sees the other's period.
+func TestViewingEarlierPeriods(t *testing.T) {
+	handler, cookie := testServer(t)
+	account := createAccount(t, handler, cookie, "Checking")
+
+	earlier := currentPeriod()
+	if reply := saveTransaction(handler, cookie, "", "Before rollover", "-10.00", earlier.Start()); reply != "SUCCESS" {
+		t.Fatal(reply)
+	}
+
+	w := serve(handler, cookie, http.MethodPost, "/rollover", "", csrfHeaderName, pageToken(cookie))
+	if w.Body.String() != "SUCCESS" {
+		t.Fatalf("Rollover: %s", w.Body)
+	}
+	latest := currentPeriod()
+	if reply := saveTransaction(handler, cookie, "", "After rollover", "-2.50", latest.Start()); reply != "SUCCESS" {
+		t.Fatal(reply)
+	}
+
+	earlierQuery := fmt.Sprintf("?account=%d&period=%s", account, earlier.Start().Format("2006-01"))
+	latestQuery := fmt.Sprintf("?account=%d", account)
+
+	const rounds = 8
+	failures := make(chan string, rounds*5)
+	var wg sync.WaitGroup
+	for i := 0; i < rounds; i++ {
+		wg.Add(5)
+
+		go func() {
+			defer wg.Done()
+			body := serve(handler, cookie, http.MethodGet, "/"+earlierQuery, "").Body.String()
+			if !strings.Contains(body, "Before rollover") || strings.Contains(body, "After rollover") {
+				failures <- "The earlier period's page showed the wrong period"
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			body := serve(handler, cookie, http.MethodGet, "/"+latestQuery, "").Body.String()
+			if !strings.Contains(body, "After rollover") || strings.Contains(body, "Before rollover") {
+				failures <- "The latest period's page showed the wrong period"
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			w := serve(handler, cookie, http.MethodGet, fmt.Sprintf("%s/accounts/%d", ApiPrefix, account), "")
+			var resource AccountResource
+			json.Unmarshal(w.Body.Bytes(), &resource)
+			if w.Code != http.StatusOK || resource.Balance > -1250 {
+				failures <- fmt.Sprintf("The API read balance %s (%d) while a page viewed last month", resource.Balance, w.Code)
+			}
+		}()
+
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("Earlier %d", i)
+			if reply := saveTransaction(handler, cookie, earlierQuery, name, fmt.Sprintf("-%d.00", i+1), earlier.Start()); reply != "SUCCESS" {
+				failures <- fmt.Sprintf("Saving %s: %s", name, reply)
+			}
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("Latest %d", i)
+			if reply := saveTransaction(handler, cookie, latestQuery, name, fmt.Sprintf("-%d.00", i+1), latest.Start()); reply != "SUCCESS" {
+				failures <- fmt.Sprintf("Saving %s: %s", name, reply)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(failures)
+
+	for failure := range failures {
+		t.Error(failure)
+	}
+
+	earlierNames, latestNames := periodNames(t, earlier), periodNames(t, latest)
+	for i := 0; i < rounds; i++ {
+		if name := fmt.Sprintf("Earlier %d", i); !strings.Contains(earlierNames, name) || strings.Contains(latestNames, name) {
+			t.Errorf("%s wasn't saved to %s only", name, earlier.Start().Format("2006-01"))
+		}
+		if name := fmt.Sprintf("Latest %d", i); !strings.Contains(latestNames, name) || strings.Contains(earlierNames, name) {
+			t.Errorf("%s wasn't saved to %s only", name, latest.Start().Format("2006-01"))
+		}
+	}
+}
+
+// TestFailedRolloverKeepsThePeriod has the next period's file taken by a
+// directory, so the rollover can't make it. The latest period has to stay the
+// one pages and the API read and save to.
+func TestFailedRolloverKeepsThePeriod(t *testing.T) {
+	handler, cookie := testServer(t)
+	account := createAccount(t, handler, cookie, "Checking")
+
+	latest := currentPeriod()
+	blocked := filepath.Join(config.DataDir, latest.Next().FileName())
+	if err := os.Mkdir(blocked, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(handler, cookie, http.MethodPost, "/rollover", "", csrfHeaderName, pageToken(cookie))
+	if w.Body.String() == "SUCCESS" {
+		t.Fatal("Rolled over into a directory")
+	}
+	if currentPeriod() != latest {
+		t.Fatalf("The latest period moved to %v after a failed rollover", currentPeriod())
+	}
+
+	if reply := saveTransaction(handler, cookie, "", "After failed rollover", "-2.50", latest.Start()); reply != "SUCCESS" {
+		t.Fatal(reply)
+	}
+	if names := periodNames(t, latest); names != "After failed rollover" {
+		t.Errorf("The latest period holds %q", names)
+	}
+	w = serve(handler, cookie, http.MethodGet, fmt.Sprintf("%s/accounts/%d", ApiPrefix, account), "")
+	if w.Code != http.StatusOK {
+		t.Errorf("Reading the account after a failed rollover: %d %s", w.Code, w.Body)
+	}
+
+	// With the way clear the rollover goes through.
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(handler, cookie, http.MethodPost, "/rollover", "", csrfHeaderName, pageToken(cookie))
+	if w.Body.String() != "SUCCESS" {
+		t.Fatalf("Rollover: %s", w.Body)
+	}
+	if currentPeriod() != latest.Next() {
+		t.Errorf("The latest period is %v, want %v", currentPeriod(), latest.Next())
+	}
+}
+
+// TestAnonymousRequestsDontTakeTheLedger sends requests without a login
+// while the ledger is held, as it is during any other request. They have to
+// be turned away without waiting for it or learning which periods exist.
+func TestAnonymousRequestsDontTakeTheLedger(t *testing.T) {
+	handler, _ := testServer(t)
+
+	requests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/?period=2001-01", http.StatusSeeOther},
+		{http.MethodGet, "/?period=" + currentPeriod().Start().Format("2006-01"), http.StatusSeeOther},
+		{http.MethodPost, "/saveTransaction?period=2001-01", http.StatusUnauthorized},
+		{http.MethodPut, ApiPrefix + "/accounts/1", http.StatusUnauthorized},
+		{http.MethodPost, "/rollover", http.StatusUnauthorized},
+	}
+
+	ledgerLock.RLock()
+	defer ledgerLock.RUnlock()
+
+	for _, request := range requests {
+		done := make(chan int)
+		go func() {
+			done <- serve(handler, nil, request.method, request.target, "{}").Code
+		}()
+
+		select {
+		case code := <-done:
+			if code != request.status {
+				t.Errorf("%s %s answered %d, want %d", request.method, request.target, code, request.status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s %s waited for the ledger", request.method, request.target)
+		}
+	}
+}
+
+// TestConcurrentUpdatesNeedTheLatestEtag sends the same If-Match from
+// several clients at once. Only the first update can match.
+func TestConcurrentUpdatesNeedTheLatestEtag(t *testing.T) {
+	handler, cookie := testServer(t)
+	account := createAccount(t, handler, cookie, "Checking")
+	target := fmt.Sprintf("%s/accounts/%d", ApiPrefix, account)
+
+	etag := serve(handler, cookie, http.MethodGet, target, "").Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("No ETag")
+	}
+
+	const clients = 8
+	codes := make(chan int, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"name":"Renamed %d","kind":"asset"}`, i)
+			codes <- serve(handler, cookie, http.MethodPut, target, body, "If-Match", etag).Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusPreconditionFailed] != clients-1 {
+		t.Errorf("Got %v, want one %d and the rest %d", counts, http.StatusOK, http.StatusPreconditionFailed)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+type NetWorthAccount struct {
+	Name    string
+	Kind    string
+	Balance string
+	IsNeg   bool
+}
+
+type NetWorthRow struct {
+	Label       string
+	Assets      string
+	Liabilities string
+	Total       string
+	IsNeg       bool
+}
+
+type NetWorthMain struct {
+	Date        string
+	From        string
+	To          string
+	Accounts    []NetWorthAccount
+	Assets      string
+	Liabilities string
+	Total       string
+	TotalClass  string
+	History     []NetWorthRow
+	Error       string
+}
+
+type netWorthJson struct {
+	AsOf        string               `json:"asOf"`
+	Assets      string               `json:"assets"`
+	Liabilities string               `json:"liabilities"`
+	Total       string               `json:"total"`
+	Accounts    []netWorthAccountRow `json:"accounts"`
+	History     []netWorthMonthRow   `json:"history"`
+}
+
+type netWorthAccountRow struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Balance string `json:"balance"`
+}
+
+type netWorthMonthRow struct {
+	Month       string `json:"month"`
+	Assets      string `json:"assets"`
+	Liabilities string `json:"liabilities"`
+	Total       string `json:"total"`
+}
+
+func parseMonth(value string, fallback db.Period) (db.Period, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return fallback, nil
+	}
+
+	t, err := time.Parse("2006-01", strings.TrimSpace(value))
+	if err != nil {
+		return fallback, fmt.Errorf("Invalid month %s, expected yyyy-mm.", value)
+	}
+
+	return db.PeriodOf(t), nil
+}
+
+func NetWorthHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := NetWorthMain{}
+
+	asOf := time.Now().UTC()
+	if len(query.Get("date")) > 0 {
+		t, err := time.Parse("2006-01-02", query.Get("date"))
+		if err != nil {
+			data.Error = fmt.Sprintf("Invalid date %s, expected yyyy-mm-dd.", query.Get("date"))
+		} else {
+			asOf = t
+		}
+	}
+
+	current := db.PeriodOf(asOf)
+	last, err := parseMonth(query.Get("to"), current)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+
+	first, err := parseMonth(query.Get("from"), db.PeriodOf(last.Start().AddDate(0, -11, 0)))
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+
+	accounts := accountsWith(r, db.RoleViewer)
+	nw, err := db.NetWorthAsOf(config.DataDir, asOf.AddDate(0, 0, 1), accounts)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		log.Println(data.Error)
+	}
+
+	history, err := db.NetWorthHistory(config.DataDir, first, last, accounts)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		log.Println(data.Error)
+	}
+
+	if query.Get("format") == "json" {
+		if len(data.Error) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, data.Error)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		out := netWorthJson{
+			AsOf:        asOf.Format("2006-01-02"),
+			Assets:      nw.Assets.String(),
+			Liabilities: nw.Liabilities.String(),
+			Total:       nw.Total.String(),
+			Accounts:    []netWorthAccountRow{},
+			History:     []netWorthMonthRow{},
+		}
+		for _, a := range nw.Accounts {
+			out.Accounts = append(out.Accounts, netWorthAccountRow{
+				Id:      a.Id,
+				Name:    a.Name,
+				Kind:    a.Kind,
+				Balance: a.TotalAvailable.String(),
+			})
+		}
+		for _, m := range history {
+			out.History = append(out.History, netWorthMonthRow{
+				Month:       fmt.Sprintf("%d-%02d", m.Period.Year, int(m.Period.Month)),
+				Assets:      m.Assets.String(),
+				Liabilities: m.Liabilities.String(),
+				Total:       m.Total.String(),
+			})
+		}
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+
+	data.Date = asOf.Format("2006-01-02")
+	data.From = fmt.Sprintf("%d-%02d", first.Year, int(first.Month))
+	data.To = fmt.Sprintf("%d-%02d", last.Year, int(last.Month))
+	data.Assets = nw.Assets.String()
+	data.Liabilities = nw.Liabilities.String()
+	data.Total = nw.Total.String()
+	data.TotalClass = "pos"
+	if nw.Total < 0 {
+		data.TotalClass = "neg"
+	}
+
+	for _, a := range nw.Accounts {
+		data.Accounts = append(data.Accounts, NetWorthAccount{
+			Name:    a.Name,
+			Kind:    a.Kind,
+			Balance: a.TotalAvailable.String(),
+			IsNeg:   a.TotalAvailable < 0,
+		})
+	}
+
+	for _, m := range history {
+		data.History = append(data.History, NetWorthRow{
+			Label:       fmt.Sprintf("%s %d", m.Period.Month, m.Period.Year),
+			Assets:      m.Assets.String(),
+			Liabilities: m.Liabilities.String(),
+			Total:       m.Total.String(),
+			IsNeg:       m.Total < 0,
+		})
+	}
+
+	renderPage(w, r, "networth/networth_main_tmpl.html", data)
+}
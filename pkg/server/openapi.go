@@ -0,0 +1,394 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The OpenAPI document is built from apiDocs and the Go types named in it, so
+// a field added to a resource shows up without touching the document. Run
+// checks apiDocs against the routes it registered and refuses to start when
+// a JSON endpoint is missing from it, or it describes one that isn't served.
+
+// apiOperation documents one method on one path. Body and Response are
+// values of the types sent and returned; a nil Response on a form endpoint
+// means it answers in plain text.
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Query       []apiParam
+	Body        any
+	Response    any
+	Status      int
+	Description string
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// pageRoutes are the routes that serve HTML pages, downloads, the event
+// stream or static files and so are deliberately left out of the document.
+var pageRoutes = map[string]bool{
+	"/static/":      true,
+	"/":             true,
+	"/recurrings":   true,
+	"/accounts":     true,
+	"/rules":        true,
+	"/webhooks":     true,
+	"/reports":      true,
+	"/networth":     true,
+	"/import":       true,
+	"/export":       true,
+	"/backup":       true,
+	"/snapshots":    true,
+	"/login":        true,
+	"/logout":       true,
+	"/setup":        true,
+	"/users":        true,
+	"/events":       true,
+	ApiPrefix + "/": true,
+}
+
+const pageEndpointDescription = "Used by the pages. The body is JSON, the page's CSRF token goes in the X-CSRF-Token header, and the answer is plain text: SUCCESS, or a message saying what went wrong."
+
+var accountFilter = apiParam{"account", "Only this account's entries."}
+
+// Who may write what. Anything on an account you can't see answers 404.
+const (
+	editorOnly = "Needs editor or owner on the account, or answers 403."
+	ownerOnly  = "Needs owner on the account, or answers 403."
+	adminOnly  = "Admins only, or answers 403."
+)
+
+var apiDocs = []apiOperation{
+	{Method: http.MethodPost, Path: "/saveTransaction", Summary: "Add or edit a transaction",
+		Body: TransactionData{}, Status: http.StatusOK,
+		Description: pageEndpointDescription + " Amount is in dollars, negative for a debit; IsNeg is only for showing and is ignored. Id 0 adds a new transaction on Date to the account the pages show, and any other Id changes that transaction's name and amount. Needs editor on the account."},
+	{Method: http.MethodPost, Path: "/deleteTransaction", Summary: "Delete a transaction",
+		Body: TransactionData{}, Status: http.StatusOK, Description: pageEndpointDescription + " Only Id is read."},
+	{Method: http.MethodPost, Path: "/saveRecurring", Summary: "Add or edit a recurring transaction",
+		Body: RecurringData{}, Status: http.StatusOK, Description: pageEndpointDescription + " Day is 1-28."},
+	{Method: http.MethodPost, Path: "/deleteRecurring", Summary: "Delete a recurring transaction",
+		Body: RecurringData{}, Status: http.StatusOK, Description: pageEndpointDescription + " Only Id is read."},
+	{Method: http.MethodPost, Path: "/applyRecurring", Summary: "Add a recurring transaction to this month",
+		Body: ApplyRecurringData{}, Status: http.StatusOK, Description: pageEndpointDescription},
+	{Method: http.MethodPost, Path: "/addAccount", Summary: "Add an account",
+		Body: AccountData{}, Status: http.StatusOK, Description: pageEndpointDescription + " Whoever adds it becomes its owner."},
+	{Method: http.MethodPost, Path: "/rollover", Summary: "Start next month",
+		Status: http.StatusOK, Description: pageEndpointDescription + " Admins only. Takes a snapshot first and carries every balance forward."},
+	{Method: http.MethodPost, Path: "/undoRollover", Summary: "Go back to last month",
+		Body: UndoRolloverData{}, Status: http.StatusOK,
+		Description: pageEndpointDescription + " Admins only. Takes a snapshot first and removes this month. When it already has transactions the answer starts with CONFIRM: and nothing changes until it is sent again with Confirm set."},
+	{Method: http.MethodPost, Path: "/carryBalances", Summary: "Carry an earlier month's balances forward",
+		Query: []apiParam{{"period", "The edited month, yyyy-mm."}}, Status: http.StatusOK,
+		Description: pageEndpointDescription + " Admins only. Sets the starting balance of every later month to how the month before it ended."},
+
+	{Method: http.MethodGet, Path: ApiPrefix + "/openapi.json", Summary: "This document",
+		Response: map[string]any{}, Status: http.StatusOK},
+
+	{Method: http.MethodGet, Path: ApiPrefix + "/accounts", Summary: "List the accounts you can see",
+		Response: []AccountResource{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: ApiPrefix + "/accounts", Summary: "Add an account",
+		Body: AccountResource{}, Response: AccountResource{}, Status: http.StatusCreated,
+		Description: "You become the account's owner."},
+	{Method: http.MethodGet, Path: ApiPrefix + "/accounts/{id}", Summary: "Get an account",
+		Response: AccountResource{}, Status: http.StatusOK},
+	{Method: http.MethodPut, Path: ApiPrefix + "/accounts/{id}", Summary: "Rename an account or change its kind",
+		Body: AccountResource{}, Response: AccountResource{}, Status: http.StatusOK, Description: ownerOnly},
+	{Method: http.MethodDelete, Path: ApiPrefix + "/accounts/{id}", Summary: "Delete an unused account",
+		Status: http.StatusNoContent, Description: ownerOnly},
+
+	{Method: http.MethodGet, Path: ApiPrefix + "/transactions", Summary: "List transactions",
+		Query: []apiParam{
+			accountFilter,
+			{"from", "First day to include, yyyy-mm-dd. With from or to, every period is searched instead of only the current one."},
+			{"to", "Last day to include, yyyy-mm-dd."},
+		},
+		Response: []TransactionResource{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: ApiPrefix + "/transactions", Summary: "Add a transaction to the current period",
+		Body: TransactionResource{}, Response: TransactionResource{}, Status: http.StatusCreated, Description: editorOnly},
+	{Method: http.MethodGet, Path: ApiPrefix + "/transactions/{id}", Summary: "Get a transaction",
+		Response: TransactionResource{}, Status: http.StatusOK},
+	{Method: http.MethodPut, Path: ApiPrefix + "/transactions/{id}", Summary: "Replace a transaction",
+		Body: TransactionResource{}, Response: TransactionResource{}, Status: http.StatusOK, Description: editorOnly},
+	{Method: http.MethodDelete, Path: ApiPrefix + "/transactions/{id}", Summary: "Delete a transaction",
+		Status: http.StatusNoContent, Description: editorOnly},
+
+	{Method: http.MethodGet, Path: ApiPrefix + "/recurrings", Summary: "List recurring transactions",
+		Query: []apiParam{accountFilter}, Response: []RecurringResource{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: ApiPrefix + "/recurrings", Summary: "Add a recurring transaction",
+		Body: RecurringResource{}, Response: RecurringResource{}, Status: http.StatusCreated, Description: editorOnly},
+	{Method: http.MethodGet, Path: ApiPrefix + "/recurrings/{id}", Summary: "Get a recurring transaction",
+		Response: RecurringResource{}, Status: http.StatusOK},
+	{Method: http.MethodPut, Path: ApiPrefix + "/recurrings/{id}", Summary: "Replace a recurring transaction",
+		Body: RecurringResource{}, Response: RecurringResource{}, Status: http.StatusOK, Description: editorOnly},
+	{Method: http.MethodDelete, Path: ApiPrefix + "/recurrings/{id}", Summary: "Delete a recurring transaction",
+		Status: http.StatusNoContent, Description: editorOnly},
+
+	{Method: http.MethodGet, Path: ApiPrefix + "/categories", Summary: "List categories",
+		Response: []CategoryResource{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: ApiPrefix + "/categories", Summary: "Add a category",
+		Body: CategoryResource{}, Response: CategoryResource{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: ApiPrefix + "/categories/{id}", Summary: "Get a category",
+		Response: CategoryResource{}, Status: http.StatusOK},
+	{Method: http.MethodPut, Path: ApiPrefix + "/categories/{id}", Summary: "Rename a category",
+		Body: CategoryResource{}, Response: CategoryResource{}, Status: http.StatusOK, Description: adminOnly},
+	{Method: http.MethodDelete, Path: ApiPrefix + "/categories/{id}", Summary: "Delete a category and clear it from transactions",
+		Status: http.StatusNoContent, Description: adminOnly},
+
+	{Method: http.MethodGet, Path: ApiPrefix + "/periods", Summary: "List periods",
+		Response: []PeriodResource{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: ApiPrefix + "/periods/{period}", Summary: "Get a period",
+		Response: PeriodResource{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: ApiPrefix + "/periods/{period}/transactions", Summary: "List a period's transactions",
+		Query: []apiParam{accountFilter}, Response: []TransactionResource{}, Status: http.StatusOK},
+}
+
+var (
+	apiDocumentOnce sync.Once
+	apiDocumentBody []byte
+	apiDocumentErr  error
+)
+
+func ApiDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	apiDocumentOnce.Do(func() {
+		apiDocumentBody, apiDocumentErr = json.MarshalIndent(buildApiDocument(), "", "  ")
+	})
+
+	if apiDocumentErr != nil {
+		apiInternalError(w, apiDocumentErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(apiDocumentBody)
+}
+
+// buildApiDocument turns apiDocs into an OpenAPI 3 document.
+func buildApiDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+
+	for _, op := range apiDocs {
+		item, ok := paths[op.Path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"operationId": operationId(op),
+		}
+		if len(op.Description) > 0 {
+			operation["description"] = op.Description
+		}
+
+		var params []any
+		for _, name := range pathParams(op.Path) {
+			schema := map[string]any{"type": "integer"}
+			if name == "period" {
+				schema = map[string]any{"type": "string", "pattern": `^\d{4}-\d{2}$`}
+			}
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": schema})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]any{"type": "string"}})
+		}
+
+		api := strings.HasPrefix(op.Path, ApiPrefix)
+		if api && (op.Method == http.MethodPut || op.Method == http.MethodDelete) {
+			param := map[string]any{"name": "If-Match", "in": "header", "description": "The ETag from your last read.", "schema": map[string]any{"type": "string"}}
+			if op.Method == http.MethodPut {
+				param["required"] = true
+			}
+			params = append(params, param)
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if op.Body != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.Body), schemas)}},
+			}
+		}
+
+		response := map[string]any{"description": http.StatusText(op.Status)}
+		switch {
+		case op.Response != nil:
+			schema := schemaOf(reflect.TypeOf(op.Response), schemas)
+			if reflect.TypeOf(op.Response).Kind() == reflect.Slice {
+				schema = map[string]any{
+					"type":       "object",
+					"required":   []string{"items"},
+					"properties": map[string]any{"items": schema},
+				}
+			}
+			response["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+		case !api:
+			response["content"] = map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+
+		responses := map[string]any{fmt.Sprint(op.Status): response}
+		if api {
+			responses["default"] = map[string]any{
+				"description": "An error.",
+				"content":     map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(ApiErrorBody{}), schemas)}},
+			}
+		}
+		operation["responses"] = responses
+
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "sacmoney",
+			"version":     "1",
+			"description": "Amounts in the API are whole cents and dates are yyyy-mm-dd. Every endpoint needs the session cookie set by logging in at /login, and only shows the accounts you have a role on.",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+func operationId(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.Split(strings.TrimPrefix(op.Path, ApiPrefix), "/") {
+		part = strings.Trim(part, "{}")
+		if len(part) > 0 {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.TrimSuffix(id, ".json")
+}
+
+func pathParams(path string) []string {
+	var names []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			names = append(names, strings.Trim(part, "{}"))
+		}
+	}
+	return names
+}
+
+// schemaOf describes t, adding named structs to schemas and referring to
+// them by name.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+
+		// Claim the name before walking the fields so a type that contains
+		// itself doesn't recurse forever.
+		properties := map[string]any{}
+		schemas[t.Name()] = map[string]any{"type": "object", "properties": properties}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := field.Name
+			if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == "-" {
+				continue
+			} else if len(tag) > 0 {
+				name = tag
+			}
+			properties[name] = schemaOf(field.Type, schemas)
+		}
+		return ref
+	}
+
+	return map[string]any{}
+}
+
+// checkApiDocument compares apiDocs with the routes Run registered. Every
+// route has to be documented or listed in pageRoutes, every documented path
+// has to be served, and each API path has to document exactly the methods it
+// answers. The pages' own endpoints have to answer the method documented.
+func checkApiDocument() error {
+	documented := map[string]map[string]bool{}
+	for _, op := range apiDocs {
+		if documented[op.Path] == nil {
+			documented[op.Path] = map[string]bool{}
+		}
+		if documented[op.Path][op.Method] {
+			return fmt.Errorf("The API document lists %s %s twice.", op.Method, op.Path)
+		}
+		documented[op.Path][op.Method] = true
+	}
+
+	var problems []string
+	registered := map[string]bool{}
+	for _, pattern := range registeredRoutes {
+		registered[pattern] = true
+		if documented[pattern] == nil && !pageRoutes[pattern] {
+			problems = append(problems, fmt.Sprintf("%s is served but not in the API document", pattern))
+		}
+	}
+
+	for path := range documented {
+		if !registered[path] && (config.Enabled(FeatureApi) || !strings.HasPrefix(path, ApiPrefix+"/")) {
+			problems = append(problems, fmt.Sprintf("%s is in the API document but not served", path))
+		}
+
+		if allowed := routeMethods[path]; len(allowed) > 0 {
+			for method := range documented[path] {
+				if !slices.Contains(allowed, method) {
+					problems = append(problems, fmt.Sprintf("%s %s is in the API document but not served", method, path))
+				}
+			}
+		}
+	}
+
+	for _, route := range apiRoutes {
+		methods := documented[ApiPrefix+route.Path]
+		for method := range route.Methods {
+			if !methods[method] {
+				problems = append(problems, fmt.Sprintf("%s %s%s is served but not in the API document", method, ApiPrefix, route.Path))
+			}
+		}
+		for method := range methods {
+			if _, ok := route.Methods[method]; !ok {
+				problems = append(problems, fmt.Sprintf("%s %s%s is in the API document but not served", method, ApiPrefix, route.Path))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("The API document is out of date: %s.", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// samplePath fills in a documented path's parameters so it can be routed.
+func samplePath(path string) string {
+	path = strings.ReplaceAll(path, "{id}", "1")
+	return strings.ReplaceAll(path, "{period}", "2026-10")
+}
+
+func TestApiDocumentMatchesRoutes(t *testing.T) {
+	defer func() { config = DefaultConfig() }()
+
+	for _, disable := range [][]string{nil, {FeatureApi}} {
+		config = DefaultConfig()
+		config.Disable = disable
+		apiOn := config.Enabled(FeatureApi)
+
+		routes(http.Dir(t.TempDir()))
+		if err := checkApiDocument(); err != nil {
+			t.Errorf("API on %v: %s", apiOn, err)
+		}
+
+		documented := map[string]bool{}
+		for _, op := range apiDocs {
+			documented[op.Path] = true
+
+			isApi := strings.HasPrefix(op.Path, ApiPrefix+"/")
+			if isApi && !apiOn {
+				continue
+			}
+
+			_, pattern := mux.Handler(httptest.NewRequest(op.Method, samplePath(op.Path), nil))
+			if pattern != op.Path {
+				t.Errorf("API on %v: %s %s is served by %q", apiOn, op.Method, op.Path, pattern)
+				continue
+			}
+
+			if methods := routeMethods[pattern]; len(methods) > 0 && !slices.Contains(methods, op.Method) {
+				t.Errorf("API on %v: %s only answers %v, not %s", apiOn, op.Path, methods, op.Method)
+			}
+		}
+
+		for _, pattern := range registeredRoutes {
+			if !documented[pattern] && !pageRoutes[pattern] {
+				t.Errorf("API on %v: %s is served but not documented", apiOn, pattern)
+			}
+		}
+	}
+
+	for _, route := range apiRoutes {
+		for method := range route.Methods {
+			if !slices.ContainsFunc(apiDocs, func(op apiOperation) bool {
+				return op.Method == method && op.Path == ApiPrefix+route.Path
+			}) {
+				t.Errorf("%s %s%s is served but not documented", method, ApiPrefix, route.Path)
+			}
+		}
+	}
+}
+
+func TestApiDocumentCheckFindsDrift(t *testing.T) {
+	saved := apiDocs
+	defer func() { apiDocs = saved }()
+
+	routes(http.Dir(t.TempDir()))
+
+	changes := map[string][]apiOperation{
+		"undocumented route": saved[1:],
+		"unserved path":      append(slices.Clone(saved), apiOperation{Method: http.MethodPost, Path: "/nowhere"}),
+		"unserved method":    append(slices.Clone(saved), apiOperation{Method: http.MethodPatch, Path: ApiPrefix + "/accounts/{id}"}),
+		"listed twice":       append(slices.Clone(saved), saved[0]),
+	}
+
+	for name, docs := range changes {
+		apiDocs = docs
+		if err := checkApiDocument(); err == nil {
+			t.Errorf("%s wasn't noticed", name)
+		}
+	}
+}
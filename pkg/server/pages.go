@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	sacmoney "tjdickerson/sacmoney"
+)
+
+// The templates and static files are embedded in the binary. The templates
+// are parsed once when the server starts, so a broken one stops it there
+// rather than on some later request. The templates and static settings point
+// at directories to use instead, and dev mode reads both from disk on every
+// request so edits show up on reload.
+
+// pageNames are the pages, by their path under templates/. Each is parsed
+// with the shared title bar.
+var pageNames = []string{
+	"transactions/trans_main_tmpl.html",
+	"recurrings/recurr_main_tmpl.html",
+	"accounts/accounts_main_tmpl.html",
+	"rules/rules_main_tmpl.html",
+	"webhooks/webhooks_main_tmpl.html",
+	"reports/reports_main_tmpl.html",
+	"networth/networth_main_tmpl.html",
+	"import/import_main_tmpl.html",
+	"snapshots/snapshots_main_tmpl.html",
+	"users/users_main_tmpl.html",
+	"login/login_tmpl.html",
+}
+
+const titleTemplate = "core/title_tmpl.html"
+
+// devDirectory is where dev mode reads templates and static files from when
+// they aren't set, which is where they are in the source tree.
+const (
+	devTemplates = "templates"
+	devStatic    = "static"
+)
+
+// pages is every page, parsed by loadPages. The templates in it are never
+// executed, only clones of them, so they can be cloned again.
+var pages map[string]*template.Template
+
+func templateFS() (fs.FS, error) {
+	switch {
+	case len(config.Templates) > 0:
+		return os.DirFS(config.Templates), nil
+	case config.Dev:
+		return os.DirFS(devTemplates), nil
+	}
+	return fs.Sub(sacmoney.Templates, "templates")
+}
+
+func staticFS() (http.FileSystem, error) {
+	switch {
+	case len(config.Static) > 0:
+		return http.Dir(config.Static), nil
+	case config.Dev:
+		return http.Dir(devStatic), nil
+	}
+
+	sub, err := fs.Sub(sacmoney.Static, "static")
+	if err != nil {
+		return nil, err
+	}
+	return http.FS(sub), nil
+}
+
+// pageFuncs are the functions every page can call. The csrf token and the
+// view belong to the request, so pages are parsed with placeholders and get
+// the request's functions when they are rendered.
+func pageFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string {
+			if r == nil {
+				return ""
+			}
+			return csrfToken(r)
+		},
+		"viewQuery": func() string {
+			if r == nil {
+				return ""
+			}
+			return viewQuery(r)
+		},
+	}
+}
+
+func parsePage(fsys fs.FS, name string) (*template.Template, error) {
+	t, err := template.New(path.Base(name)).Funcs(pageFuncs(nil)).ParseFS(fsys, name, titleTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing template %s: %s", name, err)
+	}
+	return t, nil
+}
+
+// loadPages parses every page.
+func loadPages() error {
+	fsys, err := templateFS()
+	if err != nil {
+		return fmt.Errorf("Error opening templates: %s", err)
+	}
+
+	parsed := map[string]*template.Template{}
+	for _, name := range pageNames {
+		if parsed[name], err = parsePage(fsys, name); err != nil {
+			return err
+		}
+	}
+
+	pages = parsed
+	return nil
+}
+
+func pageTemplate(name string) (*template.Template, error) {
+	if config.Dev {
+		fsys, err := templateFS()
+		if err != nil {
+			return nil, fmt.Errorf("Error opening templates: %s", err)
+		}
+		return parsePage(fsys, name)
+	}
+
+	t, ok := pages[name]
+	if !ok {
+		return nil, fmt.Errorf("There is no page %s.", name)
+	}
+	return t.Clone()
+}
+
+// renderPage writes the named page filled in with data. A page that can't be
+// rendered answers 500 rather than half a page.
+func renderPage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	t, err := pageTemplate(name)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		http.Error(w, "Error loading page.", http.StatusInternalServerError)
+		return
+	}
+
+	var outHtml bytes.Buffer
+	if err = t.Funcs(pageFuncs(r)).Execute(&outHtml, data); err != nil {
+		log.Printf("Error rendering %s: %s\n", name, err)
+		http.Error(w, "Error loading page.", http.StatusInternalServerError)
+		return
+	}
+
+	io.WriteString(w, outHtml.String())
+}
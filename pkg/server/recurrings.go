@@ -1,11 +1,8 @@
 package server
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"html"
-	"html/template"
 	"io"
 	"log"
 	"net/http"
@@ -27,6 +24,7 @@ type RecurringMain struct {
 	AccountName           string
 	RecurringTransactions []RecurringData
 	Net                   string
+	CanEdit               bool
 	Error                 string
 }
 
@@ -35,21 +33,24 @@ func convertRecurring(r *db.Recurring) RecurringData {
 		Id:     strconv.Itoa(r.Id),
 		Name:   r.Name,
 		Day:    strconv.Itoa(int(r.Day)),
-		Amount: fmt.Sprintf("%.2f", float32(r.Amount)*float32(0.01)),
+		Amount: r.Amount.String(),
 		IsNeg:  r.Amount < 0,
 	}
 }
 
 func (r *RecurringData) toDbRecurring() (db.Recurring, error) {
 	name := html.EscapeString(strings.TrimSpace(r.Name))
-	amount := utils.GetCentsFromString(r.Amount)
-
 	var outErr string = ""
 	id, err := strconv.Atoi(r.Id)
 	if err != nil {
 		outErr = outErr + "Error reading id. "
 	}
 
+	amount, err := utils.ParseMoney(r.Amount)
+	if err != nil {
+		outErr = outErr + fmt.Sprintf("%s ", err)
+	}
+
 	day, err := strconv.Atoi(r.Day)
 	if err != nil {
 		outErr = outErr + "Day of occurrence required. "
@@ -76,22 +77,22 @@ func (r *RecurringData) toDbRecurring() (db.Recurring, error) {
 }
 
 func RecurringMainHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles(
-		"templates/recurrings/recurr_main_tmpl.html",
-		"templates/core/title_tmpl.html")
-
+	account, role, err := userAccount(r)
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error parsing template: %s", err))
+		log.Printf("Error: %s\n", err)
 	}
 
-	if servctx.currentAccount == nil {
-		handleNoAccount(w, t)
+	if account == nil {
+		renderPage(w, r, "recurrings/recurr_main_tmpl.html", RecurringMain{
+			AccountName: "No account, click on accounts at top.",
+			Net:         "0",
+		})
 		return
 	}
 
 	outError := ""
-	accountName := servctx.currentAccount.Name
-	recurrings, err := db.FetchAllRecurrings()
+	accountName := account.Name
+	recurrings, err := ledger(r).FetchRecurringsFor(account.Id)
 	if err != nil {
 		outError = fmt.Sprintf("%s", err)
 		log.Println(outError)
@@ -102,7 +103,7 @@ func RecurringMainHandler(w http.ResponseWriter, r *http.Request) {
 		recurringData = append(recurringData, convertRecurring(&dbRecurr))
 	}
 
-	net, err := db.GetRecurringNetBalance()
+	net, err := ledger(r).GetRecurringNetBalanceFor(account.Id)
 	if err != nil {
 		outError = fmt.Sprintf("%s<br />%s", outError, err)
 		net = 0
@@ -111,18 +112,17 @@ func RecurringMainHandler(w http.ResponseWriter, r *http.Request) {
 	data := RecurringMain{
 		AccountName:           accountName,
 		RecurringTransactions: recurringData,
-		Net:                   fmt.Sprintf("%.2f", float32(net)*float32(0.01)),
+		Net:                   net.String(),
+		CanEdit:               role.AtLeast(db.RoleEditor),
 		Error:                 outError,
 	}
 
-	var outHtml bytes.Buffer
-	t.Execute(&outHtml, data)
-	io.WriteString(w, outHtml.String())
+	renderPage(w, r, "recurrings/recurr_main_tmpl.html", data)
 }
 
 func SaveRecurringHandler(w http.ResponseWriter, r *http.Request) {
 	var data RecurringData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodePost(w, r, &data)
 	if err != nil {
 		outErr := fmt.Sprintf("Failed to decode recurring transaction: %s", err)
 		log.Printf("Error: %s\n", outErr)
@@ -138,10 +138,30 @@ func SaveRecurringHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if recurring.Id == 0 {
-		err = db.Insert(&recurring)
+	created := recurring.Id == 0
+	if created {
+		account, role, accountErr := userAccount(r)
+		if accountErr != nil {
+			log.Printf("Error: %s\n", accountErr)
+		}
+		if account == nil || !role.AtLeast(db.RoleEditor) {
+			forbid(w)
+			return
+		}
+
+		recurring.AccountId = account.Id
+		err = ledger(r).Insert(&recurring)
 	} else {
-		err = db.Update(&recurring)
+		var saved *db.Recurring
+		if saved, err = editableRecurring(r, recurring.Id); err == nil {
+			recurring.AccountId = saved.AccountId
+			err = ledger(r).Update(&recurring)
+		}
+	}
+
+	if err == errNoPermission {
+		forbid(w)
+		return
 	}
 
 	if err != nil {
@@ -151,12 +171,17 @@ func SaveRecurringHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if created {
+		publish(r, EventRecurringCreated, recurring.AccountId, recurring.Id)
+	} else {
+		publish(r, EventRecurringUpdated, recurring.AccountId, recurring.Id)
+	}
 	io.WriteString(w, "SUCCESS")
 }
 
 func DeleteRecurringHandler(w http.ResponseWriter, r *http.Request) {
 	var data RecurringData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodePost(w, r, &data)
 
 	if err != nil {
 		outErr := fmt.Sprintf("Failed to decode recurring transaction: %s", err)
@@ -173,8 +198,14 @@ func DeleteRecurringHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	temp := db.Recurring{Id: id}
-	err = db.Delete(&temp)
+	saved, err := editableRecurring(r, id)
+	if err == errNoPermission {
+		forbid(w)
+		return
+	}
+	if err == nil {
+		err = ledger(r).Delete(saved)
+	}
 	if err != nil {
 		outErr := fmt.Sprintf("Error deleting recurring transaction: %s", err)
 		log.Printf("Error: %s\n", outErr)
@@ -182,6 +213,25 @@ func DeleteRecurringHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	RefreshAccount()
+	publish(r, EventRecurringDeleted, saved.AccountId, saved.Id)
 	io.WriteString(w, "SUCCESS")
 }
+
+// editableRecurring is the recurring transaction with the id when the user
+// may change it, or errNoPermission.
+func editableRecurring(r *http.Request, id int) (*db.Recurring, error) {
+	roles, err := userRoles(r)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := ledger(r).GetRecurring(id)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil || !roles.Can(saved.AccountId, db.RoleEditor) {
+		return nil, errNoPermission
+	}
+
+	return saved, nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	reports "tjdickerson/sacmoney/pkg/reports"
+)
+
+const defaultReportTop = 10
+
+type ReportMonth struct {
+	Label   string
+	Income  string
+	Expense string
+	Net     string
+	IsNeg   bool
+}
+
+type ReportBreakdown struct {
+	Label   string
+	Total   string
+	Count   string
+	Average string
+}
+
+type ReportMain struct {
+	From                  string
+	To                    string
+	TotalIncome           string
+	TotalExpense          string
+	Net                   string
+	NetClass              string
+	AverageMonthlyIncome  string
+	AverageMonthlyExpense string
+	AverageExpense        string
+	Months                []ReportMonth
+	ByCategory            []ReportBreakdown
+	ByPayee               []ReportBreakdown
+	TopExpenses           []TransactionData
+	TopIncome             []TransactionData
+	Error                 string
+}
+
+func convertBreakdowns(breakdowns []reports.Breakdown) []ReportBreakdown {
+	results := []ReportBreakdown{}
+	for _, b := range breakdowns {
+		results = append(results, ReportBreakdown{
+			Label:   b.Label,
+			Total:   b.Total.String(),
+			Count:   strconv.Itoa(b.Count),
+			Average: b.Average.String(),
+		})
+	}
+	return results
+}
+
+func ReportsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := reports.ParseRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		writeReportError(w, r, query.Get("format"), err)
+		return
+	}
+
+	top := defaultReportTop
+	if len(query.Get("top")) > 0 {
+		top, err = strconv.Atoi(query.Get("top"))
+		if err != nil || top < 0 {
+			writeReportError(w, r, query.Get("format"), fmt.Errorf("Invalid number of top transactions: %s", query.Get("top")))
+			return
+		}
+	}
+
+	report, err := reports.Build(config.DataDir, from, to, top, accountsWith(r, db.RoleViewer))
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		writeReportError(w, r, query.Get("format"), err)
+		return
+	}
+
+	switch query.Get("format") {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err = report.WriteJSON(w); err != nil {
+			log.Printf("Error writing report: %s\n", err)
+		}
+		return
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sacmoney-report-%s-%s.csv\"",
+			from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+		if err = report.WriteCSV(w); err != nil {
+			log.Printf("Error writing report: %s\n", err)
+		}
+		return
+	}
+
+	renderReport(w, r, reportMain(&report, ""))
+}
+
+func reportMain(report *reports.Report, outError string) ReportMain {
+	data := ReportMain{
+		From:                  report.From.Format("2006-01-02"),
+		To:                    report.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		TotalIncome:           report.TotalIncome.String(),
+		TotalExpense:          report.TotalExpense.String(),
+		Net:                   report.Net.String(),
+		NetClass:              "pos",
+		AverageMonthlyIncome:  report.AverageMonthlyIncome.String(),
+		AverageMonthlyExpense: report.AverageMonthlyExpense.String(),
+		AverageExpense:        report.AverageExpense.String(),
+		ByCategory:            convertBreakdowns(report.ByCategory),
+		ByPayee:               convertBreakdowns(report.ByPayee),
+		TopExpenses:           []TransactionData{},
+		TopIncome:             []TransactionData{},
+		Error:                 outError,
+	}
+
+	if report.Net < 0 {
+		data.NetClass = "neg"
+	}
+
+	for _, m := range report.Months {
+		data.Months = append(data.Months, ReportMonth{
+			Label:   fmt.Sprintf("%s %d", m.Month, m.Year),
+			Income:  m.Income.String(),
+			Expense: m.Expense.String(),
+			Net:     m.Net.String(),
+			IsNeg:   m.Net < 0,
+		})
+	}
+
+	for _, t := range report.TopExpenses {
+		data.TopExpenses = append(data.TopExpenses, convertTransaction(&t, nil))
+	}
+
+	for _, t := range report.TopIncome {
+		data.TopIncome = append(data.TopIncome, convertTransaction(&t, nil))
+	}
+
+	return data
+}
+
+func writeReportError(w http.ResponseWriter, r *http.Request, format string, err error) {
+	if format == "json" || format == "csv" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
+	}
+
+	now := time.Now()
+	renderReport(w, r, ReportMain{
+		From:  now.Format("2006-01-02"),
+		To:    now.Format("2006-01-02"),
+		Error: fmt.Sprintf("%s", err),
+	})
+}
+
+func renderReport(w http.ResponseWriter, r *http.Request, data ReportMain) {
+	renderPage(w, r, "reports/reports_main_tmpl.html", data)
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+	reports "tjdickerson/sacmoney/pkg/reports"
+	rules "tjdickerson/sacmoney/pkg/rules"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+type RuleData struct {
+	Id          string
+	Name        string
+	Position    string
+	Enabled     bool
+	Pattern     string
+	IsRegex     bool
+	MinAmount   string
+	MaxAmount   string
+	AccountId   string
+	Account     string
+	Category    string
+	Tags        string
+	Payee       string
+	DisplayName string
+}
+
+type RuleChange struct {
+	Date        string
+	Name        string
+	DisplayName string
+	Category    string
+	Tags        string
+	Payee       string
+	Amount      string
+	IsNeg       bool
+}
+
+type RulesMain struct {
+	Rules     []RuleData
+	Edit      RuleData
+	Accounts  []AccountData
+	From      string
+	To        string
+	AccountId string
+	Changes   []RuleChange
+	CanManage bool
+	Message   string
+	Error     string
+}
+
+func convertRule(r *db.Rule, accounts []db.Account) RuleData {
+	data := RuleData{
+		Id:          strconv.Itoa(r.Id),
+		Name:        r.Name,
+		Position:    strconv.Itoa(r.Position),
+		Enabled:     r.Enabled,
+		Pattern:     r.Pattern,
+		IsRegex:     r.IsRegex,
+		AccountId:   strconv.Itoa(r.AccountId),
+		Account:     "Any account",
+		Category:    r.Category,
+		Tags:        r.Tags,
+		Payee:       r.Payee,
+		DisplayName: r.DisplayName,
+	}
+
+	if r.MinAmount > 0 {
+		data.MinAmount = r.MinAmount.String()
+	}
+	if r.MaxAmount > 0 {
+		data.MaxAmount = r.MaxAmount.String()
+	}
+
+	for _, a := range accounts {
+		if a.Id == r.AccountId {
+			data.Account = a.Name
+		}
+	}
+
+	return data
+}
+
+func ruleFromForm(r *http.Request) (db.Rule, error) {
+	var problems []string
+	number := func(field string) int {
+		value := strings.TrimSpace(r.FormValue(field))
+		if len(value) == 0 {
+			return 0
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s", field))
+		}
+		return n
+	}
+	cents := func(field string) utils.Money {
+		value := strings.TrimSpace(r.FormValue(field))
+		if len(value) == 0 {
+			return 0
+		}
+		amount, err := utils.ParseMoney(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s", err))
+		}
+		return amount
+	}
+
+	rule := db.Rule{
+		Id:          number("id"),
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Position:    number("position"),
+		Enabled:     r.FormValue("enabled") == "on",
+		Pattern:     strings.TrimSpace(r.FormValue("pattern")),
+		IsRegex:     r.FormValue("isRegex") == "on",
+		MinAmount:   cents("minAmount"),
+		MaxAmount:   cents("maxAmount"),
+		AccountId:   number("account"),
+		Category:    strings.TrimSpace(r.FormValue("category")),
+		Tags:        strings.TrimSpace(r.FormValue("tags")),
+		Payee:       strings.TrimSpace(r.FormValue("payee")),
+		DisplayName: strings.TrimSpace(r.FormValue("displayName")),
+	}
+
+	if len(rule.Name) == 0 {
+		rule.Name = rule.Pattern
+	}
+
+	if len(problems) > 0 {
+		return rule, fmt.Errorf("%s.", strings.Join(problems, ", "))
+	}
+
+	return rule, rules.Validate(&rule)
+}
+
+func RulesHandler(w http.ResponseWriter, r *http.Request) {
+	data := RulesMain{
+		Edit:      RuleData{Enabled: true, AccountId: "0"},
+		AccountId: "0",
+		CanManage: isAdmin(r),
+	}
+
+	from, to, _ := reports.ParseRange("", "")
+	data.From = from.Format("2006-01-02")
+	data.To = to.AddDate(0, 0, -1).Format("2006-01-02")
+
+	roles, err := userRoles(r)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+
+	accounts, err := ledger(r).FetchAccounts(roles.With(db.RoleViewer))
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, a := range accounts {
+		data.Accounts = append(data.Accounts, convertAccount(&a))
+	}
+
+	if r.Method == http.MethodPost {
+		handleRuleAction(r, &data, accounts, roles)
+	} else if edit := r.URL.Query().Get("edit"); len(edit) > 0 {
+		id, _ := strconv.Atoi(edit)
+		rule, err := db.GetRule(id)
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+		} else if rule == nil {
+			data.Error = fmt.Sprintf("No rule with id %s.", edit)
+		} else {
+			data.Edit = convertRule(rule, accounts)
+		}
+	}
+
+	saved, err := db.FetchAllRules()
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, rule := range saved {
+		data.Rules = append(data.Rules, convertRule(&rule, accounts))
+	}
+
+	renderRules(w, r, data)
+}
+
+// handleRuleAction runs what was posted. Rules apply to every new
+// transaction, whoever enters it, so only admins change them; anyone can run
+// them over the accounts they edit.
+func handleRuleAction(r *http.Request, data *RulesMain, accounts []db.Account, roles db.AccountRoles) {
+	action := r.FormValue("action")
+	if (action == "save" || action == "delete") && !data.CanManage {
+		data.Error = "Only an admin can change rules."
+		return
+	}
+
+	switch action {
+	case "save":
+		rule, err := ruleFromForm(r)
+		if err != nil {
+			data.Edit = convertRule(&rule, accounts)
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+
+		if rule.Id == 0 {
+			err = db.Insert(&rule)
+		} else {
+			err = db.Update(&rule)
+		}
+
+		if err != nil {
+			data.Edit = convertRule(&rule, accounts)
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+			return
+		}
+
+		data.Message = fmt.Sprintf("Saved rule %s.", rule.Name)
+	case "delete":
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			data.Error = "Error reading rule id."
+			return
+		}
+
+		if err = db.Delete(&db.Rule{Id: id}); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+			return
+		}
+
+		data.Message = "Deleted rule."
+	case "preview", "apply":
+		applyRulesToHistory(r, data, roles)
+	}
+}
+
+// applyRulesToHistory re-runs the rules over saved transactions. Preview
+// lists what would change without writing anything.
+func applyRulesToHistory(r *http.Request, data *RulesMain, roles db.AccountRoles) {
+	data.From = r.FormValue("from")
+	data.To = r.FormValue("to")
+	data.AccountId = r.FormValue("account")
+
+	from, to, err := reports.ParseRange(data.From, data.To)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		return
+	}
+
+	accountId, _ := strconv.Atoi(data.AccountId)
+
+	engine, err := rules.Load()
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		return
+	}
+
+	dryRun := r.FormValue("action") == "preview"
+	accounts := roles.With(db.RoleEditor).Only(accountId)
+	changed, err := engine.ApplyHistory(config.DataDir, accounts, from, to, dryRun)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+		log.Printf("Error: %s\n", data.Error)
+		return
+	}
+
+	for _, t := range changed {
+		data.Changes = append(data.Changes, RuleChange{
+			Date:        t.Date.Format("Mon 02 Jan 2006"),
+			Name:        t.Name,
+			DisplayName: t.DisplayName,
+			Category:    t.Category,
+			Tags:        t.Tags,
+			Payee:       t.Payee,
+			Amount:      t.Amount.String(),
+			IsNeg:       t.Amount < 0,
+		})
+	}
+
+	if dryRun {
+		data.Message = fmt.Sprintf("%d transactions would change.", len(changed))
+		return
+	}
+
+	data.Message = fmt.Sprintf("Updated %d transactions.", len(changed))
+
+	touched := map[int]bool{}
+	for _, t := range changed {
+		if !touched[t.AccountId] {
+			touched[t.AccountId] = true
+			publish(r, EventTransactionUpdated, t.AccountId, 0)
+		}
+	}
+}
+
+func renderRules(w http.ResponseWriter, r *http.Request, data RulesMain) {
+	renderPage(w, r, "rules/rules_main_tmpl.html", data)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Every page and post goes through secureHeaders and checkCsrf. A post only
+// counts when it carries the page's CSRF token, so another site can't make a
+// logged-in browser change anything: the forms send it as csrf_token and
+// api.js sends it in the X-CSRF-Token header. The token for a logged-in user
+// is derived from their session cookie, so it changes with each login and
+// nothing extra has to be stored. Before logging in there is no session, so
+// the login and setup pages hand out a random csrf cookie and expect it back.
+//
+// The JSON API doesn't need a token: it only takes application/json, which
+// another site's page can't send without the browser asking first, and the
+// server never says yes. It still gets the Origin check.
+
+const (
+	csrfCookieName = "sacmoney_csrf"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+
+	// pageMaxBody is the most the pages' own JSON posts may send. Uploads
+	// have their own limit in maxImportSize, which is also the most any
+	// request may send.
+	pageMaxBody = 64 << 10
+
+	contentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+)
+
+const csrfRefused = "This page has expired. Reload it and try again."
+
+// secureHeaders keeps the pages from being framed or loading anything from
+// other sites, and caps how much any request may send.
+func secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkCsrf refuses posts from other sites and posts without the token.
+func checkCsrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		isApi := strings.HasPrefix(r.URL.Path, ApiPrefix+"/")
+
+		if !sameOrigin(r) {
+			infof("Refused %s %s from %s.\n", r.Method, r.URL.Path, r.Header.Get("Origin"))
+			if isApi {
+				writeApiError(w, http.StatusForbidden, "forbidden", "Requests from other sites aren't allowed.")
+			} else {
+				http.Error(w, "Requests from other sites aren't allowed.", http.StatusForbidden)
+			}
+			return
+		}
+
+		if isApi {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected := csrfToken(r)
+		sent := r.Header.Get(csrfHeaderName)
+		if len(sent) == 0 {
+			sent = r.PostFormValue(csrfFieldName)
+		}
+
+		if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			http.Error(w, csrfRefused, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin is false when the browser says the request came from a page on
+// another host. Requests that don't say where they came from, like curl's,
+// are left to the token check.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// csrfToken is the token the request's pages and posts have to carry, or ""
+// when the browser hasn't been given one yet.
+func csrfToken(r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && len(cookie.Value) > 0 {
+		mac := hmac.New(sha256.New, []byte(cookie.Value))
+		mac.Write([]byte(csrfCookieName))
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	if token, ok := r.Context().Value(csrfContextKey).(string); ok {
+		return token
+	}
+
+	if cookie, err := r.Cookie(csrfCookieName); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// withCsrfCookie gives a browser without a login a token of its own, so the
+// login and setup forms have something to send back.
+func withCsrfCookie(w http.ResponseWriter, r *http.Request) *http.Request {
+	if len(csrfToken(r)) > 0 {
+		return r
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("Error: %s\n", err)
+		return r
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return r.WithContext(context.WithValue(r.Context(), csrfContextKey, token))
+}
+
+// allowMethods answers 405 to any method a route doesn't take. GET routes
+// also answer HEAD. No methods means the handler checks for itself.
+func allowMethods(methods []string, next http.Handler) http.Handler {
+	if len(methods) == 0 {
+		return next
+	}
+
+	allowed := map[string]bool{}
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	if allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+		methods = append(methods, http.MethodHead)
+	}
+	allow := strings.Join(methods, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[r.Method] {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodePost reads a page's JSON post into v.
+func decodePost(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, pageMaxBody)
+	return json.NewDecoder(r.Body).Decode(v)
+}
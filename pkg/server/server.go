@@ -1,158 +1,327 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"sort"
-	"strconv"
-	"strings"
+	"path/filepath"
 	"time"
+	backup "tjdickerson/sacmoney/pkg/backup"
 	db "tjdickerson/sacmoney/pkg/database"
 )
 
-const DbDirectory = "data/"
+func checkEnvironment() error {
+	if _, err := os.Stat(config.DataDir); os.IsNotExist(err) {
+		err = os.MkdirAll(config.DataDir, 0700)
+		if err != nil {
+			return fmt.Errorf("Error getting data directory status: %s", err)
+		}
+	}
 
-type serverContext struct {
-	currentAccount *db.Account
-	currentMonth   string
-	currentYear    string
+	return nil
 }
 
-var (
-	servctx *serverContext
-)
-
-func RefreshAccount() {
-	account, err := db.GetDefaultAccount()
+// latestPeriod is the newest period in the data directory, or this month
+// when there are none yet.
+func latestPeriod() db.Period {
+	periods, err := db.ListPeriods(config.DataDir)
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error getting account: %s\n", err))
+		log.Printf("Error while reading directory contents: %s\n", err)
+	}
+
+	if len(periods) == 0 {
+		return db.PeriodOf(time.Now())
 	}
 
-	servctx.currentAccount = &account
+	return periods[len(periods)-1]
 }
 
-func checkEnvironment() error {
-	if _, err := os.Stat(DbDirectory); os.IsNotExist(err) {
-		err = os.Mkdir(DbDirectory, 0700)
-		if err != nil {
-			return fmt.Errorf("Error getting data directory status: %s", err)
-		}
+// NextMonthRollover starts a new period for every account, so only admins
+// can do it.
+func NextMonthRollover(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
 	}
 
-	return nil
+	if _, err := takeSnapshot(backup.ReasonRollover); err != nil {
+		io.WriteString(w, fmt.Sprintf("Not rolling over, the snapshot before rollover failed: %s", err))
+		return
+	}
+
+	next := ledgerPeriod.Next()
+	newDbPath := filepath.Join(config.DataDir, next.FileName())
+	if err := db.InitDatabase(newDbPath, true); err != nil {
+		log.Printf("Error: %s\n", err)
+		io.WriteString(w, fmt.Sprintf("Not rolling over: %s", err))
+		return
+	}
+
+	ledgerPeriod = next
+	recordHistory(r, db.HistoryRollover, ledgerPeriod)
+	publish(r, EventRollover, 0, 0)
+
+	io.WriteString(w, "SUCCESS")
+}
+
+type UndoRolloverData struct {
+	Confirm bool
 }
 
-func getTargetDbName() string {
-	now := time.Now()
+// UndoRolloverHandler removes the latest period and goes back to the one
+// before it. A period that has had transactions entered is only removed
+// once the page has asked and Confirm is set; the snapshot taken first still
+// has them.
+func UndoRolloverHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
 
-	entries, err := os.ReadDir(DbDirectory)
+	var data UndoRolloverData
+	if err := decodePost(w, r, &data); err != nil {
+		io.WriteString(w, fmt.Sprintf("Failed to read the request: %s", err))
+		return
+	}
+
+	periods, err := db.ListPeriods(config.DataDir)
 	if err != nil {
-		log.Printf("Error while reading directory contents: %s\n", err)
+		log.Printf("Error: %s\n", err)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
+	}
+	if len(periods) < 2 {
+		io.WriteString(w, "There is no earlier month to go back to.")
+		return
 	}
 
-	if len(entries) == 0 {
-		return fmt.Sprintf("%s.db", now.Format("2006January"))
+	undone := ledgerPeriod
+	entered, err := db.CountEnteredTransactions(config.DataDir, undone)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
 	}
 
-	var databases = make([]string, 0, 100)
-	for _, e := range entries {
-		if !e.IsDir() {
-			databases = append(databases, e.Name())
+	if entered > 0 && !data.Confirm {
+		// The page asks and posts again with Confirm set.
+		what := fmt.Sprintf("%d transactions", entered)
+		if entered == 1 {
+			what = "a transaction"
 		}
+		io.WriteString(w, fmt.Sprintf("CONFIRM:%s %d already has %s. Undo the rollover and remove it all?",
+			undone.Month, undone.Year, what))
+		return
 	}
 
-	sort.Slice(databases, func(i, j int) bool {
-		return i < j
-	})
-
-	return databases[len(databases)-1]
-}
+	if _, err := takeSnapshot(backup.ReasonUndo); err != nil {
+		io.WriteString(w, fmt.Sprintf("Not undoing the rollover, the snapshot before it failed: %s", err))
+		return
+	}
 
-func GetNextYearMonth(year string, month string) (string, string, error) {
-	const defaultYear = "1970"
-	const defaultMonth = "January"
-	t, err := time.Parse("2006January", fmt.Sprintf("%s%s", year, month))
+	closeLedger()
+	err = db.RemovePeriod(config.DataDir, undone)
+	openLedger()
 	if err != nil {
-		return defaultYear, defaultMonth, fmt.Errorf("Failed to convert %s to an actual month.", month)
+		log.Printf("Error: %s\n", err)
+		io.WriteString(w, fmt.Sprintf("%s", err))
+		return
 	}
 
-	var nextMonth time.Month
-	var nextYear int
+	infof("Undid the rollover to %s %d, back to %s %d.\n", undone.Month, undone.Year, ledgerPeriod.Month, ledgerPeriod.Year)
+	recordHistory(r, db.HistoryUndoRollover, undone)
+	publish(r, EventRolloverUndone, 0, 0)
+
+	io.WriteString(w, "SUCCESS")
+}
 
-	currentMonth := t.Month()
-	currentYear, err := strconv.Atoi(year)
+// recordHistory notes a change to the periods made by the request's user.
+func recordHistory(r *http.Request, action string, p db.Period) {
+	err := db.RecordHistory(db.HistoryEntry{UserId: userId(r), Action: action, Period: p})
 	if err != nil {
-		return defaultYear, defaultMonth, fmt.Errorf("Failed to convert year %s to an actual year.", year)
+		log.Printf("Error: %s\n", err)
 	}
+}
 
-	if currentMonth == time.December {
-		nextMonth = time.January
-		nextYear = currentYear + 1
-	} else {
-		nextMonth = currentMonth + 1
-		nextYear = currentYear
+// CarryBalancesHandler sets the starting balance of every period after the
+// one the page shows to how the period before it ended, after that period
+// was edited. It changes every account, so only admins can do it.
+func CarryBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
 	}
 
-	return strconv.Itoa(nextYear), nextMonth.String(), nil
-}
+	from, ok := viewPeriod(r)
+	if !ok {
+		io.WriteString(w, "Choose the period to carry balances forward from.")
+		return
+	}
 
-func NextMonthRollover(w http.ResponseWriter, r *http.Request) {
-	year, month, err := GetNextYearMonth(servctx.currentYear, servctx.currentMonth)
+	changed, err := db.CarryBalancesForward(config.DataDir, from)
 	if err != nil {
-		io.WriteString(w, fmt.Sprintf("Error getting rollover date: %s", err))
+		log.Printf("Error: %s\n", err)
+		io.WriteString(w, fmt.Sprintf("Error carrying balances forward: %s", err))
+		return
 	}
 
-	servctx.currentMonth = month
-	servctx.currentYear = year
+	infof("Carried balances forward from %s %d into %d periods.\n", from.Month, from.Year, len(changed))
+	recordHistory(r, db.HistoryCarryBalances, from)
+	publish(r, EventTransactionUpdated, 0, 0)
+	io.WriteString(w, "SUCCESS")
+}
+
+// openLedger connects to the latest period and the store.
+func openLedger() {
+	ledgerPeriod = latestPeriod()
+	db.InitDatabase(filepath.Join(config.DataDir, ledgerPeriod.FileName()), false)
 
-	newDbPath := fmt.Sprintf("%s/%s%s.db", DbDirectory, year, month)
-	if err = db.InitDatabase(newDbPath, true); err != nil {
-		log.Fatal(fmt.Sprintf("Error connecting to new database instance: %s\n", err))
+	if err := db.InitStore(fmt.Sprintf("%s/%s", config.DataDir, db.StoreFileName)); err != nil {
+		log.Fatal(fmt.Sprintf("Error opening store: %s\n", err))
 	}
 
-	RefreshAccount()
-	io.WriteString(w, "SUCCESS")
+	if db.HasAccount() {
+		if _, err := db.GetDefaultAccount(); err != nil {
+			log.Fatal(fmt.Sprintf("Error getting account: %s\n", err))
+		}
+	}
+
+	claimAccounts()
+}
+
+func closeLedger() {
+	db.CloseStore()
+	db.CloseDatabase()
 }
 
-func Run() {
-	checkEnvironment()
-	dbName := getTargetDbName()
-	dbPath := fmt.Sprintf("%s/%s", DbDirectory, dbName)
-	db.InitDatabase(dbPath, false)
-	defer db.CloseDatabase()
+// mux serves every route. registeredRoutes is every pattern handed to it, so
+// the API document can be checked against what is actually served.
+// routeMethods is what each of them answers; a route without methods checks
+// for itself.
+var (
+	mux              *http.ServeMux
+	registeredRoutes []string
+	routeMethods     = map[string][]string{}
+)
+
+func handle(pattern string, handler http.Handler, methods ...string) {
+	registeredRoutes = append(registeredRoutes, pattern)
+	routeMethods[pattern] = methods
+	mux.Handle(pattern, allowMethods(methods, handler))
+}
+
+func handleFunc(pattern string, handler http.HandlerFunc, methods ...string) {
+	handle(pattern, handler, methods...)
+}
+
+// routes registers every page and API endpoint on a new mux, and wraps it in
+// what each request goes through first.
+func routes(static http.FileSystem) http.Handler {
+	mux = http.NewServeMux()
+	registeredRoutes = nil
+	routeMethods = map[string][]string{}
+
+	const get, post = http.MethodGet, http.MethodPost
+
+	handle("/static/", http.StripPrefix("/static/", http.FileServer(static)), get)
+
+	handleFunc("/", TransMainHandler, get)
+	handleFunc("/saveTransaction", SaveTransactionHandler, post)
+	handleFunc("/deleteTransaction", DeleteTransactionHandler, post)
+
+	handleFunc("/recurrings", RecurringMainHandler, get)
+	handleFunc("/saveRecurring", SaveRecurringHandler, post)
+	handleFunc("/deleteRecurring", DeleteRecurringHandler, post)
+
+	handleFunc("/accounts", AccountMainHandler, get, post)
+	handleFunc("/addAccount", AddAccountHandler, post)
 
-	temp := strings.TrimRight(dbName, ".db")
-	month := temp[4:]
-	year := dbName[:4]
+	handleFunc("/rules", RulesHandler, get, post)
+	handleFunc("/webhooks", WebhooksHandler, get, post)
 
-	servctx = &serverContext{}
-	servctx.currentMonth = month
-	servctx.currentYear = year
+	handleFunc("/reports", ReportsHandler, get)
+	handleFunc("/networth", NetWorthHandler, get)
 
-	if !db.HasAccount() {
-		servctx.currentAccount = nil
+	handleFunc("/import", ImportMainHandler, get, post)
+	handleFunc("/export", ExportHandler, get, post)
+	handleFunc("/backup", BackupHandler, get, post)
+	handleExclusive("/snapshots", SnapshotsHandler, get, post)
+
+	handleFunc("/login", LoginHandler, get, post)
+	handleFunc("/logout", LogoutHandler, post)
+	handleFunc("/setup", SetupHandler, get, post)
+	handleFunc("/users", UsersHandler, get, post)
+
+	handleExclusive("/rollover", NextMonthRollover, post)
+	handleExclusive("/undoRollover", UndoRolloverHandler, post)
+	handleExclusive("/carryBalances", CarryBalancesHandler, post)
+	handleFunc("/applyRecurring", ApplyRecurringHandler, post)
+
+	handleFunc("/events", EventsHandler, get)
+
+	if config.Enabled(FeatureApi) {
+		registerApi()
 	} else {
-		RefreshAccount()
+		handleFunc(ApiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+			writeApiError(w, http.StatusNotFound, "not_found", "The API is turned off.")
+		})
 	}
 
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	return logRequests(secureHeaders(requireLogin(checkCsrf(lockLedger(mux)))))
+}
 
-	http.HandleFunc("/", TransMainHandler)
-	http.HandleFunc("/saveTransaction", SaveTransactionHandler)
-	http.HandleFunc("/deleteTransaction", DeleteTransactionHandler)
+// Run serves the pages and the API with cfg until the server fails.
+func Run(cfg Config) {
+	if err := cfg.check(); err != nil {
+		log.Fatal(err)
+	}
+	config = cfg
 
-	http.HandleFunc("/recurrings", RecurringMainHandler)
-	http.HandleFunc("/saveRecurring", SaveRecurringHandler)
-	http.HandleFunc("/deleteRecurring", DeleteRecurringHandler)
+	if err := checkEnvironment(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadPages(); err != nil {
+		log.Fatal(err)
+	}
+	static, err := staticFS()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	http.HandleFunc("/accounts", AccountMainHandler)
-	http.HandleFunc("/addAccount", AddAccountHandler)
+	openLedger()
+	defer closeLedger()
 
-	http.HandleFunc("/rollover", NextMonthRollover)
-	http.HandleFunc("/applyRecurring", ApplyRecurringHandler)
+	startSnapshots(snapshotInterval())
+	startWebhooks()
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	handler := routes(static)
+	if err := checkApiDocument(); err != nil {
+		log.Fatal(err)
+	}
+
+	if !config.ServesHTTPS() {
+		infof("Listening on %s\n", config.Listen)
+		log.Fatal(http.ListenAndServe(config.Listen, handler))
+	}
+
+	certFile, keyFile, err := tlsFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(config.RedirectListen) > 0 {
+		go func() {
+			infof("Redirecting %s to HTTPS\n", config.RedirectListen)
+			log.Fatal(http.ListenAndServe(config.RedirectListen, http.HandlerFunc(redirectToHttps)))
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:      config.Listen,
+		Handler:   handler,
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	infof("Listening on %s with HTTPS\n", config.Listen)
+	log.Fatal(srv.ListenAndServeTLS(certFile, keyFile))
 }
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+const testPassword = "correct horse"
+
+// testServer opens a ledger in a new data directory with one admin, and
+// returns what Run would serve along with the admin's session cookie.
+func testServer(t *testing.T) (http.Handler, *http.Cookie) {
+	t.Helper()
+
+	config = DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.SnapshotDir = t.TempDir()
+	config.LogLevel = LogError
+
+	if err := loadPages(); err != nil {
+		t.Fatal(err)
+	}
+	openLedger()
+	t.Cleanup(func() {
+		closeLedger()
+		config = DefaultConfig()
+	})
+
+	admin := &db.User{Username: "admin", IsAdmin: true}
+	if err := admin.SetPassword(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err := startSession(w, httptest.NewRequest(http.MethodGet, "/", nil), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	return routes(http.Dir(t.TempDir())), w.Result().Cookies()[0]
+}
+
+// serve sends one request through the handler as the holder of cookie.
+func serve(handler http.Handler, cookie *http.Cookie, method string, target string, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	if strings.HasPrefix(target, ApiPrefix+"/") {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	backup "tjdickerson/sacmoney/pkg/backup"
+)
+
+// DefaultSnapshotInterval is how often snapshots are taken unless the
+// configuration says otherwise.
+const DefaultSnapshotInterval = 24 * time.Hour
+
+// snapshotLock keeps a scheduled snapshot from running while a restore is
+// swapping the ledger files out.
+var snapshotLock sync.Mutex
+
+type SnapshotData struct {
+	Name   string
+	Taken  string
+	Reason string
+	Files  int
+	Size   string
+}
+
+type SnapshotsMain struct {
+	Snapshots []SnapshotData
+	Interval  string
+	Directory string
+	Message   string
+	Error     string
+}
+
+// parseSnapshotInterval reads a Go duration, such as 12h. "0" or "off" turns
+// scheduled snapshots off.
+func parseSnapshotInterval(value string) (time.Duration, error) {
+	if value == "off" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("Invalid snapshot interval %s, use a duration such as 12h, or off.", value)
+	}
+
+	return interval, nil
+}
+
+// snapshotInterval is how often the configuration says to take snapshots.
+// Run has already checked it.
+func snapshotInterval() time.Duration {
+	interval, _ := parseSnapshotInterval(config.SnapshotInterval)
+	return interval
+}
+
+// takeSnapshot snapshots the data directory and rotates out the ones that
+// are no longer kept.
+func takeSnapshot(reason string) (backup.Snapshot, error) {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	snapshot, err := backup.TakeSnapshot(config.DataDir, config.SnapshotDir, reason)
+	if err != nil {
+		log.Printf("Error taking snapshot: %s\n", err)
+		return snapshot, err
+	}
+	infof("Took snapshot %s\n", snapshot.Name)
+
+	rotateSnapshots()
+	return snapshot, nil
+}
+
+func rotateSnapshots() {
+	removed, err := backup.Rotate(config.SnapshotDir, backup.DefaultRetention)
+	if err != nil {
+		log.Printf("Error rotating snapshots: %s\n", err)
+	}
+	for _, name := range removed {
+		infof("Removed snapshot %s\n", name)
+	}
+}
+
+// startSnapshots takes a snapshot every interval in the background. One is
+// taken straight away if the newest is already older than the interval, so
+// restarting the server doesn't push the next one back.
+func startSnapshots(interval time.Duration) {
+	if interval == 0 {
+		infof("Scheduled snapshots are off\n")
+		return
+	}
+
+	var next time.Duration
+	snapshots, err := backup.ListSnapshots(config.SnapshotDir)
+	if err != nil {
+		log.Printf("Error reading snapshots: %s\n", err)
+	}
+	if len(snapshots) > 0 {
+		if age := time.Since(snapshots[0].Taken); age < interval {
+			next = interval - age
+		}
+	}
+
+	go func() {
+		timer := time.NewTimer(next)
+		for range timer.C {
+			takeSnapshot(backup.ReasonScheduled)
+			timer.Reset(interval)
+		}
+	}()
+}
+
+// restoreSnapshot swaps the ledger for the one in a snapshot. The current
+// ledger is snapshotted first so a restore can itself be undone; rotation
+// waits until the restore is done so it can't remove the snapshot in use.
+func restoreSnapshot(name string) error {
+	if _, err := backup.GetSnapshot(config.SnapshotDir, name); err != nil {
+		return err
+	}
+
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	current, err := backup.TakeSnapshot(config.DataDir, config.SnapshotDir, backup.ReasonRestore)
+	if err != nil {
+		return fmt.Errorf("Not restoring, the snapshot of the current data failed: %s", err)
+	}
+	infof("Took snapshot %s\n", current.Name)
+
+	closeLedger()
+	err = backup.RestoreSnapshot(config.SnapshotDir, name, config.DataDir)
+	openLedger()
+
+	rotateSnapshots()
+	return err
+}
+
+func convertSnapshot(s *backup.Snapshot) SnapshotData {
+	return SnapshotData{
+		Name:   s.Name,
+		Taken:  s.Taken.Format("Mon 02 Jan 2006 15:04"),
+		Reason: s.Reason,
+		Files:  len(s.Files),
+		Size:   fmt.Sprintf("%.1f KB", float64(s.Size)/1024),
+	}
+}
+
+func SnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	data := SnapshotsMain{Directory: config.SnapshotDir, Interval: "off"}
+	if interval := snapshotInterval(); interval > 0 {
+		data.Interval = interval.String()
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "take":
+			snapshot, err := takeSnapshot(backup.ReasonManual)
+			if err != nil {
+				data.Error = fmt.Sprintf("%s", err)
+			} else {
+				data.Message = fmt.Sprintf("Took snapshot %s.", snapshot.Name)
+			}
+		case "restore":
+			name := r.FormValue("name")
+			if err := restoreSnapshot(name); err != nil {
+				data.Error = fmt.Sprintf("%s", err)
+				log.Printf("Error: %s\n", data.Error)
+			} else {
+				data.Message = fmt.Sprintf("Restored snapshot %s.", name)
+			}
+		}
+	}
+
+	snapshots, err := backup.ListSnapshots(config.SnapshotDir)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, s := range snapshots {
+		data.Snapshots = append(data.Snapshots, convertSnapshot(&s))
+	}
+
+	renderPage(w, r, "snapshots/snapshots_main_tmpl.html", data)
+}
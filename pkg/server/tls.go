@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// With HTTPS on and no certificate given, the server makes its own and keeps
+// it in the data directory, so phones only have to be told to trust it once.
+// It names the machine's hostname and every address it has on the network,
+// and is made again when one of those is missing from it or it is about to
+// expire. Browsers will still warn about it until it is trusted; the
+// fingerprint in the log is what to compare against.
+
+const (
+	selfSignedCert = "sacmoney-cert.pem"
+	selfSignedKey  = "sacmoney-key.pem"
+
+	selfSignedLifetime = 825 * 24 * time.Hour
+	selfSignedRenew    = 30 * 24 * time.Hour
+)
+
+// tlsFiles is the certificate and key to serve HTTPS with.
+func tlsFiles() (string, string, error) {
+	if len(config.TLSCert) > 0 {
+		return config.TLSCert, config.TLSKey, nil
+	}
+
+	certFile := filepath.Join(config.DataDir, selfSignedCert)
+	keyFile := filepath.Join(config.DataDir, selfSignedKey)
+
+	dnsNames, ips := certificateNames()
+	if cert, err := loadCertificate(certFile, keyFile); err == nil && certificateCovers(cert, dnsNames, ips) {
+		logFingerprint(cert)
+		return certFile, keyFile, nil
+	}
+
+	cert, err := createSelfSigned(certFile, keyFile, dnsNames, ips)
+	if err != nil {
+		return "", "", err
+	}
+	infof("Created a self-signed certificate for %s\n", strings.Join(cert.DNSNames, ", "))
+	logFingerprint(cert)
+
+	return certFile, keyFile, nil
+}
+
+// certificateNames are the names the server can be reached by: localhost,
+// the hostname, and every address of every network interface that is up.
+func certificateNames() ([]string, []net.IP) {
+	dnsNames := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && len(host) > 0 {
+		dnsNames = append(dnsNames, host)
+		if !strings.Contains(host, ".") {
+			dnsNames = append(dnsNames, host+".local")
+		}
+	}
+
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("Error listing network interfaces: %s\n", err)
+		return dnsNames, ips
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+
+	return dnsNames, ips
+}
+
+func loadCertificate(certFile string, keyFile string) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// certificateCovers is whether cert names everything in dnsNames and ips and
+// has a while left to run. Certificates made before they were leaves, which
+// could sign others, are replaced too.
+func certificateCovers(cert *x509.Certificate, dnsNames []string, ips []net.IP) bool {
+	if cert.IsCA || time.Now().Add(selfSignedRenew).After(cert.NotAfter) {
+		return false
+	}
+
+	for _, name := range dnsNames {
+		if !slices.Contains(cert.DNSNames, name) {
+			return false
+		}
+	}
+
+	for _, ip := range ips {
+		if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func createSelfSigned(certFile string, keyFile string, dnsNames []string, ips []net.IP) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a key: %s", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a serial number: %s", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"sacmoney"}, CommonName: dnsNames[len(dnsNames)-1]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error saving the key: %s", err)
+	}
+
+	if err = os.MkdirAll(config.DataDir, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating %s: %s", config.DataDir, err)
+	}
+	if err = writePem(keyFile, "EC PRIVATE KEY", keyDer, 0600); err != nil {
+		return nil, err
+	}
+	if err = writePem(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func writePem(path string, kind string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+
+	if err = pem.Encode(f, &pem.Block{Type: kind, Bytes: der}); err != nil {
+		f.Close()
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+
+	return f.Close()
+}
+
+func logFingerprint(cert *x509.Certificate) {
+	sum := sha256.Sum256(cert.Raw)
+	infof("Certificate SHA-256 fingerprint %X\n", sum)
+}
+
+// redirectToHttps sends plain HTTP requests to the same page over HTTPS. The
+// redirect isn't permanent, so turning HTTPS off again doesn't leave
+// browsers stuck on it.
+func redirectToHttps(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+
+	if _, port, err := net.SplitHostPort(config.Listen); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedIsALeaf(t *testing.T) {
+	config = DefaultConfig()
+	config.DataDir = t.TempDir()
+	defer func() { config = DefaultConfig() }()
+
+	dnsNames := []string{"localhost", "sacmoney.local"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(192, 168, 1, 20)}
+	certFile := filepath.Join(config.DataDir, selfSignedCert)
+	keyFile := filepath.Join(config.DataDir, selfSignedKey)
+
+	if _, err := createSelfSigned(certFile, keyFile, dnsNames, ips); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.IsCA || !cert.BasicConstraintsValid {
+		t.Error("The certificate can sign other certificates")
+	}
+	if want := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment; cert.KeyUsage != want {
+		t.Errorf("Key usage %b, want %b", cert.KeyUsage, want)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("Extended key usage %v, want server auth only", cert.ExtKeyUsage)
+	}
+
+	// Trusting it, as a phone told to does, is enough to reach the server by
+	// any of its names.
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	for _, name := range []string{"localhost", "sacmoney.local", "192.168.1.20"} {
+		_, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	if !certificateCovers(cert, dnsNames, ips) {
+		t.Error("The new certificate isn't reused")
+	}
+	cert.IsCA = true
+	if certificateCovers(cert, dnsNames, ips) {
+		t.Error("A CA certificate from before is reused")
+	}
+	cert.IsCA = false
+	cert.NotAfter = time.Now().Add(selfSignedRenew / 2)
+	if certificateCovers(cert, dnsNames, ips) {
+		t.Error("A certificate about to expire is reused")
+	}
+}
@@ -1,22 +1,22 @@
 package server
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"html"
-	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 	db "tjdickerson/sacmoney/pkg/database"
+	duplicates "tjdickerson/sacmoney/pkg/duplicates"
+	rules "tjdickerson/sacmoney/pkg/rules"
 	utils "tjdickerson/sacmoney/pkg/utils"
 )
 
-func handleNoAccount(w http.ResponseWriter, t *template.Template) {
+func handleNoAccount(w http.ResponseWriter, r *http.Request) {
 	data := TransMain{
 		AccountName:    "No account, click on accounts at top.",
 		TotalAvailable: "0",
@@ -24,17 +24,21 @@ func handleNoAccount(w http.ResponseWriter, t *template.Template) {
 		Error:          "",
 	}
 
-	var outHtml bytes.Buffer
-	t.Execute(&outHtml, data)
-	io.WriteString(w, outHtml.String())
+	renderPage(w, r, "transactions/trans_main_tmpl.html", data)
 }
 
 type TransactionData struct {
-	Id     string
-	Date   string
-	Name   string
-	Amount string
-	IsNeg  bool
+	Id          string
+	Date        string
+	Name        string
+	DisplayName string
+	Category    string
+	Tags        string
+	Amount      string
+	IsNeg       bool
+	Duplicate   string
+	CreatedBy   string
+	EditedBy    string
 }
 
 type RecurringDisplay struct {
@@ -56,29 +60,115 @@ type TransMain struct {
 	TotalAvailable string
 	Transactions   []TransactionData
 	Recurrings     []RecurringDisplay
+	CanEdit        bool
+	CanRollover    bool
 	Error          string
+
+	// The period picker. Past is set when the page shows an earlier period
+	// than the latest, and StaleBalances when a later period no longer
+	// starts where this one ends.
+	Periods       []PeriodOption
+	PrevUrl       string
+	NextUrl       string
+	Past          bool
+	DefaultDate   string
+	StaleBalances bool
+	CanCarry      bool
+	CanUndo       bool
+	History       []string
+}
+
+type PeriodOption struct {
+	Name     string
+	Url      string
+	Selected bool
+}
+
+// periodUrl is the transaction page showing p, keeping the rest of the view.
+// The latest period is the page without ?period=.
+func periodUrl(r *http.Request, p db.Period, latest db.Period) string {
+	query := url.Values{}
+	if account := r.URL.Query().Get("account"); len(account) > 0 {
+		query.Set("account", account)
+	}
+	if p != latest {
+		query.Set("period", periodId(p))
+	}
+
+	if len(query) == 0 {
+		return "/"
+	}
+	return "/?" + query.Encode()
+}
+
+// periodPicker fills in the links to every period and to either side of the
+// one shown.
+func periodPicker(r *http.Request, data *TransMain, shown db.Period) {
+	latest := currentPeriod()
+	data.Past = shown != latest
+
+	periods, err := db.ListPeriods(config.DataDir)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return
+	}
+
+	for i := len(periods) - 1; i >= 0; i-- {
+		p := periods[i]
+		if latest.Before(p) {
+			continue
+		}
+
+		data.Periods = append(data.Periods, PeriodOption{
+			Name:     fmt.Sprintf("%s %d", p.Month, p.Year),
+			Url:      periodUrl(r, p, latest),
+			Selected: p == shown,
+		})
+
+		switch {
+		case p.Before(shown) && len(data.PrevUrl) == 0:
+			data.PrevUrl = periodUrl(r, p, latest)
+		case shown.Before(p):
+			data.NextUrl = periodUrl(r, p, latest)
+		}
+	}
+
+	if data.Past {
+		data.DefaultDate = shown.End().AddDate(0, 0, -1).Format("2006-01-02")
+		if data.StaleBalances, err = db.StaleBalancesAfter(config.DataDir, shown); err != nil {
+			log.Printf("Error: %s\n", err)
+		}
+	}
 }
 
-func convertTransaction(t *db.Transaction) TransactionData {
+func convertTransaction(t *db.Transaction, names map[int]string) TransactionData {
 	return TransactionData{
-		Id:     strconv.Itoa(t.Id),
-		Name:   html.EscapeString(strings.TrimSpace(t.Name)),
-		Date:   t.Date.Format("Mon 02 Jan"),
-		Amount: fmt.Sprintf("%.2f", float32(t.Amount)*float32(0.01)),
-		IsNeg:  t.Amount < 0,
+		CreatedBy:   names[t.CreatedBy],
+		EditedBy:    names[t.EditedBy],
+		Id:          strconv.Itoa(t.Id),
+		Name:        html.EscapeString(strings.TrimSpace(t.Name)),
+		DisplayName: t.DisplayName,
+		Category:    t.Category,
+		Tags:        t.Tags,
+		Date:        t.Date.Format("Mon 02 Jan"),
+		Amount:      t.Amount.String(),
+		IsNeg:       t.Amount < 0,
 	}
 }
 
 func (t *TransactionData) toDbTransaction() (db.Transaction, error) {
 	name := html.EscapeString(strings.TrimSpace(t.Name))
-	amount := utils.GetCentsFromString(t.Amount)
-
 	var outErr string = ""
 	id, err := strconv.Atoi(t.Id)
 	if err != nil {
 		outErr = outErr + "Error reading id. "
 	}
 
+	amount, err := utils.ParseMoney(t.Amount)
+	if err != nil {
+		outErr = outErr + fmt.Sprintf("%s ", err)
+	}
+
 	date, err := time.Parse("2006-01-02", t.Date)
 	if err != nil {
 		date = time.Now()
@@ -100,35 +190,71 @@ func (t *TransactionData) toDbTransaction() (db.Transaction, error) {
 	}, nil
 }
 
-func TransMainHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles(
-		"templates/transactions/trans_main_tmpl.html",
-		"templates/core/title_tmpl.html")
+// historyShown is how many changes to the periods the page lists.
+const historyShown = 5
 
+// periodHistory describes the latest rollovers and other changes to the
+// periods, newest first.
+func periodHistory() []string {
+	entries, err := db.FetchHistory(historyShown)
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error parsing template: %s", err))
+		log.Printf("Error: %s\n", err)
+		return nil
 	}
 
-	if servctx.currentAccount == nil {
-		handleNoAccount(w, t)
+	names := usernames()
+	var lines []string
+	for _, e := range entries {
+		var what string
+		switch e.Action {
+		case db.HistoryRollover:
+			what = "rolled over to"
+		case db.HistoryUndoRollover:
+			what = "undid the rollover to"
+		case db.HistoryCarryBalances:
+			what = "carried balances forward from"
+		default:
+			what = e.Action
+		}
+
+		who := names[e.UserId]
+		if len(who) == 0 {
+			who = "Someone"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: %s %s %s %d", e.Time.Format("Mon 02 Jan 2006 15:04"), who, what, e.Period.Month, e.Period.Year))
+	}
+
+	return lines
+}
+
+func TransMainHandler(w http.ResponseWriter, r *http.Request) {
+	account, role, err := userAccount(r)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+
+	if account == nil {
+		handleNoAccount(w, r)
 		return
 	}
 
 	outError := ""
-	accountName := servctx.currentAccount.Name
-	totalAvailable := fmt.Sprintf("%.2f", float32(servctx.currentAccount.TotalAvailable)*float32(0.01))
-	transactions, err := db.FetchAllTransactions()
+	accountName := account.Name
+	totalAvailable := account.TotalAvailable.String()
+	transactions, err := ledger(r).FetchTransactionsFor(account.Id)
 	if err != nil {
 		outError = fmt.Sprintf("%s", err)
 		log.Println(outError)
 	}
 
+	names := usernames()
 	transactionData := []TransactionData{}
 	for _, dbTrans := range transactions {
-		transactionData = append(transactionData, convertTransaction(&dbTrans))
+		transactionData = append(transactionData, convertTransaction(&dbTrans, names))
 	}
 
-	recurrings, err := db.FetchAllRecurrings()
+	recurrings, err := ledger(r).FetchRecurringsFor(account.Id)
 	if err != nil {
 		outError = fmt.Sprintf("%s", err)
 		log.Println(outError)
@@ -147,47 +273,47 @@ func TransMainHandler(w http.ResponseWriter, r *http.Request) {
 		recurringData = append(recurringData, RecurringDisplay{
 			Id:       fmt.Sprintf("%d", r.Id),
 			Name:     r.Name,
-			Amount:   fmt.Sprintf("%.2f", float32(r.Amount)*float32(0.01)),
+			Amount:   r.Amount.String(),
 			IsNeg:    r.Amount < 0,
 			Day:      fmt.Sprintf("%d", r.Day),
 			CssClass: cssClass,
 		})
 	}
 
-	currentYear := servctx.currentYear
-	currentMonth := servctx.currentMonth
-	nextYear, nextMonth, err := GetNextYearMonth(currentYear, currentMonth)
-	if err != nil {
-		outError = fmt.Sprintf("%s", err)
-		log.Printf("%s\n", outError)
-	}
+	period := shownPeriod(r)
 
 	availClass := "pos"
-	if servctx.currentAccount.TotalAvailable < 0 {
+	if account.TotalAvailable < 0 {
 		availClass = "neg"
 	}
 
 	data := TransMain{
 		AccountName:    accountName,
-		Month:          currentMonth,
-		Year:           currentYear,
-		NextMonth:      nextMonth,
-		NextYear:       nextYear,
+		Month:          period.Month.String(),
+		Year:           period.YearString(),
+		NextMonth:      period.Next().Month.String(),
+		NextYear:       period.Next().YearString(),
 		TotalAvailable: totalAvailable,
 		Transactions:   transactionData,
 		Recurrings:     recurringData,
 		AvailClass:     availClass,
+		CanEdit:        role.AtLeast(db.RoleEditor),
+		CanRollover:    isAdmin(r) && period == currentPeriod(),
+		CanCarry:       isAdmin(r),
 		Error:          outError,
 	}
+	periodPicker(r, &data, period)
+	if data.CanRollover {
+		data.CanUndo = len(data.Periods) > 1
+		data.History = periodHistory()
+	}
 
-	var outHtml bytes.Buffer
-	t.Execute(&outHtml, data)
-	io.WriteString(w, outHtml.String())
+	renderPage(w, r, "transactions/trans_main_tmpl.html", data)
 }
 
 func SaveTransactionHandler(w http.ResponseWriter, r *http.Request) {
 	var data TransactionData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodePost(w, r, &data)
 	if err != nil {
 		outErr := fmt.Sprintf("Failed to decode transaction: %s", err)
 		log.Printf("Error: %s\n", outErr)
@@ -203,9 +329,69 @@ func SaveTransactionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if transaction.Id == 0 {
-		err = db.Insert(&transaction)
+		account, role, err := userAccount(r)
+		if err != nil {
+			log.Printf("Error: %s\n", err)
+		}
+		if account == nil || !role.AtLeast(db.RoleEditor) {
+			forbid(w)
+			return
+		}
+
+		transaction.AccountId = account.Id
+		transaction.CreatedBy = userId(r)
+
+		match, err := duplicates.Find(config.DataDir, transaction)
+		if err != nil {
+			log.Printf("Error checking for duplicates: %s\n", err)
+		}
+
+		if match != nil {
+			switch data.Duplicate {
+			case duplicates.ActionKeep:
+			case duplicates.ActionMerge:
+				err = db.MergeTransaction(config.DataDir, match.Existing, transaction, true)
+				if err != nil {
+					outErr := fmt.Sprintf("Failed to merge transaction: %s", err)
+					log.Printf("Error: %s\n", outErr)
+					io.WriteString(w, outErr)
+					return
+				}
+
+				publish(r, EventTransactionUpdated, match.Existing.AccountId, match.Existing.Id)
+				io.WriteString(w, "SUCCESS")
+				return
+			default:
+				// The page asks whether to merge, skip or keep both and posts
+				// again with the answer.
+				io.WriteString(w, fmt.Sprintf("DUPLICATE:This looks like %s for %s on %s (%.0f%% match).",
+					match.Existing.Name, match.Existing.Amount.String(),
+					match.Existing.Date.Format("Mon 02 Jan"), match.Score*100))
+				return
+			}
+		}
+	}
+
+	if transaction.Id == 0 {
+		engine, err := rules.Load()
+		if err != nil {
+			log.Printf("Error loading rules: %s\n", err)
+		} else {
+			engine.Apply(&transaction)
+		}
+	}
+
+	if transaction.Id == 0 {
+		if err = ledger(r).Insert(&transaction); err == nil {
+			publish(r, EventTransactionCreated, transaction.AccountId, transaction.Id)
+		}
 	} else {
-		err = db.Update(&transaction)
+		err = updateTransaction(r, transaction)
+	}
+
+	if err == errNoPermission {
+		forbid(w)
+		return
 	}
 
 	if err != nil {
@@ -215,13 +401,51 @@ func SaveTransactionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	RefreshAccount()
 	io.WriteString(w, "SUCCESS")
 }
 
+// updateTransaction saves the name and amount edited on the page, keeping
+// everything else the transaction already has.
+func updateTransaction(r *http.Request, edited db.Transaction) error {
+	saved, err := editableTransaction(r, edited.Id)
+	if err != nil {
+		return err
+	}
+
+	saved.Name = edited.Name
+	saved.Amount = edited.Amount
+	saved.EditedBy = userId(r)
+	if err = ledger(r).Update(saved); err != nil {
+		return err
+	}
+
+	publish(r, EventTransactionUpdated, saved.AccountId, saved.Id)
+	return nil
+}
+
+// editableTransaction is the transaction with the id when the user may
+// change it, or errNoPermission. A transaction the user can't see is
+// refused the same way, so ids don't give away other people's accounts.
+func editableTransaction(r *http.Request, id int) (*db.Transaction, error) {
+	roles, err := userRoles(r)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := ledger(r).GetTransaction(id)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil || !roles.Can(saved.AccountId, db.RoleEditor) {
+		return nil, errNoPermission
+	}
+
+	return saved, nil
+}
+
 func DeleteTransactionHandler(w http.ResponseWriter, r *http.Request) {
 	var data TransactionData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodePost(w, r, &data)
 
 	if err != nil {
 		outErr := fmt.Sprintf("Failed to decode transaction: %s", err)
@@ -238,8 +462,14 @@ func DeleteTransactionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	temp := db.Transaction{Id: id}
-	err = db.Delete(&temp)
+	saved, err := editableTransaction(r, id)
+	if err == errNoPermission {
+		forbid(w)
+		return
+	}
+	if err == nil {
+		err = ledger(r).Delete(saved)
+	}
 	if err != nil {
 		outErr := fmt.Sprintf("Error deleting transaction: %s", err)
 		log.Printf("Error: %s\n", outErr)
@@ -247,16 +477,18 @@ func DeleteTransactionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	RefreshAccount()
+	publish(r, EventTransactionDeleted, saved.AccountId, saved.Id)
 	io.WriteString(w, "SUCCESS")
 }
 
+// ApplyRecurringData is the body of /applyRecurring.
+type ApplyRecurringData struct {
+	Id string
+}
+
 func ApplyRecurringHandler(w http.ResponseWriter, r *http.Request) {
-	type jsonData struct {
-		Id string
-	}
-	jd := jsonData{}
-	if err := json.NewDecoder(r.Body).Decode(&jd); err != nil {
+	jd := ApplyRecurringData{}
+	if err := decodePost(w, r, &jd); err != nil {
 		outErr := fmt.Sprintf("Error getting recurring transaction id: %s", err)
 		log.Printf("%s\n", outErr)
 		io.WriteString(w, outErr)
@@ -271,9 +503,29 @@ func ApplyRecurringHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db.CreateTransactionFromRecurring(id)
+	roles, err := userRoles(r)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+
+	recurring, err := ledger(r).GetRecurring(id)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+	if recurring == nil || !roles.Can(recurring.AccountId, db.RoleEditor) {
+		forbid(w)
+		return
+	}
+
+	if err = ledger(r).CreateTransactionFromRecurring(id, userId(r)); err != nil {
+		outErr := fmt.Sprintf("Error applying recurring transaction: %s", err)
+		log.Printf("%s\n", outErr)
+		io.WriteString(w, outErr)
+		return
+	}
 
-	RefreshAccount()
+	webhookRecurringPosted(r, recurring)
+	publish(r, EventTransactionCreated, recurring.AccountId, 0)
 	io.WriteString(w, "SUCCESS")
 }
 
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+type UserData struct {
+	Id       string
+	Username string
+	IsAdmin  bool
+	IsYou    bool
+	Created  string
+}
+
+type UsersMain struct {
+	Users   []UserData
+	Message string
+	Error   string
+}
+
+// UsersHandler lets admins add and remove the people who can log in. Who
+// sees which account is set by each account's owners on the accounts page.
+func UsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	data := UsersMain{}
+	if r.Method == http.MethodPost {
+		handleUserAction(r, &data)
+	}
+
+	users, err := db.FetchAllUsers()
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, u := range users {
+		data.Users = append(data.Users, UserData{
+			Id:       strconv.Itoa(u.Id),
+			Username: u.Username,
+			IsAdmin:  u.IsAdmin,
+			IsYou:    u.Id == userId(r),
+			Created:  u.Created.Format("Mon 02 Jan 2006"),
+		})
+	}
+
+	renderPage(w, r, "users/users_main_tmpl.html", data)
+}
+
+func handleUserAction(r *http.Request, data *UsersMain) {
+	switch r.FormValue("action") {
+	case "add":
+		user := db.User{Username: strings.TrimSpace(r.FormValue("username")), IsAdmin: r.FormValue("admin") == "on"}
+		if len(user.Username) == 0 {
+			data.Error = "A username is required."
+			return
+		}
+
+		if err := user.SetPassword(r.FormValue("password")); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+
+		if err := db.Insert(&user); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+
+		data.Message = fmt.Sprintf("Added %s. Share accounts with them from the accounts page.", user.Username)
+	case "delete":
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			data.Error = "Error reading user id."
+			return
+		}
+
+		if id == userId(r) {
+			data.Error = "You can't delete yourself."
+			return
+		}
+
+		user, err := db.GetUser(id)
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+		if user == nil {
+			data.Error = fmt.Sprintf("No user with id %d.", id)
+			return
+		}
+
+		if err = db.Delete(user); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+			return
+		}
+
+		// Accounts only they owned go to the admins.
+		claimAccounts()
+		data.Message = fmt.Sprintf("Deleted %s.", user.Username)
+	}
+}
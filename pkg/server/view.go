@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+// What a page shows travels in its URL rather than in the server, so tabs
+// and people don't change each other's view. The pages pass it on: their
+// links get viewQuery added, and api.js adds it to their posts.
+
+// viewParams are the query parameters that make up a page's view.
+var viewParams = []string{"account", "period"}
+
+// viewAccountId is the account the request's page shows, or 0 when it
+// doesn't say.
+func viewAccountId(r *http.Request) int {
+	id, err := strconv.Atoi(r.URL.Query().Get("account"))
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// viewPeriod is the period the request's page asks for with ?period=yyyy-mm,
+// and false when it doesn't ask for one or the value isn't a period.
+func viewPeriod(r *http.Request) (db.Period, bool) {
+	t, err := time.Parse("2006-01", r.URL.Query().Get("period"))
+	if err != nil {
+		return db.Period{}, false
+	}
+	return db.PeriodOf(t), true
+}
+
+// shownPeriod is the period the request's page shows: the one it asks for,
+// or else the open one.
+func shownPeriod(r *http.Request) db.Period {
+	if p, ok := viewPeriod(r); ok {
+		return p
+	}
+	return currentPeriod()
+}
+
+// viewQuery is the request's view as a query string, such as "?account=2",
+// or "" when the page was opened without one.
+func viewQuery(r *http.Request) string {
+	query := r.URL.Query()
+	view := url.Values{}
+	for _, name := range viewParams {
+		if value := query.Get(name); len(value) > 0 {
+			view.Set(name, value)
+		}
+	}
+
+	if len(view) == 0 {
+		return ""
+	}
+	return "?" + view.Encode()
+}
@@ -0,0 +1,542 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+	utils "tjdickerson/sacmoney/pkg/utils"
+)
+
+// Webhooks POST a JSON payload to an address when something happens in the
+// ledger. Each request carries the time it was sent in X-Sacmoney-Timestamp
+// and, in X-Sacmoney-Signature, "sha256=" and the hex HMAC-SHA256 of the
+// timestamp, a ".", and the body, keyed with the webhook's secret. Deliveries
+// that fail, or get anything but a 2xx, are tried again with the wait
+// doubling each time, and every attempt goes in the delivery log. Retries
+// waiting when the server stops are lost.
+
+const (
+	WebhookTransactionCreated = "transaction.created"
+	WebhookBalanceLow         = "balance.low"
+	WebhookRecurringPosted    = "recurring.posted"
+	WebhookRolloverCompleted  = "rollover.completed"
+	WebhookTest               = "test"
+)
+
+var webhookEvents = []string{WebhookTransactionCreated, WebhookBalanceLow, WebhookRecurringPosted, WebhookRolloverCompleted}
+
+const (
+	webhookAttempts  = 5
+	webhookTimeout   = 10 * time.Second
+	webhookQueueSize = 256
+	deliveriesShown  = 50
+
+	signatureHeader = "X-Sacmoney-Signature"
+)
+
+// WebhookPayload is the body of every webhook request. Id is the same on
+// every attempt, so a receiver can tell a retry from a new event.
+type WebhookPayload struct {
+	Id    string `json:"id"`
+	Event string `json:"event"`
+	Time  string `json:"time"`
+	Data  any    `json:"data"`
+}
+
+type WebhookAccount struct {
+	Id      int         `json:"id"`
+	Name    string      `json:"name"`
+	Balance utils.Money `json:"balance"`
+}
+
+// webhookDelivery is a payload on its way to one webhook.
+type webhookDelivery struct {
+	hook    db.Webhook
+	payload WebhookPayload
+	body    []byte
+	attempt int
+}
+
+var (
+	// webhookBackoff is the wait before the first retry.
+	webhookBackoff = 10 * time.Second
+
+	webhookQueue  = make(chan webhookDelivery, webhookQueueSize)
+	webhookClient = &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// lowBalances is which webhooks have been told which accounts are
+	// below their threshold, so they are only told again after it recovers.
+	// It is only kept while the server runs.
+	lowBalances     = map[[2]int]bool{}
+	lowBalancesLock sync.Mutex
+)
+
+// startWebhooks sends queued deliveries one at a time until the server
+// stops.
+func startWebhooks() {
+	go func() {
+		for d := range webhookQueue {
+			deliver(d)
+		}
+	}()
+}
+
+func queueDelivery(d webhookDelivery) {
+	select {
+	case webhookQueue <- d:
+	default:
+		log.Printf("Error: webhook queue is full, dropped %s for %s\n", d.payload.Event, d.hook.Name)
+	}
+}
+
+// sendWebhooks queues the event for every webhook that wants it. The caller
+// holds the ledger.
+func sendWebhooks(event string, accountId int, data any) {
+	hooks, err := db.FetchAllWebhooks()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return
+	}
+
+	for _, h := range hooks {
+		if h.Wants(event, accountId) {
+			queueDelivery(newDelivery(h, event, data))
+		}
+	}
+}
+
+func newDelivery(h db.Webhook, event string, data any) webhookDelivery {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+
+	payload := WebhookPayload{
+		Id:    hex.EncodeToString(raw),
+		Event: event,
+		Time:  time.Now().UTC().Format(time.RFC3339),
+		Data:  data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+
+	return webhookDelivery{hook: h, payload: payload, body: body}
+}
+
+// deliver makes one attempt and schedules the next when it fails.
+func deliver(d webhookDelivery) {
+	d.attempt++
+	record := attemptDelivery(d)
+
+	ledgerLock.RLock()
+	err := db.RecordDelivery(&record)
+	ledgerLock.RUnlock()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+
+	if record.Delivered || d.attempt >= webhookAttempts {
+		return
+	}
+
+	wait := webhookBackoff << (d.attempt - 1)
+	time.AfterFunc(wait, func() { queueDelivery(d) })
+}
+
+// attemptDelivery posts the payload once and says how it went.
+func attemptDelivery(d webhookDelivery) db.WebhookDelivery {
+	record := db.WebhookDelivery{
+		WebhookId:  d.hook.Id,
+		DeliveryId: d.payload.Id,
+		Event:      d.payload.Event,
+		Attempt:    d.attempt,
+		Time:       time.Now(),
+	}
+
+	timestamp := strconv.FormatInt(record.Time.Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, d.hook.Url, bytes.NewReader(d.body))
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sacmoney-webhook")
+	req.Header.Set("X-Sacmoney-Event", d.payload.Event)
+	req.Header.Set("X-Sacmoney-Delivery", d.payload.Id)
+	req.Header.Set("X-Sacmoney-Timestamp", timestamp)
+	req.Header.Set(signatureHeader, "sha256="+signWebhook(d.hook.Secret, timestamp, d.body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	record.StatusCode = resp.StatusCode
+	record.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !record.Delivered {
+		record.Error = resp.Status
+	}
+
+	return record
+}
+
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhooks turns a change into the webhook events it causes.
+func notifyWebhooks(r *http.Request, e ChangeEvent) {
+	switch e.Type {
+	case EventTransactionCreated:
+		if e.Id != 0 {
+			webhookTransactionCreated(r, e.Id)
+		}
+		checkBalance(r, e.AccountId)
+	case EventTransactionUpdated, EventTransactionDeleted:
+		checkBalance(r, e.AccountId)
+	case EventRollover:
+		sendWebhooks(WebhookRolloverCompleted, 0, map[string]any{"period": periodResource(currentPeriod())})
+	}
+}
+
+func webhookAccount(r *http.Request, id int) (WebhookAccount, error) {
+	account, err := ledger(r).GetAccount(id)
+	if err != nil {
+		return WebhookAccount{}, err
+	}
+	return WebhookAccount{Id: account.Id, Name: account.Name, Balance: account.TotalAvailable}, nil
+}
+
+func webhookTransactionCreated(r *http.Request, id int) {
+	t, err := ledger(r).GetTransaction(id)
+	if err != nil || t == nil {
+		log.Printf("Error reading transaction %d for webhooks: %v\n", id, err)
+		return
+	}
+	t.Period = shownPeriod(r)
+
+	account, err := webhookAccount(r, t.AccountId)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return
+	}
+
+	sendWebhooks(WebhookTransactionCreated, t.AccountId, map[string]any{
+		"account":     account,
+		"transaction": transactionResource(t, usernames()),
+	})
+}
+
+// webhookRecurringPosted tells webhooks a recurring transaction was added to
+// the month.
+func webhookRecurringPosted(r *http.Request, recurring *db.Recurring) {
+	account, err := webhookAccount(r, recurring.AccountId)
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return
+	}
+
+	sendWebhooks(WebhookRecurringPosted, recurring.AccountId, map[string]any{
+		"account":   account,
+		"recurring": recurringResource(recurring),
+	})
+}
+
+// checkBalance sends balance.low to each webhook whose threshold the
+// account's balance has just dropped below. Only changes to the open period
+// count, since earlier ones don't have the balance as it is now.
+func checkBalance(r *http.Request, accountId int) {
+	if accountId == 0 || shownPeriod(r) != currentPeriod() {
+		return
+	}
+
+	hooks, err := db.FetchAllWebhooks()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return
+	}
+
+	var account *WebhookAccount
+	for _, h := range hooks {
+		if h.AccountId == 0 || !h.Wants(WebhookBalanceLow, accountId) {
+			continue
+		}
+
+		if account == nil {
+			a, err := webhookAccount(r, accountId)
+			if err != nil {
+				log.Printf("Error: %s\n", err)
+				return
+			}
+			account = &a
+		}
+
+		below := account.Balance < h.Threshold
+		key := [2]int{h.Id, accountId}
+
+		lowBalancesLock.Lock()
+		told := lowBalances[key]
+		lowBalances[key] = below
+		lowBalancesLock.Unlock()
+
+		if below && !told {
+			queueDelivery(newDelivery(h, WebhookBalanceLow, map[string]any{"account": *account, "threshold": h.Threshold}))
+		}
+	}
+}
+
+type WebhookData struct {
+	Id        string
+	Name      string
+	Url       string
+	Secret    string
+	Events    []string
+	AccountId string
+	Account   string
+	Threshold string
+	Enabled   bool
+}
+
+// Wants is for the form's checkboxes.
+func (d WebhookData) Wants(event string) bool {
+	return slices.Contains(d.Events, event)
+}
+
+type DeliveryData struct {
+	Time      string
+	Webhook   string
+	Event     string
+	Attempt   int
+	Status    string
+	Delivered bool
+}
+
+type WebhooksMain struct {
+	Webhooks   []WebhookData
+	Edit       WebhookData
+	Accounts   []AccountData
+	Events     []string
+	Deliveries []DeliveryData
+	Message    string
+	Error      string
+}
+
+func convertWebhook(h *db.Webhook, accounts []db.Account) WebhookData {
+	data := WebhookData{
+		Id:        strconv.Itoa(h.Id),
+		Name:      h.Name,
+		Url:       h.Url,
+		Secret:    h.Secret,
+		Events:    h.EventList(),
+		AccountId: strconv.Itoa(h.AccountId),
+		Account:   "Any account",
+		Enabled:   h.Enabled,
+	}
+
+	if slices.Contains(data.Events, WebhookBalanceLow) {
+		data.Threshold = h.Threshold.String()
+	}
+
+	for _, a := range accounts {
+		if a.Id == h.AccountId {
+			data.Account = a.Name
+		}
+	}
+
+	return data
+}
+
+func webhookFromForm(r *http.Request) (db.Webhook, error) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	accountId, _ := strconv.Atoi(r.FormValue("account"))
+
+	h := db.Webhook{
+		Id:        id,
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		Url:       strings.TrimSpace(r.FormValue("url")),
+		Secret:    strings.TrimSpace(r.FormValue("secret")),
+		AccountId: accountId,
+		Enabled:   r.FormValue("enabled") == "on",
+	}
+
+	var events []string
+	for _, e := range webhookEvents {
+		if r.FormValue("event_"+e) == "on" {
+			events = append(events, e)
+		}
+	}
+	h.Events = strings.Join(events, ",")
+
+	u, err := url.Parse(h.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return h, errors.New("The URL has to be a full http:// or https:// address.")
+	}
+
+	if len(events) == 0 {
+		return h, errors.New("Pick at least one event.")
+	}
+
+	if threshold := strings.TrimSpace(r.FormValue("threshold")); len(threshold) > 0 {
+		if h.Threshold, err = utils.ParseMoney(threshold); err != nil {
+			return h, err
+		}
+	}
+	if slices.Contains(events, WebhookBalanceLow) && h.AccountId == 0 {
+		return h, errors.New("A low balance webhook needs an account to watch.")
+	}
+
+	if len(h.Name) == 0 {
+		h.Name = u.Host
+	}
+
+	if len(h.Secret) == 0 {
+		raw := make([]byte, 24)
+		if _, err = rand.Read(raw); err != nil {
+			return h, err
+		}
+		h.Secret = hex.EncodeToString(raw)
+	}
+
+	return h, nil
+}
+
+// WebhooksHandler lists the webhooks and their recent deliveries. Webhooks
+// send what happens in every account, so only admins manage them.
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	data := WebhooksMain{
+		Edit:   WebhookData{Enabled: true, AccountId: "0"},
+		Events: webhookEvents,
+	}
+
+	accounts, err := ledger(r).FetchAllAccounts()
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, a := range accounts {
+		data.Accounts = append(data.Accounts, convertAccount(&a))
+	}
+
+	if r.Method == http.MethodPost {
+		handleWebhookAction(r, &data, accounts)
+	} else if edit := r.URL.Query().Get("edit"); len(edit) > 0 {
+		id, _ := strconv.Atoi(edit)
+		h, err := db.GetWebhook(id)
+		if err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+		} else if h == nil {
+			data.Error = fmt.Sprintf("No webhook with id %s.", edit)
+		} else {
+			data.Edit = convertWebhook(h, accounts)
+		}
+	}
+
+	hooks, err := db.FetchAllWebhooks()
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	names := map[int]string{}
+	for _, h := range hooks {
+		data.Webhooks = append(data.Webhooks, convertWebhook(&h, accounts))
+		names[h.Id] = h.Name
+	}
+
+	deliveries, err := db.FetchDeliveries(deliveriesShown)
+	if err != nil {
+		data.Error = fmt.Sprintf("%s", err)
+	}
+	for _, d := range deliveries {
+		status := d.Error
+		if d.Delivered {
+			status = strconv.Itoa(d.StatusCode)
+		}
+		data.Deliveries = append(data.Deliveries, DeliveryData{
+			Time:      d.Time.Format("Mon 02 Jan 15:04:05"),
+			Webhook:   names[d.WebhookId],
+			Event:     d.Event,
+			Attempt:   d.Attempt,
+			Status:    status,
+			Delivered: d.Delivered,
+		})
+	}
+
+	renderPage(w, r, "webhooks/webhooks_main_tmpl.html", data)
+}
+
+func handleWebhookAction(r *http.Request, data *WebhooksMain, accounts []db.Account) {
+	switch r.FormValue("action") {
+	case "save":
+		h, err := webhookFromForm(r)
+		if err != nil {
+			data.Edit = convertWebhook(&h, accounts)
+			data.Error = fmt.Sprintf("%s", err)
+			return
+		}
+
+		if h.Id == 0 {
+			err = db.Insert(&h)
+		} else {
+			err = db.Update(&h)
+		}
+		if err != nil {
+			data.Edit = convertWebhook(&h, accounts)
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+			return
+		}
+
+		data.Message = fmt.Sprintf("Saved webhook %s.", h.Name)
+	case "delete":
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		if err := db.Delete(&db.Webhook{Id: id}); err != nil {
+			data.Error = fmt.Sprintf("%s", err)
+			log.Printf("Error: %s\n", data.Error)
+			return
+		}
+
+		data.Message = "Deleted webhook."
+	case "test":
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		h, err := db.GetWebhook(id)
+		if err != nil || h == nil {
+			data.Error = fmt.Sprintf("No webhook with id %d.", id)
+			return
+		}
+
+		// A test goes through the queue like any other event, so the page
+		// doesn't wait on the webhook's address while holding the ledger.
+		queueDelivery(newDelivery(*h, WebhookTest, map[string]any{"message": "This is a test from sacmoney."}))
+		data.Message = fmt.Sprintf("Sent a test to %s. Reload the page to see how it went in the delivery log.", h.Name)
+	}
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	db "tjdickerson/sacmoney/pkg/database"
+)
+
+const testSecret = "s3cret"
+
+var startWebhooksOnce sync.Once
+
+// received is one request a test receiver got.
+type received struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver answers each request with the next status in statuses,
+// repeating the last one, and records what it got.
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []received
+	arrived  chan struct{}
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	rec := &webhookReceiver{arrived: make(chan struct{}, 100)}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, received{time.Now(), r.Header.Clone(), body})
+		status := statuses[min(len(rec.requests), len(statuses))-1]
+		rec.mu.Unlock()
+
+		w.WriteHeader(status)
+		rec.arrived <- struct{}{}
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// wait blocks until n requests have arrived.
+func (rec *webhookReceiver) wait(t *testing.T, n int) []received {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-rec.arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got %d webhook requests, want %d", i, n)
+		}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]received{}, rec.requests...)
+}
+
+// webhookServer is testServer with the delivery worker running, retries
+// that come quickly, and a webhook pointed at url.
+func webhookServer(t *testing.T, url string, events string) (http.Handler, *http.Cookie, *db.Webhook) {
+	handler, cookie := testServer(t)
+
+	saved := webhookBackoff
+	webhookBackoff = 20 * time.Millisecond
+	t.Cleanup(func() { webhookBackoff = saved })
+	startWebhooksOnce.Do(startWebhooks)
+
+	hook := &db.Webhook{Name: "Receiver", Url: url, Secret: testSecret, Events: events, Enabled: true}
+	if err := db.Insert(hook); err != nil {
+		t.Fatal(err)
+	}
+	return handler, cookie, hook
+}
+
+// deliveriesOf waits for n attempts of the delivery to be logged and returns
+// them, oldest first.
+func deliveriesOf(t *testing.T, deliveryId string, n int) []db.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ledgerLock.RLock()
+		all, err := db.FetchDeliveries(100)
+		ledgerLock.RUnlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var found []db.WebhookDelivery
+		for i := len(all) - 1; i >= 0; i-- {
+			if all[i].DeliveryId == deliveryId {
+				found = append(found, all[i])
+			}
+		}
+
+		if len(found) >= n || time.Now().After(deadline) {
+			return found
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookSignedAndRetried(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	handler, cookie, hook := webhookServer(t, rec.URL, WebhookTransactionCreated)
+
+	account := createAccount(t, handler, cookie, "Checking")
+	body := fmt.Sprintf(`{"accountId":%d,"name":"Coffee","amount":-450,"date":"%s"}`, account, currentPeriod().Start().Format("2006-01-02"))
+	if w := serve(handler, cookie, http.MethodPost, ApiPrefix+"/transactions", body); w.Code != http.StatusCreated {
+		t.Fatalf("Creating a transaction: %d %s", w.Code, w.Body)
+	}
+
+	requests := rec.wait(t, 3)
+	deliveryId := requests[0].header.Get("X-Sacmoney-Delivery")
+
+	for i, req := range requests {
+		timestamp := req.header.Get("X-Sacmoney-Timestamp")
+		mac := hmac.New(sha256.New, []byte(testSecret))
+		mac.Write([]byte(timestamp + "." + string(req.body)))
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get(signatureHeader) != want {
+			t.Errorf("Attempt %d is signed %q, want %q", i+1, req.header.Get(signatureHeader), want)
+		}
+
+		if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || req.at.Unix()-sent > 1 || sent > req.at.Unix() {
+			t.Errorf("Attempt %d has timestamp %q, arrived at %d", i+1, timestamp, req.at.Unix())
+		}
+
+		if req.header.Get("X-Sacmoney-Event") != WebhookTransactionCreated || req.header.Get("X-Sacmoney-Delivery") != deliveryId {
+			t.Errorf("Attempt %d is event %q delivery %q", i+1, req.header.Get("X-Sacmoney-Event"), req.header.Get("X-Sacmoney-Delivery"))
+		}
+
+		var payload struct {
+			Id    string
+			Event string
+			Data  struct {
+				Transaction TransactionResource
+			}
+		}
+		if err := json.Unmarshal(req.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Id != deliveryId || payload.Data.Transaction.Name != "Coffee" || payload.Data.Transaction.Amount != -450 {
+			t.Errorf("Attempt %d sent %s", i+1, req.body)
+		}
+	}
+
+	// The wait doubles each time.
+	for i := 1; i < len(requests); i++ {
+		want := webhookBackoff << (i - 1)
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("Attempt %d came %s after the one before, want at least %s", i+1, gap, want)
+		}
+	}
+
+	log := deliveriesOf(t, deliveryId, 3)
+	if len(log) != 3 {
+		t.Fatalf("The delivery log has %d attempts, want 3", len(log))
+	}
+	for i, want := range []struct {
+		status    int
+		delivered bool
+	}{{500, false}, {502, false}, {200, true}} {
+		d := log[i]
+		if d.Attempt != i+1 || d.StatusCode != want.status || d.Delivered != want.delivered || d.WebhookId != hook.Id || d.Event != WebhookTransactionCreated {
+			t.Errorf("Logged attempt %d as %+v", i+1, d)
+		}
+		if !d.Delivered && len(d.Error) == 0 {
+			t.Errorf("Logged attempt %d failed without saying why", i+1)
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	_, _, hook := webhookServer(t, rec.URL, WebhookTest)
+
+	queueDelivery(newDelivery(*hook, WebhookTest, nil))
+	requests := rec.wait(t, webhookAttempts)
+
+	// Give a sixth attempt time to come, if it were going to.
+	time.Sleep(webhookBackoff << webhookAttempts)
+	rec.mu.Lock()
+	count := len(rec.requests)
+	rec.mu.Unlock()
+	if count != webhookAttempts {
+		t.Errorf("Got %d attempts, want %d", count, webhookAttempts)
+	}
+
+	log := deliveriesOf(t, requests[0].header.Get("X-Sacmoney-Delivery"), webhookAttempts)
+	if len(log) != webhookAttempts || log[len(log)-1].Delivered || log[len(log)-1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("The delivery log has %+v", log)
+	}
+}
+
+// TestWebhookTestDoesntWait has the receiver hang and checks the page's Test
+// button answers anyway, with the ledger free for everyone else.
+func TestWebhookTestDoesntWait(t *testing.T) {
+	release := make(chan struct{})
+	deliveryIds := make(chan string, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveryIds <- r.Header.Get("X-Sacmoney-Delivery")
+		<-release
+	}))
+	defer slow.Close()
+
+	handler, cookie, hook := webhookServer(t, slow.URL, WebhookTest)
+
+	form := url.Values{"action": {"test"}, "id": {fmt.Sprint(hook.Id)}, csrfFieldName: {pageToken(cookie)}}
+	done := make(chan string)
+	go func() {
+		w := serve(handler, cookie, http.MethodPost, "/webhooks", form.Encode(), "Content-Type", "application/x-www-form-urlencoded")
+		done <- w.Body.String()
+	}()
+
+	select {
+	case body := <-done:
+		if !strings.Contains(body, "Sent a test to Receiver") {
+			t.Errorf("The page didn't say the test was sent:\n%s", body)
+		}
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatal("The page waited for the webhook to answer")
+	}
+
+	// The test still goes out, and is logged once the receiver answers.
+	var deliveryId string
+	select {
+	case deliveryId = <-deliveryIds:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The test was never sent")
+	}
+	close(release)
+
+	if log := deliveriesOf(t, deliveryId, 1); len(log) != 1 || !log[0].Delivered || log[0].Event != WebhookTest {
+		t.Errorf("The delivery log has %+v", log)
+	}
+}
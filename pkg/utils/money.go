@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in whole cents. Adding, subtracting and comparing it is
+// exact, and it is only ever turned into dollars as text, never through a
+// float. It is stored in SQLite as an integer and sent in JSON as a number
+// of cents.
+type Money int64
+
+// ParseMoney reads amounts the way banks write them: an optional sign or
+// currency symbol, thousands separators, at most two decimal places, and
+// parentheses for negative values.
+func ParseMoney(amount string) (Money, error) {
+	clean := strings.TrimSpace(amount)
+	negative := false
+
+	if strings.HasPrefix(clean, "(") && strings.HasSuffix(clean, ")") {
+		negative = true
+		clean = clean[1 : len(clean)-1]
+	}
+
+	clean = strings.ReplaceAll(clean, "$", "")
+	clean = strings.ReplaceAll(clean, ",", "")
+	clean = strings.ReplaceAll(clean, " ", "")
+
+	if strings.HasPrefix(clean, "-") {
+		negative = !negative
+		clean = clean[1:]
+	} else if strings.HasPrefix(clean, "+") {
+		clean = clean[1:]
+	}
+
+	if len(clean) == 0 {
+		return 0, errors.New("Empty amount.")
+	}
+
+	whole, fraction, hasFraction := strings.Cut(clean, ".")
+	if !digits(whole) || !digits(fraction) || (hasFraction && (len(fraction) == 0 || len(fraction) > 2)) {
+		return 0, fmt.Errorf("Invalid amount %q.", amount)
+	}
+
+	if len(whole) == 0 {
+		whole = "0"
+	}
+
+	for len(fraction) < 2 {
+		fraction = fraction + "0"
+	}
+
+	// Negative amounts are parsed as such so the smallest one still fits.
+	if negative {
+		whole = "-" + whole
+		fraction = "-" + fraction
+	}
+
+	dollars, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid amount %q.", amount)
+	}
+
+	cents, _ := strconv.ParseInt(fraction, 10, 64)
+	if dollars > math.MaxInt64/100 || dollars < math.MinInt64/100 ||
+		(cents > 0 && dollars*100 > math.MaxInt64-cents) || (cents < 0 && dollars*100 < math.MinInt64-cents) {
+		return 0, fmt.Errorf("Amount %q is too large.", amount)
+	}
+
+	return Money(dollars*100 + cents), nil
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Abs is the amount without its sign. The smallest amount has no positive
+// counterpart, so it gives the largest one instead of staying negative.
+func (m Money) Abs() Money {
+	if m == math.MinInt64 {
+		return math.MaxInt64
+	}
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Div splits the amount n ways, dropping any fraction of a cent.
+func (m Money) Div(n int) Money {
+	return m / Money(n)
+}
+
+// String writes the amount as dollars with two decimal places, such as
+// -1234.05, which is also what ParseMoney reads back.
+func (m Money) String() string {
+	sign := ""
+	magnitude := uint64(m)
+	if m < 0 {
+		sign = "-"
+		magnitude = -magnitude
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, magnitude/100, magnitude%100)
+}
+
+// Dollars is the amount with a dollar sign, such as -$4.50.
+func (m Money) Dollars() string {
+	s := m.String()
+	if m < 0 {
+		return "-$" + s[1:]
+	}
+	return "$" + s
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(m), 10), nil
+}
+
+// UnmarshalJSON takes a whole number of cents, or a string in dollars such
+// as "-4.50".
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := ParseMoney(s)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	cents, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid amount %s, expected whole cents.", data)
+	}
+	*m = Money(cents)
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// Scan reads a column of cents. NULL, as from a sum over no rows, is zero.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		if v != float64(int64(v)) {
+			return fmt.Errorf("Amount %v isn't a whole number of cents.", v)
+		}
+		*m = Money(v)
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("Can't read an amount from %T.", src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	cents, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid amount %s in the database.", s)
+	}
+	*m = Money(cents)
+	return nil
+}
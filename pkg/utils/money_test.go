@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// edgeAmounts are the amounts most likely to go wrong: zero, a cent either
+// way, negatives under a dollar, whole dollars, and the ends of int64.
+var edgeAmounts = []Money{
+	0, 1, -1, 5, -5, 50, -50, 99, -99, 100, -100, 101, -101, 123405, -123405,
+	math.MaxInt64, math.MaxInt64 - 1, math.MinInt64, math.MinInt64 + 1,
+}
+
+// moneyAmounts is edgeAmounts and a spread of random ones, with a fixed seed
+// so a failure can be run again.
+func moneyAmounts() []Money {
+	amounts := append([]Money{}, edgeAmounts...)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		amounts = append(amounts, Money(random.Uint64()), Money(random.Int63n(100000)-50000))
+	}
+	return amounts
+}
+
+func TestMoneyStringRoundTrip(t *testing.T) {
+	for _, m := range moneyAmounts() {
+		parsed, err := ParseMoney(m.String())
+		if err != nil {
+			t.Errorf("%d: ParseMoney(%q): %s", int64(m), m.String(), err)
+			continue
+		}
+		if parsed != m {
+			t.Errorf("%d: ParseMoney(%q) is %d", int64(m), m.String(), int64(parsed))
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		amount Money
+		text   string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{-1, "-0.01"},
+		{-5, "-0.05"},
+		{-50, "-0.50"},
+		{-99, "-0.99"},
+		{-100, "-1.00"},
+		{-123405, "-1234.05"},
+		{math.MaxInt64, "92233720368547758.07"},
+		{math.MinInt64, "-92233720368547758.08"},
+	}
+
+	for _, test := range tests {
+		if s := test.amount.String(); s != test.text {
+			t.Errorf("%d: String() is %q, want %q", int64(test.amount), s, test.text)
+		}
+	}
+}
+
+func TestMoneyAbs(t *testing.T) {
+	tests := []struct {
+		amount Money
+		abs    Money
+	}{
+		{0, 0},
+		{1, 1},
+		{-1, 1},
+		{-123405, 123405},
+		{math.MaxInt64, math.MaxInt64},
+		{math.MinInt64 + 1, math.MaxInt64},
+		{math.MinInt64, math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		if abs := test.amount.Abs(); abs != test.abs {
+			t.Errorf("%d: Abs() is %d, want %d", int64(test.amount), int64(abs), int64(test.abs))
+		}
+	}
+
+	for _, m := range moneyAmounts() {
+		if m.Abs() < 0 {
+			t.Errorf("%d: Abs() is negative", int64(m))
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text   string
+		amount Money
+		fails  bool
+	}{
+		{"-0.05", -5, false},
+		{"-.05", -5, false},
+		{"(0.50)", -50, false},
+		{"($0.99)", -99, false},
+		{"-$0.01", -1, false},
+		{"(-0.50)", 50, false},
+		{"$1,234.5", 123450, false},
+		{"+4", 400, false},
+		{"92233720368547758.07", math.MaxInt64, false},
+		{"-92233720368547758.08", math.MinInt64, false},
+		{"92233720368547758.08", 0, true},
+		{"-92233720368547758.09", 0, true},
+		{"1.005", 0, true},
+		{"1.", 0, true},
+		{"-", 0, true},
+		{"", 0, true},
+		{"4.5x", 0, true},
+	}
+
+	for _, test := range tests {
+		amount, err := ParseMoney(test.text)
+		if failed := err != nil; failed != test.fails {
+			t.Errorf("%q: error %v, wanted failure %v", test.text, err, test.fails)
+		}
+		if !test.fails && amount != test.amount {
+			t.Errorf("%q: amount %d, want %d", test.text, int64(amount), int64(test.amount))
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	for _, m := range moneyAmounts() {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("%d: %s", int64(m), err)
+		}
+
+		var back Money
+		if err = json.Unmarshal(data, &back); err != nil {
+			t.Errorf("%d: Unmarshal(%s): %s", int64(m), data, err)
+			continue
+		}
+		if back != m {
+			t.Errorf("%d: Unmarshal(%s) is %d", int64(m), data, int64(back))
+		}
+
+		// Dollars in a string read back the same.
+		quoted, _ := json.Marshal(m.String())
+		if err = json.Unmarshal(quoted, &back); err != nil || back != m {
+			t.Errorf("%d: Unmarshal(%s) is %d, %v", int64(m), quoted, int64(back), err)
+		}
+	}
+
+	var m Money = 7
+	if err := json.Unmarshal([]byte("null"), &m); err != nil || m != 7 {
+		t.Errorf("Unmarshal(null) changed the amount to %d, %v", int64(m), err)
+	}
+	for _, bad := range []string{"4.5", `"4.505"`, "9223372036854775808", "true"} {
+		if err := json.Unmarshal([]byte(bad), &m); err == nil {
+			t.Errorf("Unmarshal(%s) didn't fail", bad)
+		}
+	}
+}
+
+func TestMoneySqlRoundTrip(t *testing.T) {
+	for _, m := range moneyAmounts() {
+		value, err := m.Value()
+		if err != nil {
+			t.Fatalf("%d: %s", int64(m), err)
+		}
+		if _, ok := value.(int64); !ok {
+			t.Fatalf("%d: Value() is a %T, want int64", int64(m), value)
+		}
+
+		var back Money
+		if err = back.Scan(value); err != nil || back != m {
+			t.Errorf("%d: Scan(%v) is %d, %v", int64(m), value, int64(back), err)
+		}
+
+		// SQLite can hand an integer column back as text.
+		text := strconv.FormatInt(value.(int64), 10)
+		if err = back.Scan(text); err != nil || back != m {
+			t.Errorf("%d: Scan(%q) is %d, %v", int64(m), text, int64(back), err)
+		}
+		if err = back.Scan([]byte(text)); err != nil || back != m {
+			t.Errorf("%d: Scan([]byte(%q)) is %d, %v", int64(m), text, int64(back), err)
+		}
+	}
+
+	tests := []struct {
+		src    any
+		amount Money
+		fails  bool
+	}{
+		{nil, 0, false},
+		{float64(-5), -5, false},
+		{float64(-450), -450, false},
+		{4.5, 0, true},
+		{"4.50", 0, true},
+		{"9223372036854775808", 0, true},
+		{true, 0, true},
+	}
+
+	for _, test := range tests {
+		var m Money = 7
+		err := m.Scan(test.src)
+		if failed := err != nil; failed != test.fails {
+			t.Errorf("Scan(%#v): error %v, wanted failure %v", test.src, err, test.fails)
+		}
+		if !test.fails && m != test.amount {
+			t.Errorf("Scan(%#v) is %d, want %d", test.src, int64(m), int64(test.amount))
+		}
+	}
+}
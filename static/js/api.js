@@ -1,19 +1,38 @@
 
-function add_transaction() {
+function add_transaction(duplicate) {
 	const trans_date = document.getElementById("input-trans-date").value;
 	const trans_name = document.getElementById("input-trans-name").value;
 	const trans_amount = document.getElementById("input-trans-amount").value;
 
 	post("/saveTransaction",
-		(rt) => { after_post(rt) },
+		(rt) => { after_add_transaction(rt) },
 		{
 			id: "0",
 			date: trans_date,
 			name: trans_name,
 			amount: trans_amount,
+			duplicate: duplicate || "",
 		});
 }
 
+function after_add_transaction(result) {
+	const prefix = "DUPLICATE:";
+	if (!result.startsWith(prefix)) {
+		after_post(result);
+		return;
+	}
+
+	document.getElementById("duplicate-text").innerText = result.substring(prefix.length);
+	document.getElementById("duplicate-prompt").style.display = "flex";
+}
+
+function resolve_duplicate(action) {
+	document.getElementById("duplicate-prompt").style.display = "none";
+	if (action !== "skip") {
+		add_transaction(action);
+	}
+}
+
 function save_transaction(sender) {
 	const trn_id = sender.getAttribute("tid");
 	const trans_name = document.getElementById(`edit-trans-name_${trn_id}`).value;
@@ -61,11 +80,13 @@ function save_recurring_transaction(sender) {
 
 function add_account() {
 	const account_name = document.getElementById("input-account-name").value;
+	const account_kind = document.getElementById("input-account-kind").value;
 
 	post("/addAccount",
 		(rt) => { after_post(rt); },
 		{
 			name: account_name,
+			kind: account_kind,
 		});
 }
 
@@ -77,6 +98,28 @@ function rollover() {
 		});
 }
 
+function undo_rollover(confirmed) {
+	post("/undoRollover",
+		(rt) => {
+			// A month that already has transactions is only removed once
+			// that has been confirmed.
+			if (rt.startsWith("CONFIRM:")) {
+				if (window.confirm(rt.substring("CONFIRM:".length))) {
+					undo_rollover(true);
+				}
+				return;
+			}
+			after_post(rt);
+		},
+		{ Confirm: confirmed });
+}
+
+function carry_balances() {
+	post("/carryBalances",
+		(rt) => { after_post(rt); },
+		{});
+}
+
 function apply_recurring_transaction(sender) {
 	const recurr_id = sender.getAttribute("rid");
 
@@ -163,17 +206,41 @@ function cancel_row(sender) {
 }
 
 
+/**
+ * The page's CSRF token, which every post has to send back.
+ * @returns {string}
+ * */
+function csrf_token() {
+	const input = document.querySelector('input[name="csrf_token"]');
+	return input ? input.value : "";
+}
+
+/**
+ * The page's view, such as "?account=2", which its posts carry on so they
+ * act on what the page shows.
+ * @returns {string}
+ * */
+function view_query() {
+	const input = document.getElementById("view-query");
+	return input ? input.value : "";
+}
+
 /**
  * @param {string} uri
  * @param {function} callback
  * */
 function request(uri, callback) {
 	const xhr = new XMLHttpRequest();
-	xhr.open("POST", uri);
+	xhr.open("POST", uri + view_query());
 	xhr.setRequestHeader("Content-Type", "application/text");
+	xhr.setRequestHeader("X-CSRF-Token", csrf_token());
 	xhr.onload = () => {
 		if (xhr.readyState == 4 && xhr.status == 200) {
 			callback(xhr.responseText);
+		} else if (xhr.status == 401) {
+			window.location.href = "/login?next=" + encodeURIComponent(window.location.pathname + window.location.search);
+		} else if (xhr.status == 403) {
+			show_error(xhr.responseText);
 		} else {
 			console.error(`Request failed: ${xhr.status}`);
 		}
@@ -188,11 +255,16 @@ function request(uri, callback) {
  * */
 function post(uri, callback, data) {
 	const xhr = new XMLHttpRequest();
-	xhr.open("POST", uri);
+	xhr.open("POST", uri + view_query());
 	xhr.setRequestHeader("Content-Type", "application/json;charset=UTF-8");
+	xhr.setRequestHeader("X-CSRF-Token", csrf_token());
 	xhr.onload = () => {
 		if (xhr.readyState == 4 && xhr.status == 200) {
 			callback(xhr.responseText);
+		} else if (xhr.status == 401) {
+			window.location.href = "/login?next=" + encodeURIComponent(window.location.pathname + window.location.search);
+		} else if (xhr.status == 403) {
+			show_error(xhr.responseText);
 		} else {
 			console.error(`Post failed: ${xhr.status}`);
 		}
@@ -205,7 +277,16 @@ function post(uri, callback, data) {
 
 function page_load_transactions() {
 	const input_date = document.getElementById("input-trans-date");
-	input_date.valueAsDate = new Date();
+	if (!input_date) {
+		// Viewers don't get the new transaction form.
+		return;
+	}
+	// An earlier month starts on its last day rather than today.
+	if (input_date.dataset.default) {
+		input_date.value = input_date.dataset.default;
+	} else {
+		input_date.valueAsDate = new Date();
+	}
 
 	const input_name = document.getElementById("input-trans-name");
 	const input_amount = document.getElementById("input-trans-amount");
@@ -222,6 +303,9 @@ function page_load_recurrings() {
 	const input_date = document.getElementById("input-recurring-date");
 	const input_name = document.getElementById("input-recurring-name");
 	const input_amount = document.getElementById("input-recurring-amount");
+	if (!input_amount) {
+		return;
+	}
 
 	input_date.value = "";
 	input_name.value = "";
@@ -232,7 +316,11 @@ function page_load_recurrings() {
 	set_default_button(input_amount);
 }
 
-function page_load_accounts() {
+function page_load_accounts(error) {
+	if (error) {
+		show_error(error);
+	}
+
 	const input_name = document.getElementById("input-account-name");
 
 	input_name.value = "";
@@ -241,6 +329,15 @@ function page_load_accounts() {
 	set_default_button(input_name);
 }
 
+/**
+* @param {string} error
+**/
+function page_load_reports(error) {
+	if (error) {
+		show_error(error);
+	}
+}
+
 /**
 * @param {HTMLElement} target_el
 **/
@@ -254,3 +351,58 @@ function set_default_button(target_el) {
 	});
 }
 
+
+/**
+ * Keeps the parts of the page marked data-live up to date with changes made
+ * in other tabs and on other devices. Each change fetches the page again and
+ * swaps those parts in, leaving alone any being edited.
+ * */
+let live_timer = null;
+
+function start_live_updates() {
+	if (!window.EventSource || !document.querySelector("[data-live]")) {
+		return;
+	}
+
+	const source = new EventSource("/events");
+	source.onmessage = (event) => {
+		const change = JSON.parse(event.data);
+		if (change.type.startsWith("rollover")) {
+			// The month changed under the page, so everything on it did.
+			window.location.reload();
+			return;
+		}
+
+		clearTimeout(live_timer);
+		live_timer = setTimeout(refresh_live, 250);
+	};
+
+	// Sent when the server can no longer say what the page missed.
+	source.addEventListener("reset", () => { window.location.reload(); });
+}
+
+function refresh_live() {
+	const xhr = new XMLHttpRequest();
+	xhr.open("GET", window.location.pathname + window.location.search);
+	xhr.responseType = "document";
+	xhr.onload = () => {
+		if (xhr.status != 200 || !xhr.responseXML) {
+			console.error(`Refresh failed: ${xhr.status}`);
+			return;
+		}
+
+		document.querySelectorAll("[data-live]").forEach((region) => {
+			if (region.contains(document.activeElement) || region.querySelector(".editing")) {
+				return;
+			}
+
+			const fresh = xhr.responseXML.getElementById(region.id);
+			if (fresh) {
+				region.replaceWith(document.importNode(fresh, true));
+			}
+		});
+	}
+	xhr.send();
+}
+
+window.addEventListener("load", start_live_updates);